@@ -0,0 +1,73 @@
+package xPlane
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// NodeCapacity is the CPU, in millicores, and the memory, in MiB, a node of the cluster has for dataplanes.
+type NodeCapacity struct {
+	CPU    float64 `json:"cpu" yaml:"cpu"`
+	Memory float64 `json:"memory" yaml:"memory"`
+}
+
+// Pod is a replica of a service, running on a node of the cluster.
+type Pod struct {
+	Name    string `json:"name" yaml:"name"`
+	Service string `json:"service" yaml:"service"`
+	Node    string `json:"node" yaml:"node"`
+}
+
+// Cluster is where the services of the application graph run: the nodes, their budget for dataplanes,
+// and the pods of every service.
+type Cluster struct {
+	Nodes map[string]NodeCapacity `json:"nodes" yaml:"nodes"`
+	Pods  []Pod                   `json:"pods" yaml:"pods"`
+}
+
+// Get the names of the nodes, sorted.
+func (c *Cluster) GetNodes() []string {
+	nodes := make([]string, 0, len(c.Nodes))
+	for node := range c.Nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Get the pods of a service.
+func (c *Cluster) GetPods(service string) []Pod {
+	var pods []Pod
+	for _, pod := range c.Pods {
+		if pod.Service == service {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// Validate checks that every pod runs on a node of the cluster, and that no node has a negative budget.
+func (c *Cluster) Validate() error {
+	var errs []error
+
+	for _, node := range c.GetNodes() {
+		if capacity := c.Nodes[node]; capacity.CPU < 0 || capacity.Memory < 0 {
+			errs = append(errs, fmt.Errorf("negative capacity for node %s", node))
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, pod := range c.Pods {
+		if names[pod.Name] {
+			errs = append(errs, fmt.Errorf("duplicate pod %s", pod.Name))
+		}
+		names[pod.Name] = true
+
+		if _, ok := c.Nodes[pod.Node]; !ok {
+			errs = append(errs, fmt.Errorf("pod %s runs on unknown node %s", pod.Name, pod.Node))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,41 @@
+package xPlane
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestCluster(t *testing.T) {
+	flag.Parse()
+
+	c := &Cluster{
+		Nodes: map[string]NodeCapacity{"node-2": {CPU: 1000, Memory: 512}, "node-1": {CPU: 2000, Memory: 1024}},
+		Pods: []Pod{
+			{Name: "frontend-0", Service: "frontend", Node: "node-1"},
+			{Name: "frontend-1", Service: "frontend", Node: "node-2"},
+			{Name: "search-0", Service: "search", Node: "node-2"},
+		},
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected a valid cluster, got %v", err)
+	}
+	if nodes := c.GetNodes(); !reflect.DeepEqual(nodes, []string{"node-1", "node-2"}) {
+		t.Errorf("Expected sorted nodes, got %v", nodes)
+	}
+	if pods := c.GetPods("frontend"); len(pods) != 2 || pods[1].Node != "node-2" {
+		t.Errorf("Expected 2 frontend pods, got %v", pods)
+	}
+
+	c.Pods = append(c.Pods, Pod{Name: "search-0", Service: "search", Node: "node-3"})
+	c.Nodes["node-4"] = NodeCapacity{CPU: -1}
+	if err := c.Validate(); err == nil {
+		t.Errorf("Expected errors for the duplicate pod, the unknown node and the negative capacity")
+	}
+
+	// Every instance uses its idle CPU plus its share of the requests.
+	r := ResourceProfile{CPUPerRequest: 0.1, CPUPerInstance: 5, MemoryPerInstance: 50}
+	if cpu, memory := r.InstanceUsage(4, 200); cpu != 10 || memory != 50 {
+		t.Errorf("Expected 10 millicores and 50 MiB, got %g and %g", cpu, memory)
+	}
+}
@@ -0,0 +1,526 @@
+package xPlane
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Policy contexts describe the request paths a policy applies to, as a sequence of hops separated by `->`:
+//
+//	context   := hop ( "->" hop )*
+//	hop       := [ "!" ] atom [ "?" ]
+//	atom      := name                      a single service
+//	           | "[" name ( "," name )* "]"  any service of a set
+//	           | "{" key "=" value ( "," key "=" value )* "}"  any service with all the labels
+//	           | "."                       any single service
+//	           | "*" | ".*"                any sequence of services, possibly empty
+//
+// `!` negates a single-service hop (any one service that does not match), and `?` makes a hop optional.
+// A context matches a request path if the whole path is matched by the hops, e.g. `frontend->*->rate`
+// matches `frontend,search,rate` and `frontend,rate`.
+
+type HopKind int
+
+const (
+	// A single service, or a set of services.
+	HOP_SERVICES HopKind = iota
+	// Any service with the given labels.
+	HOP_LABELS
+	// Any single service.
+	HOP_ANY
+	// Any sequence of services, possibly empty.
+	HOP_ANY_PATH
+)
+
+// ContextHop is a hop in a policy context.
+type ContextHop struct {
+	Kind     HopKind
+	Services []string
+	Labels   map[string]string
+	Negated  bool
+	Optional bool
+}
+
+// PolicyContext is the parsed form of a policy context.
+type PolicyContext struct {
+	Hops []ContextHop
+
+	// Returns the labels of a service, used to match label selectors.
+	labels func(service string) map[string]string
+}
+
+// ContextSyntaxError is returned when a policy context cannot be parsed.
+type ContextSyntaxError struct {
+	Input string
+	Pos   int
+	Msg   string
+}
+
+func (e *ContextSyntaxError) Error() string {
+	return fmt.Sprintf("invalid context %q at position %d: %s", e.Input, e.Pos, e.Msg)
+}
+
+// Characters allowed in service names, label keys and label values.
+func isNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '_' || c == '.' || c == ':' || c == '/'
+}
+
+type contextParser struct {
+	input string
+	pos   int
+}
+
+func (p *contextParser) errorf(format string, args ...interface{}) error {
+	return &ContextSyntaxError{Input: p.input, Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *contextParser) skipSpaces() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *contextParser) peek() byte {
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *contextParser) name() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) && !strings.HasPrefix(p.input[p.pos:], "->") {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected a name")
+	}
+	return p.input[start:p.pos], nil
+}
+
+// Parse a comma separated list of items enclosed by open and close.
+func (p *contextParser) list(open byte, close byte, item func() error) error {
+	if p.peek() != open {
+		return p.errorf("expected %q", open)
+	}
+	p.pos++
+
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+
+		p.skipSpaces()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case close:
+			p.pos++
+			return nil
+		default:
+			return p.errorf("expected %q or %q", ',', close)
+		}
+	}
+}
+
+func (p *contextParser) hop() (ContextHop, error) {
+	var hop ContextHop
+
+	p.skipSpaces()
+	if p.peek() == '!' {
+		hop.Negated = true
+		p.pos++
+		p.skipSpaces()
+	}
+
+	start := p.pos
+	switch {
+	case p.peek() == '[':
+		hop.Kind = HOP_SERVICES
+		err := p.list('[', ']', func() error {
+			svc, err := p.name()
+			if err != nil {
+				return err
+			}
+			hop.Services = append(hop.Services, svc)
+			return nil
+		})
+		if err != nil {
+			return hop, err
+		}
+
+	case p.peek() == '{':
+		hop.Kind = HOP_LABELS
+		hop.Labels = make(map[string]string)
+		err := p.list('{', '}', func() error {
+			key, err := p.name()
+			if err != nil {
+				return err
+			}
+			p.skipSpaces()
+			if p.peek() != '=' {
+				return p.errorf("expected '=' after label %s", key)
+			}
+			p.pos++
+			value, err := p.name()
+			if err != nil {
+				return err
+			}
+			if _, ok := hop.Labels[key]; ok {
+				return p.errorf("duplicate label %s", key)
+			}
+			hop.Labels[key] = value
+			return nil
+		})
+		if err != nil {
+			return hop, err
+		}
+
+	case p.peek() == '*':
+		hop.Kind = HOP_ANY_PATH
+		p.pos++
+
+	case strings.HasPrefix(p.input[p.pos:], ".*"):
+		hop.Kind = HOP_ANY_PATH
+		p.pos += 2
+
+	default:
+		name, err := p.name()
+		if err != nil {
+			return hop, err
+		}
+
+		if name == "." {
+			hop.Kind = HOP_ANY
+		} else if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+			p.pos = start
+			return hop, p.errorf("invalid service name %s", name)
+		} else {
+			hop.Kind = HOP_SERVICES
+			hop.Services = []string{name}
+		}
+	}
+
+	p.skipSpaces()
+	if p.peek() == '?' {
+		hop.Optional = true
+		p.pos++
+	}
+
+	if hop.Kind == HOP_ANY_PATH && (hop.Negated || hop.Optional) {
+		p.pos = start
+		return hop, p.errorf("a multi-hop wildcard cannot be negated or optional")
+	}
+	if hop.Kind == HOP_ANY && hop.Negated {
+		p.pos = start
+		return hop, p.errorf("a single-hop wildcard cannot be negated")
+	}
+
+	return hop, nil
+}
+
+// ParseContext parses a policy context such as `frontend->*->[rate,geo]`.
+func ParseContext(input string) (*PolicyContext, error) {
+	p := contextParser{input: input}
+	c := &PolicyContext{}
+
+	for {
+		hop, err := p.hop()
+		if err != nil {
+			return nil, err
+		}
+		c.Hops = append(c.Hops, hop)
+
+		p.skipSpaces()
+		if p.pos == len(p.input) {
+			break
+		}
+		if !strings.HasPrefix(p.input[p.pos:], "->") {
+			return nil, p.errorf("expected '->'")
+		}
+		p.pos += 2
+	}
+
+	return c, nil
+}
+
+// ParseContextTokens parses a policy context stored as a list of hops, e.g. Policy.GetContext().
+func ParseContextTokens(tokens []string) (*PolicyContext, error) {
+	if len(tokens) == 0 {
+		return nil, &ContextSyntaxError{Msg: "empty context"}
+	}
+	for _, token := range tokens {
+		if strings.Contains(token, "->") {
+			return nil, &ContextSyntaxError{Input: token, Msg: "a token must be a single hop"}
+		}
+	}
+	return ParseContext(strings.Join(tokens, "->"))
+}
+
+// String prints the hop in canonical form: sets and labels are sorted, and `.*` is printed as `*`.
+func (h ContextHop) String() string {
+	var s string
+	switch h.Kind {
+	case HOP_SERVICES:
+		services := append([]string{}, h.Services...)
+		sort.Strings(services)
+		services = uniqueSorted(services)
+		if len(services) == 1 {
+			s = services[0]
+		} else {
+			s = "[" + strings.Join(services, ",") + "]"
+		}
+	case HOP_LABELS:
+		keys := make([]string, 0, len(h.Labels))
+		for k := range h.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			labels[i] = k + "=" + h.Labels[k]
+		}
+		s = "{" + strings.Join(labels, ",") + "}"
+	case HOP_ANY:
+		s = "."
+	case HOP_ANY_PATH:
+		s = "*"
+	}
+
+	if h.Negated {
+		s = "!" + s
+	}
+	if h.Optional {
+		s += "?"
+	}
+	return s
+}
+
+func uniqueSorted(values []string) []string {
+	unique := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// String prints the context in canonical form. Parsing the output gives back an equivalent context.
+func (c *PolicyContext) String() string {
+	return strings.Join(c.Tokens(), "->")
+}
+
+// Tokens returns the canonical form of every hop, the representation used by Policy.
+func (c *PolicyContext) Tokens() []string {
+	tokens := make([]string, len(c.Hops))
+	for i, h := range c.Hops {
+		tokens[i] = h.String()
+	}
+	return tokens
+}
+
+// WithLabels sets how the labels of a service are found, for label selectors.
+// Without it, label selectors do not match any service.
+func (c *PolicyContext) WithLabels(labels func(service string) map[string]string) *PolicyContext {
+	c.labels = labels
+	return c
+}
+
+// MatchesService checks if a single service matches the hop. A multi-hop wildcard matches any service.
+func (c *PolicyContext) MatchesService(h ContextHop, service string) bool {
+	var match bool
+	switch h.Kind {
+	case HOP_SERVICES:
+		for _, svc := range h.Services {
+			if svc == service {
+				match = true
+				break
+			}
+		}
+	case HOP_LABELS:
+		var labels map[string]string
+		if c.labels != nil {
+			labels = c.labels(service)
+		}
+		match = true
+		for k, v := range h.Labels {
+			if labels[k] != v {
+				match = false
+				break
+			}
+		}
+	case HOP_ANY, HOP_ANY_PATH:
+		match = true
+	}
+
+	if h.Negated {
+		return !match
+	}
+	return match
+}
+
+// ContextState is the progress of matching a request path against a context, one service at a time.
+type ContextState struct {
+	context *PolicyContext
+
+	// matched[k] is true if the services seen so far are matched by the first k hops.
+	matched []bool
+}
+
+// Start returns the state before any service is seen.
+func (c *PolicyContext) Start() *ContextState {
+	matched := make([]bool, len(c.Hops)+1)
+	matched[0] = true
+	c.closeEmpty(matched)
+	return &ContextState{context: c, matched: matched}
+}
+
+// Step returns the state after the service is seen.
+func (s *ContextState) Step(svc string) *ContextState {
+	c := s.context
+	next := make([]bool, len(c.Hops)+1)
+	for k, h := range c.Hops {
+		if !s.matched[k] && !(h.Kind == HOP_ANY_PATH && s.matched[k+1]) {
+			continue
+		}
+		if !c.MatchesService(h, svc) {
+			continue
+		}
+
+		// A multi-hop wildcard can consume the service and stay on the same hop.
+		if h.Kind == HOP_ANY_PATH {
+			next[k+1] = true
+		} else if s.matched[k] {
+			next[k+1] = true
+		}
+	}
+	c.closeEmpty(next)
+	return &ContextState{context: c, matched: next}
+}
+
+// Accepts checks if the services seen so far are matched by the context.
+func (s *ContextState) Accepts() bool {
+	return s.matched[len(s.matched)-1]
+}
+
+// Live checks if the services seen so far can still be extended to a match.
+func (s *ContextState) Live() bool {
+	for _, m := range s.matched {
+		if m {
+			return true
+		}
+	}
+	return false
+}
+
+// Key identifies the state: two states with the same key match the same extensions of their paths.
+func (s *ContextState) Key() string {
+	b := make([]byte, len(s.matched))
+	for i, m := range s.matched {
+		if m {
+			b[i] = '1'
+		} else {
+			b[i] = '0'
+		}
+	}
+	return string(b)
+}
+
+// Extend the matched states over hops that can match no service at all.
+func (c *PolicyContext) closeEmpty(matched []bool) {
+	for k, h := range c.Hops {
+		if matched[k] && (h.Optional || h.Kind == HOP_ANY_PATH) {
+			matched[k+1] = true
+		}
+	}
+}
+
+// Matches checks if the request path, a list of services from the first caller to the last callee,
+// is matched by the context.
+func (c *PolicyContext) Matches(path []string) bool {
+	s := c.Start()
+	for _, svc := range path {
+		s = s.Step(svc)
+	}
+
+	return s.Accepts()
+}
+
+// EnforcementEdges returns the last edge of every request path of the application graph matched by the context,
+// as a map from the sender to its receivers. Paths can start at any service, and cycles in the graph are allowed.
+func (c *PolicyContext) EnforcementEdges(applEdges map[string][]string) map[string][]string {
+	type node struct {
+		svc   string
+		state string
+	}
+
+	// Walk the product of the graph and the context states, visiting every pair once.
+	edges := make(map[string][]string)
+	states := make(map[node]*ContextState)
+	var queue []node
+	for _, svc := range c.StartServices(applEdges) {
+		s := c.Start().Step(svc)
+		if n := (node{svc, s.Key()}); s.Live() && states[n] == nil {
+			states[n] = s
+			queue = append(queue, n)
+		}
+	}
+
+	for len(queue) > 0 {
+		curr := queue[0]
+		queue = queue[1:]
+
+		for _, child := range applEdges[curr.svc] {
+			s := states[curr].Step(child)
+			if !s.Live() {
+				continue
+			}
+			if s.Accepts() && !slices.Contains(edges[curr.svc], child) {
+				edges[curr.svc] = append(edges[curr.svc], child)
+			}
+			if n := (node{child, s.Key()}); states[n] == nil {
+				states[n] = s
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	return edges
+}
+
+// StartServices returns the services that can start a request matching the context, sorted. These are the
+// services of the first hop if it names them, and every service of the graph otherwise.
+func (c *PolicyContext) StartServices(applEdges map[string][]string) []string {
+	if len(c.Hops) > 0 {
+		first := c.Hops[0]
+		if first.Kind == HOP_SERVICES && !first.Negated && !first.Optional {
+			services := append([]string{}, first.Services...)
+			sort.Strings(services)
+			return services
+		}
+	}
+
+	var services []string
+	seen := make(map[string]bool)
+	for svc, children := range applEdges {
+		for _, s := range append([]string{svc}, children...) {
+			if !seen[s] {
+				seen[s] = true
+				services = append(services, s)
+			}
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// Get the parsed context of the policy.
+func (p *Policy) GetContextExpr() (*PolicyContext, error) {
+	return ParseContextTokens(p.context)
+}
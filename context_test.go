@@ -0,0 +1,159 @@
+package xPlane
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseContext(t *testing.T) {
+	flag.Parse()
+
+	tests := []struct {
+		input     string
+		canonical string
+	}{
+		{"frontend->search", "frontend->search"},
+		{"frontend -> .* -> rate", "frontend->*->rate"},
+		{"*->[reserve, geo,geo]", "*->[geo,reserve]"},
+		{"frontend->.->{version=v2,app=rate}", "frontend->.->{app=rate,version=v2}"},
+		{"!frontend->search?->user", "!frontend->search?->user"},
+		{"[rate]", "rate"},
+		{"svc-a->svc_b.v1", "svc-a->svc_b.v1"},
+	}
+
+	for _, test := range tests {
+		c, err := ParseContext(test.input)
+		if err != nil {
+			t.Errorf("Error parsing %q: %v", test.input, err)
+			continue
+		}
+		if c.String() != test.canonical {
+			t.Errorf("Expected %q, got %q", test.canonical, c.String())
+		}
+
+		// The canonical form parses to the same context.
+		again, err := ParseContext(c.String())
+		if err != nil || again.String() != c.String() {
+			t.Errorf("Expected %q to round trip, got %v %v", c.String(), again, err)
+		}
+	}
+
+	c, err := ParseContextTokens([]string{"frontend", ".*", "[rate,geo]"})
+	if err != nil {
+		t.Fatalf("Error parsing tokens: %v", err)
+	}
+	if !reflect.DeepEqual(c.Tokens(), []string{"frontend", "*", "[geo,rate]"}) {
+		t.Errorf("Expected canonical tokens, got %v", c.Tokens())
+	}
+}
+
+func TestParseContextErrors(t *testing.T) {
+	flag.Parse()
+
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{"", 0},
+		{"frontend->", 10},
+		{"frontend search", 9},
+		{"[a,", 3},
+		{"[a b]", 3},
+		{"{app}", 4},
+		{"!*", 1},
+		{"*?", 0},
+		{"!.", 1},
+		{"frontend->.x", 10},
+	}
+
+	for _, test := range tests {
+		_, err := ParseContext(test.input)
+		var syntaxErr *ContextSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Expected a syntax error for %q, got %v", test.input, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos {
+			t.Errorf("Expected error at position %d for %q, got %v", test.pos, test.input, err)
+		}
+	}
+
+	if _, err := ParseContextTokens([]string{"a->b"}); err == nil {
+		t.Errorf("Expected an error for a token with several hops")
+	}
+}
+
+func TestContextMatches(t *testing.T) {
+	flag.Parse()
+
+	labels := map[string]map[string]string{
+		"rate":    {"app": "rate", "version": "v2"},
+		"profile": {"app": "profile", "version": "v2"},
+	}
+
+	tests := []struct {
+		context string
+		path    []string
+		match   bool
+	}{
+		{"frontend->search", []string{"frontend", "search"}, true},
+		{"frontend->search", []string{"frontend", "search", "geo"}, false},
+		{"frontend->*->rate", []string{"frontend", "rate"}, true},
+		{"frontend->*->rate", []string{"frontend", "search", "geo", "rate"}, true},
+		{"frontend->*->rate", []string{"frontend", "search", "geo"}, false},
+		{"frontend->.->rate", []string{"frontend", "rate"}, false},
+		{"frontend->.->rate", []string{"frontend", "search", "rate"}, true},
+		{"*->[rate,geo]", []string{"search", "geo"}, true},
+		{"*->[rate,geo]", []string{"search", "profile"}, false},
+		{"frontend->!search", []string{"frontend", "reserve"}, true},
+		{"frontend->!search", []string{"frontend", "search"}, false},
+		{"frontend->search?->geo", []string{"frontend", "geo"}, true},
+		{"frontend->search?->geo", []string{"frontend", "search", "geo"}, true},
+		{"frontend->search?->geo", []string{"frontend", "rate", "geo"}, false},
+		{"*->{version=v2}", []string{"search", "rate"}, true},
+		{"*->{version=v2,app=profile}", []string{"search", "rate"}, false},
+		{"*->{version=v2}", []string{"search", "geo"}, false},
+		{"*", []string{}, true},
+	}
+
+	for _, test := range tests {
+		c, err := ParseContext(test.context)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", test.context, err)
+		}
+		c.WithLabels(func(svc string) map[string]string { return labels[svc] })
+
+		if c.Matches(test.path) != test.match {
+			t.Errorf("Expected %q matching %v to be %v", test.context, test.path, test.match)
+		}
+	}
+}
+
+func TestEnforcementEdges(t *testing.T) {
+	flag.Parse()
+
+	// A graph with a cycle between A and B.
+	applEdges := map[string][]string{"A": {"B"}, "B": {"A", "C"}, "C": {"D"}}
+
+	tests := []struct {
+		context string
+		edges   map[string][]string
+	}{
+		{"A->*->C", map[string][]string{"B": {"C"}}},
+		{"*->[A,D]", map[string][]string{"B": {"A"}, "C": {"D"}}},
+		{"B->.", map[string][]string{"B": {"A", "C"}}},
+		{"A->B->E", map[string][]string{}},
+	}
+
+	for _, test := range tests {
+		c, err := ParseContext(test.context)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", test.context, err)
+		}
+		if edges := c.EnforcementEdges(applEdges); !reflect.DeepEqual(edges, test.edges) {
+			t.Errorf("Expected edges %v for %q, got %v", test.edges, test.context, edges)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package xPlane
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var arrayIndexRegex = regexp.MustCompile(`\.([0-9]+)`)
+
+// CopperError is returned when a Copper-compiled JSON file does not match the expected IR.
+// Path points at the offending JSON value, e.g. `groups[0].inner.Policy.matches`.
+type CopperError struct {
+	Path string
+	Err  error
+}
+
+func (e *CopperError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("copper: %v", e.Err)
+	}
+	return fmt.Sprintf("copper: %s: %v", e.Path, e.Err)
+}
+
+func (e *CopperError) Unwrap() error {
+	return e.Err
+}
+
+func copperErrorf(path string, format string, args ...interface{}) *CopperError {
+	return &CopperError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+// CopperFile is the JSON IR emitted by the Copper compiler for both interface (.cui) and policy (.cup) files.
+type CopperFile struct {
+	Imports []CopperImport `json:"imports"`
+	Groups  []CopperGroup  `json:"groups"`
+}
+
+type CopperImport struct {
+	Path string `json:"path"`
+}
+
+// CopperGroup is a top-level item of a Copper file. Exactly one of the inner variants is set.
+type CopperGroup struct {
+	Inner struct {
+		Specification *CopperSpecification `json:"Specification"`
+		Policy        *CopperPolicy        `json:"Policy"`
+	} `json:"inner"`
+}
+
+type CopperSpecification struct {
+	ActInterface *CopperActInterface `json:"ActInterface"`
+}
+
+type CopperActInterface struct {
+	Fields []CopperField `json:"fields"`
+}
+
+type CopperField struct {
+	Action *CopperAction `json:"Action"`
+}
+
+type CopperIdent struct {
+	Name string `json:"name"`
+}
+
+type CopperAction struct {
+	Name CopperIdent `json:"name"`
+	Type struct {
+		// Function is a tuple whose first element carries the annotations on `self`.
+		Function []json.RawMessage `json:"Function"`
+	} `json:"type_"`
+}
+
+// CopperAnnotation holds the placement and mutability annotations of an action.
+type CopperAnnotation struct {
+	Placement  string `json:"placement"`
+	Mutability string `json:"mutability"`
+}
+
+type CopperPolicy struct {
+	Matches            []CopperMatch           `json:"matches"`
+	UsedAbstractFields [][]CopperAbstractField `json:"used_abstract_fields"`
+}
+
+type CopperMatch struct {
+	Context *CopperContext `json:"Context"`
+}
+
+type CopperContext struct {
+	Blocks []CopperBlock `json:"blocks"`
+}
+
+type CopperBlock struct {
+	Inner struct {
+		Endpoints []CopperIdent `json:"Endpoints"`
+	} `json:"inner"`
+}
+
+// CopperAbstractField is a reference to an act field used by a policy. Only `set` references name functions.
+type CopperAbstractField struct {
+	Set []string `json:"set"`
+}
+
+// DecodeCopper decodes the Copper JSON IR. Malformed JSON or values of the wrong type are reported as a
+// *CopperError pointing at the offending path.
+func DecodeCopper(b []byte) (*CopperFile, error) {
+	var f CopperFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &typeErr) {
+			// encoding/json reports array indices as `.0`; use the `[0]` notation of the other errors.
+			path := arrayIndexRegex.ReplaceAllString(typeErr.Field, "[$1]")
+			return nil, copperErrorf(path, "expected %s, got %s", typeErr.Type, typeErr.Value)
+		} else if errors.As(err, &syntaxErr) {
+			return nil, copperErrorf("", "invalid json at offset %d: %v", syntaxErr.Offset, err)
+		}
+		return nil, &CopperError{Err: err}
+	}
+
+	return &f, nil
+}
+
+// GetFunctions returns the functions declared by all ActInterfaces of an interface file.
+func (f *CopperFile) GetFunctions() ([]PolicyFunction, error) {
+	var functions []PolicyFunction
+	found := false
+	for g, group := range f.Groups {
+		spec := group.Inner.Specification
+		if spec == nil || spec.ActInterface == nil {
+			continue
+		}
+		found = true
+
+		for i, field := range spec.ActInterface.Fields {
+			fieldPath := fmt.Sprintf("groups[%d].inner.Specification.ActInterface.fields[%d]", g, i)
+			if field.Action == nil {
+				// Only actions are dataplane functions.
+				continue
+			}
+
+			pf, err := field.Action.toPolicyFunction(fieldPath + ".Action")
+			if err != nil {
+				return nil, err
+			}
+			functions = append(functions, pf)
+		}
+	}
+
+	if !found {
+		return nil, copperErrorf("groups", "no ActInterface found")
+	}
+
+	return functions, nil
+}
+
+func (a *CopperAction) toPolicyFunction(path string) (PolicyFunction, error) {
+	if a.Name.Name == "" {
+		return PolicyFunction{}, copperErrorf(path+".name.name", "missing action name")
+	}
+
+	if len(a.Type.Function) == 0 {
+		return PolicyFunction{}, copperErrorf(path+".type_.Function", "missing function signature")
+	}
+
+	var signature struct {
+		Self *CopperAnnotation `json:"self_"`
+	}
+	if err := json.Unmarshal(a.Type.Function[0], &signature); err != nil {
+		return PolicyFunction{}, copperErrorf(path+".type_.Function[0]", "invalid function signature: %v", err)
+	}
+
+	// No annotation means the function can run anywhere and does not mutate the request.
+	if signature.Self == nil {
+		return CreatePolicyFunction(a.Name.Name, SENDER_RECEIVER, false), nil
+	}
+
+	placement := SENDER_RECEIVER
+	if signature.Self.Placement == "In" {
+		placement = RECEIVER
+	} else if signature.Self.Placement == "Out" {
+		placement = SENDER
+	}
+
+	return CreatePolicyFunction(a.Name.Name, placement, signature.Self.Mutability == "Mut"), nil
+}
+
+// GetPolicy returns the first policy declared in a policy file, along with its JSON path.
+func (f *CopperFile) GetPolicy() (*CopperPolicy, string, error) {
+	for g, group := range f.Groups {
+		if group.Inner.Policy != nil {
+			return group.Inner.Policy, fmt.Sprintf("groups[%d].inner.Policy", g), nil
+		}
+	}
+	return nil, "", copperErrorf("groups", "no Policy found")
+}
+
+// GetContext returns the policy context, one entry per block.
+// A block with several endpoints is encoded as `[a,b]`.
+func (cp *CopperPolicy) GetContext(path string) ([]string, error) {
+	var contextObject *CopperContext
+	contextPath := ""
+	for i, match := range cp.Matches {
+		if match.Context == nil {
+			continue
+		}
+		if contextObject != nil {
+			return nil, copperErrorf(fmt.Sprintf("%s.matches[%d].Context", path, i), "more than one context in policy")
+		}
+		contextObject = match.Context
+		contextPath = fmt.Sprintf("%s.matches[%d].Context", path, i)
+	}
+
+	if contextObject == nil {
+		return nil, copperErrorf(path+".matches", "no context found in policy")
+	}
+
+	if len(contextObject.Blocks) == 0 {
+		return nil, copperErrorf(contextPath+".blocks", "empty context")
+	}
+
+	var context []string
+	for b, block := range contextObject.Blocks {
+		blockPath := fmt.Sprintf("%s.blocks[%d].inner.Endpoints", contextPath, b)
+		if len(block.Inner.Endpoints) == 0 {
+			return nil, copperErrorf(blockPath, "no endpoints in block")
+		}
+
+		var serviceSet []string
+		for e, endpoint := range block.Inner.Endpoints {
+			if endpoint.Name == "" {
+				return nil, copperErrorf(fmt.Sprintf("%s[%d].name", blockPath, e), "missing service name")
+			}
+			serviceSet = append(serviceSet, endpoint.Name)
+		}
+
+		if len(serviceSet) == 1 {
+			context = append(context, serviceSet[0])
+		} else {
+			context = append(context, "["+strings.Join(serviceSet, ",")+"]")
+		}
+	}
+
+	return context, nil
+}
+
+// CopperFunctionRef is a function used by a policy, along with its JSON path for error reporting.
+type CopperFunctionRef struct {
+	Name string
+	Path string
+}
+
+// GetUsedFunctions returns the functions a policy calls, in order of use.
+func (cp *CopperPolicy) GetUsedFunctions(path string) ([]CopperFunctionRef, error) {
+	var refs []CopperFunctionRef
+	for i, fields := range cp.UsedAbstractFields {
+		for j, field := range fields {
+			if field.Set == nil {
+				continue
+			}
+
+			fieldPath := fmt.Sprintf("%s.used_abstract_fields[%d][%d].set", path, i, j)
+			if len(field.Set) == 0 || field.Set[0] == "" {
+				return nil, copperErrorf(fieldPath, "missing function name")
+			}
+			refs = append(refs, CopperFunctionRef{Name: field.Set[0], Path: fieldPath + "[0]"})
+		}
+	}
+
+	return refs, nil
+}
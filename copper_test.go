@@ -0,0 +1,208 @@
+package xPlane
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testDataplaneJson = `{
+	"imports": [],
+	"groups": [
+		{"inner": {"Specification": {"ActInterface": {"fields": [
+			{"Action": {"name": {"name": "GetHeader"}, "type_": {"Function": [{"self_": null}, "String"]}}},
+			{"Action": {"name": {"name": "SetHeader"}, "type_": {"Function": [{"self_": {"placement": "In", "mutability": "Mut"}}]}}},
+			{"Action": {"name": {"name": "RouteToVersion"}, "type_": {"Function": [{"self_": {"placement": "Out", "mutability": "Mut"}}]}}}
+		]}}}}
+	]
+}`
+
+const testPolicyJson = `{
+	"imports": [{"path": "rpc.m4"}],
+	"groups": [
+		{"inner": {"Policy": {
+			"matches": [
+				{"Act": {}},
+				{"Context": {"blocks": [
+					{"inner": {"Endpoints": [{"name": "frontend"}]}},
+					{"inner": {"Endpoints": [{"name": "search"}, {"name": "reserve"}]}}
+				]}}
+			],
+			"used_abstract_fields": [[{"get": ["GetHeader"]}, {"set": ["SetHeader"]}]]
+		}}}
+	]
+}`
+
+const testL7DataplaneJson = `{
+	"imports": [{"path": "common.m4"}],
+	"groups": [
+		{"inner": {"Specification": {"ActInterface": {"fields": [
+			{"Action": {"name": {"name": "SetHeader"}, "type_": {"Function": [{"self_": {"placement": "In", "mutability": "Mut"}}]}}},
+			{"Action": {"name": {"name": "InjectDelay"}, "type_": {"Function": [{"self_": {"placement": "In", "mutability": "Mut"}}]}}}
+		]}}}}
+	]
+}`
+
+func setupPlatform(t *testing.T) *Platform {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "rpc.m4.json"), []byte(testDataplaneJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := InitializePlatform(dir, map[string][]string{"frontend": {"search", "reserve"}})
+	if err := p.RegisterDataplane("rpc.m4.json", 10); err != nil {
+		t.Fatalf("Error registering dataplane: %v", err)
+	}
+
+	return p
+}
+
+func TestRegisterDataplane(t *testing.T) {
+	flag.Parse()
+
+	p := setupPlatform(t)
+	d, ok := p.GetRegistry().GetDataplane("rpc.m4")
+	if !ok {
+		t.Fatalf("Dataplane rpc.m4 not registered")
+	}
+	if d.GetIndex() != 0 || d.GetCost() != 10 {
+		t.Errorf("Expected index 0 and cost 10, got %d and %d", d.GetIndex(), d.GetCost())
+	}
+
+	functions := d.GetFunctions()
+	if len(functions) != 3 {
+		t.Fatalf("Expected 3 functions, got %d", len(functions))
+	}
+
+	setHeader := functions["SetHeader"]
+	if setHeader.GetConstraint() != RECEIVER || !setHeader.GetMutability() {
+		t.Errorf("Expected SetHeader to be a mutable RECEIVER function, got %v", setHeader)
+	}
+
+	getHeader := functions["GetHeader"]
+	if getHeader.GetConstraint() != SENDER_RECEIVER || getHeader.GetMutability() {
+		t.Errorf("Expected GetHeader to be an immutable SENDER_RECEIVER function, got %v", getHeader)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	flag.Parse()
+
+	p := setupPlatform(t)
+	policy, err := p.ParsePolicy([]byte(testPolicyJson))
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+
+	context := policy.GetContext()
+	if len(context) != 2 || context[0] != "frontend" || context[1] != "[search,reserve]" {
+		t.Errorf("Unexpected context %v", context)
+	}
+
+	functions := policy.GetFunctions()
+	if len(functions) != 1 || functions[0].GetFunctionName() != "SetHeader" {
+		t.Errorf("Expected only SetHeader, got %v", functions)
+	}
+}
+
+func TestParsePolicyMultipleImports(t *testing.T) {
+	flag.Parse()
+
+	p := setupPlatform(t)
+	if err := os.WriteFile(path.Join(p.jsonDir, "l7.m4.json"), []byte(testL7DataplaneJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RegisterDataplane("l7.m4.json", 4); err != nil {
+		t.Fatalf("Error registering dataplane: %v", err)
+	}
+
+	policyJson := `{
+		"imports": [{"path": "rpc.m4"}, {"path": "l7.m4"}],
+		"groups": [{"inner": {"Policy": {
+			"matches": [{"Context": {"blocks": [{"inner": {"Endpoints": [{"name": "frontend"}]}}, {"inner": {"Endpoints": [{"name": "search"}]}}]}}],
+			"used_abstract_fields": [[{"set": ["SetHeader"]}, {"set": ["InjectDelay"]}]]
+		}}}]
+	}`
+
+	policy, err := p.ParsePolicy([]byte(policyJson))
+	if err != nil {
+		t.Fatalf("Error parsing policy: %v", err)
+	}
+
+	functions := policy.GetFunctions()
+	if len(functions) != 2 {
+		t.Fatalf("Expected 2 functions, got %d", len(functions))
+	}
+
+	// SetHeader is implemented by both dataplanes, InjectDelay only by the L7 one.
+	if d := functions[0].GetDataplanes(); len(d) != 2 || d[0] != 0 || d[1] != 1 {
+		t.Errorf("Expected SetHeader to be supported by dataplanes [0 1], got %v", d)
+	}
+	if d := functions[1].GetDataplanes(); len(d) != 1 || d[0] != 1 {
+		t.Errorf("Expected InjectDelay to be supported by dataplanes [1], got %v", d)
+	}
+	if d := policy.GetDataplanes(); len(d) != 1 || d[0] != 1 {
+		t.Errorf("Expected the policy to be supported by dataplanes [1], got %v", d)
+	}
+
+	// Without the L7 import, InjectDelay cannot be resolved.
+	_, err = p.ParsePolicy([]byte(strings.Replace(policyJson, `, {"path": "l7.m4"}`, "", 1)))
+	if err == nil {
+		t.Errorf("Expected an error when InjectDelay is not imported")
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	flag.Parse()
+
+	p := setupPlatform(t)
+	testcases := []struct {
+		json string
+		path string
+	}{
+		{`{"imports": [{"path": "rpc.m4"}], "groups": [{"inner": {"Policy": {"matches": [{"Act": {}}]}}}]}`,
+			"groups[0].inner.Policy.matches"},
+		{`{"imports": [{"path": "rpc.m4"}], "groups": [{"inner": {"Policy": {"matches": [{"Context": {"blocks": [{"inner": {}}]}}]}}}]}`,
+			"groups[0].inner.Policy.matches[0].Context.blocks[0].inner.Endpoints"},
+		{`{"imports": [{"path": "rpc.m4"}], "groups": [{"inner": {"Policy": {"matches": [{"Context": {"blocks": [{"inner": {"Endpoints": [{"name": "A"}]}}]}}], "used_abstract_fields": [[{"set": ["Drop"]}]]}}}]}`,
+			"groups[0].inner.Policy.used_abstract_fields[0][0].set[0]"},
+		{`{"imports": [{"path": "l7.m4"}], "groups": [{"inner": {"Policy": {"matches": [{"Context": {"blocks": [{"inner": {"Endpoints": [{"name": "A"}]}}]}}]}}}]}`,
+			"imports[0].path"},
+		{`{"imports": [], "groups": [{"inner": {"Policy": {"matches": "oops"}}}]}`,
+			"groups[0].inner.Policy.matches"},
+	}
+
+	for _, tc := range testcases {
+		_, err := p.ParsePolicy([]byte(tc.json))
+		var copperErr *CopperError
+		if !errors.As(err, &copperErr) {
+			t.Errorf("Expected a CopperError for %s, got %v", tc.json, err)
+			continue
+		}
+		if copperErr.Path != tc.path {
+			t.Errorf("Expected error at %s, got %s", tc.path, copperErr.Path)
+		}
+	}
+}
+
+func TestSubmitPolicyRejectsInvalid(t *testing.T) {
+	flag.Parse()
+
+	p := setupPlatform(t)
+	if err := os.WriteFile(path.Join(p.jsonDir, "good.json"), []byte(testPolicyJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(p.jsonDir, "bad.json"), []byte(`{"groups": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.SubmitPolicy([]string{"good.json", "bad.json"}); err == nil {
+		t.Errorf("Expected an error for an invalid policy")
+	}
+	if len(p.policies) != 0 {
+		t.Errorf("Expected no policies to be accepted, got %d", len(p.policies))
+	}
+}
@@ -0,0 +1,64 @@
+package xPlane
+
+import (
+	"math"
+)
+
+// ResourceProfile is the resources a dataplane uses at a service, as measured by scripts/utils/cpumem_stats.py.
+type ResourceProfile struct {
+	// CPU used per request per second sent or received by the service, in millicores.
+	CPUPerRequest float64 `json:"cpuPerRequest,omitempty" yaml:"cpuPerRequest,omitempty"`
+
+	// CPU used by the sidecar of every replica of the service when idle, in millicores.
+	CPUPerInstance float64 `json:"cpuPerInstance,omitempty" yaml:"cpuPerInstance,omitempty"`
+
+	// Memory used by the sidecar of every replica of the service, in MiB.
+	MemoryPerInstance float64 `json:"memoryPerInstance,omitempty" yaml:"memoryPerInstance,omitempty"`
+}
+
+// CostModel converts the resources used by a dataplane at a service to the cost minimized by the placement.
+type CostModel struct {
+	// Cost of a millicore of CPU.
+	CPU float64
+
+	// Cost of a MiB of memory.
+	Memory float64
+}
+
+// DefaultCostModel charges a millicore of CPU as much as a MiB of memory.
+var DefaultCostModel = CostModel{CPU: 1, Memory: 1}
+
+// Get the number of replicas of a service, at least one.
+func (g *ApplicationGraph) GetReplicas(service string) int {
+	return max(g.serviceAttrs[service].Replicas, 1)
+}
+
+// Get the rate of the requests every service sends and receives, i.e. the requests its sidecar processes.
+func (g *ApplicationGraph) GetRequestRates() map[string]float64 {
+	rates := make(map[string]float64, len(g.services))
+	for e, attrs := range g.edgeAttrs {
+		rates[e.From] += attrs.RequestRate
+		rates[e.To] += attrs.RequestRate
+	}
+	return rates
+}
+
+// Get the cost of running a dataplane at a service with the given replicas and request rate: its fixed cost
+// for every replica, plus the CPU it uses for the requests and the CPU and memory of its instances, one per replica.
+// Without resources, and with a single replica, this is the fixed cost of the dataplane.
+func (c CostModel) DataplaneCost(d *Dataplane, replicas int, requestRate float64) int {
+	r := d.GetResources()
+
+	cost := float64(d.GetCost() * replicas)
+	cost += c.CPU * r.CPUPerRequest * requestRate
+	cost += c.CPU * r.CPUPerInstance * float64(replicas)
+	cost += c.Memory * r.MemoryPerInstance * float64(replicas)
+	return int(math.Round(cost))
+}
+
+// Get the CPU and memory used by every instance of the dataplane at a service with the given instances and
+// request rate, when the requests are evenly spread over the instances.
+func (r ResourceProfile) InstanceUsage(instances int, requestRate float64) (cpu float64, memory float64) {
+	cpu = r.CPUPerInstance + r.CPUPerRequest*requestRate/float64(max(instances, 1))
+	return cpu, r.MemoryPerInstance
+}
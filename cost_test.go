@@ -0,0 +1,68 @@
+package xPlane
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestDataplaneCost(t *testing.T) {
+	flag.Parse()
+
+	g := NewApplicationGraph()
+	g.AddService("frontend", ServiceAttributes{Replicas: 40})
+	g.AddService("search", ServiceAttributes{Replicas: 2})
+	g.AddService("cache", ServiceAttributes{})
+	g.AddEdge("frontend", "search", EdgeAttributes{RequestRate: 1000})
+	g.AddEdge("search", "cache", EdgeAttributes{RequestRate: 10})
+
+	r := NewDataplaneRegistry()
+	r.Register("envoy", 1, nil)
+	r.Register("bpf", 3, nil)
+	if err := r.SetResources("envoy", ResourceProfile{CPUPerRequest: 0.1, MemoryPerInstance: 50}); err != nil {
+		t.Fatalf("Error setting resources: %v", err)
+	}
+	if err := r.SetResources("envoy", ResourceProfile{CPUPerRequest: -1}); err == nil {
+		t.Errorf("Expected an error for negative resources")
+	}
+	if err := r.SetResources("linkerd", ResourceProfile{}); err == nil {
+		t.Errorf("Expected an error for an unregistered dataplane")
+	}
+
+	// Re-registering keeps the resources.
+	r.Register("envoy", 1, nil)
+	envoy, _ := r.GetDataplane("envoy")
+	bpf, _ := r.GetDataplane("bpf")
+	if envoy.GetResources().MemoryPerInstance != 50 {
+		t.Errorf("Expected the resources to be kept, got %v", envoy.GetResources())
+	}
+
+	rates := g.GetRequestRates()
+	if rates["frontend"] != 1000 || rates["search"] != 1010 || rates["cache"] != 10 {
+		t.Errorf("Unexpected request rates %v", rates)
+	}
+	if g.GetReplicas("frontend") != 40 || g.GetReplicas("cache") != 1 {
+		t.Errorf("Expected 40 and 1 replicas, got %d and %d", g.GetReplicas("frontend"), g.GetReplicas("cache"))
+	}
+
+	tests := []struct {
+		dataplane *Dataplane
+		service   string
+		model     CostModel
+		cost      int
+	}{
+		// 40 instances, 100 millicores for 1000 requests, and 50 MiB per instance.
+		{envoy, "frontend", DefaultCostModel, 40 + 100 + 2000},
+		{envoy, "cache", DefaultCostModel, 1 + 1 + 50},
+		{envoy, "cache", CostModel{CPU: 10}, 1 + 10},
+		// Without resources, the fixed cost per instance.
+		{bpf, "search", DefaultCostModel, 6},
+		{bpf, "cache", DefaultCostModel, 3},
+	}
+
+	for _, test := range tests {
+		cost := test.model.DataplaneCost(test.dataplane, g.GetReplicas(test.service), rates[test.service])
+		if cost != test.cost {
+			t.Errorf("Expected cost %d for %s at %s, got %d", test.cost, test.dataplane.GetName(), test.service, cost)
+		}
+	}
+}
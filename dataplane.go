@@ -0,0 +1,224 @@
+package xPlane
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Dataplane is a sidecar implementation that can enforce policies, along with the functions it supports.
+// The index of a dataplane is its position in the registry, and is what PolicyFunction.dataplanes refers to.
+type Dataplane struct {
+	name      string
+	index     int
+	cost      int
+	functions map[string]PolicyFunction
+
+	// Protocols the dataplane can parse. A dataplane without protocols can parse any protocol.
+	protocols []Protocol
+
+	// Resources used by the dataplane, to weigh its cost by the traffic and replicas of a service.
+	resources ResourceProfile
+}
+
+// Accessor methods for Dataplane struct.
+func (d *Dataplane) GetName() string {
+	return d.name
+}
+
+func (d *Dataplane) GetIndex() int {
+	return d.index
+}
+
+func (d *Dataplane) GetCost() int {
+	return d.cost
+}
+
+func (d *Dataplane) GetFunctions() map[string]PolicyFunction {
+	return d.functions
+}
+
+func (d *Dataplane) GetFunction(functionName string) (PolicyFunction, bool) {
+	pf, ok := d.functions[functionName]
+	return pf, ok
+}
+
+func (d *Dataplane) GetProtocols() []Protocol {
+	return d.protocols
+}
+
+func (d *Dataplane) GetResources() ResourceProfile {
+	return d.resources
+}
+
+// SupportsProtocol checks if the dataplane can parse the requests of an edge speaking the protocol.
+// Edges of unknown protocol are assumed to be supported.
+func (d *Dataplane) SupportsProtocol(protocol Protocol) bool {
+	if len(d.protocols) == 0 || protocol == PROTOCOL_UNKNOWN {
+		return true
+	}
+	return slices.Contains(d.protocols, protocol)
+}
+
+// Supports checks if the dataplane can run the function where the given constraint requires it.
+// A function declared without a placement annotation can run at either side.
+func (d *Dataplane) Supports(functionName string, constraint ConstraintType) bool {
+	pf, ok := d.functions[functionName]
+	if !ok {
+		return false
+	}
+	return pf.GetConstraint() == SENDER_RECEIVER || pf.GetConstraint() == constraint
+}
+
+// Create a new Dataplane struct.
+func CreateDataplane(name string, index int, cost int, functions []PolicyFunction) Dataplane {
+	d := Dataplane{
+		name:      name,
+		index:     index,
+		cost:      cost,
+		functions: make(map[string]PolicyFunction),
+	}
+
+	for _, pf := range functions {
+		d.functions[pf.GetFunctionName()] = pf
+	}
+
+	return d
+}
+
+// Get the cost of every dataplane, indexed by the dataplane index.
+func GetDataplaneCosts(dataplanes []Dataplane) []int {
+	costs := make([]int, len(dataplanes))
+	for _, d := range dataplanes {
+		costs[d.GetIndex()] = d.GetCost()
+	}
+	return costs
+}
+
+// DataplaneRegistry holds all registered dataplanes. The same function name may be
+// registered by several dataplanes, each with its own annotations.
+type DataplaneRegistry struct {
+	dataplanes []Dataplane
+	byName     map[string]int
+}
+
+func NewDataplaneRegistry() *DataplaneRegistry {
+	return &DataplaneRegistry{
+		byName: make(map[string]int),
+	}
+}
+
+// Register adds a dataplane to the registry and returns its index.
+// Functions of a single dataplane must be unique. Re-registering a dataplane replaces
+// its cost and functions but keeps its index, protocols and resources.
+func (r *DataplaneRegistry) Register(name string, cost int, functions []PolicyFunction) (int, error) {
+	seen := make(map[string]bool)
+	for _, pf := range functions {
+		if seen[pf.GetFunctionName()] {
+			return -1, fmt.Errorf("function %s declared more than once in dataplane %s", pf.GetFunctionName(), name)
+		}
+		seen[pf.GetFunctionName()] = true
+	}
+
+	index, ok := r.byName[name]
+	if !ok {
+		index = len(r.dataplanes)
+		r.byName[name] = index
+		r.dataplanes = append(r.dataplanes, Dataplane{})
+	}
+	protocols, resources := r.dataplanes[index].protocols, r.dataplanes[index].resources
+	r.dataplanes[index] = CreateDataplane(name, index, cost, functions)
+	r.dataplanes[index].protocols = protocols
+	r.dataplanes[index].resources = resources
+
+	return index, nil
+}
+
+// SetProtocols declares the protocols a registered dataplane can parse, e.g. a gRPC-only eBPF parser.
+// Without protocols, a dataplane can parse any protocol.
+func (r *DataplaneRegistry) SetProtocols(name string, protocols []Protocol) error {
+	d, ok := r.GetDataplane(name)
+	if !ok {
+		return fmt.Errorf("dataplane %s is not registered", name)
+	}
+
+	for _, protocol := range protocols {
+		if protocol == PROTOCOL_UNKNOWN {
+			return fmt.Errorf("empty protocol for dataplane %s", name)
+		}
+	}
+
+	d.protocols = append([]Protocol{}, protocols...)
+	return nil
+}
+
+// SetResources declares the resources a registered dataplane uses, e.g. as measured by scripts/utils/cpumem_stats.py.
+func (r *DataplaneRegistry) SetResources(name string, resources ResourceProfile) error {
+	d, ok := r.GetDataplane(name)
+	if !ok {
+		return fmt.Errorf("dataplane %s is not registered", name)
+	}
+	if resources.CPUPerRequest < 0 || resources.CPUPerInstance < 0 || resources.MemoryPerInstance < 0 {
+		return fmt.Errorf("negative resources for dataplane %s", name)
+	}
+
+	d.resources = resources
+	return nil
+}
+
+func (r *DataplaneRegistry) GetDataplanes() []Dataplane {
+	return r.dataplanes
+}
+
+func (r *DataplaneRegistry) GetDataplane(name string) (*Dataplane, bool) {
+	index, ok := r.byName[name]
+	if !ok {
+		return nil, false
+	}
+	return &r.dataplanes[index], true
+}
+
+func (r *DataplaneRegistry) GetCosts() []int {
+	return GetDataplaneCosts(r.dataplanes)
+}
+
+// Lookup returns the indexes of all dataplanes that can run the function where the constraint requires it,
+// e.g. Lookup("RouteToVersion", SENDER) gives the dataplanes that support RouteToVersion at egress.
+func (r *DataplaneRegistry) Lookup(functionName string, constraint ConstraintType) []int {
+	var supported []int
+	for _, d := range r.dataplanes {
+		if d.Supports(functionName, constraint) {
+			supported = append(supported, d.GetIndex())
+		}
+	}
+	return supported
+}
+
+// ResolveFunction resolves a function used by a policy against the given dataplanes (all dataplanes if none are given).
+// The annotations are taken from the first of these dataplanes that declares the function, and the function
+// is marked as supported by every registered dataplane that can run it under those annotations.
+func (r *DataplaneRegistry) ResolveFunction(functionName string, candidates []string) (PolicyFunction, error) {
+	if len(candidates) == 0 {
+		for _, d := range r.dataplanes {
+			candidates = append(candidates, d.GetName())
+		}
+	}
+
+	for _, name := range candidates {
+		d, ok := r.GetDataplane(name)
+		if !ok {
+			return PolicyFunction{}, fmt.Errorf("dataplane %s is not registered", name)
+		}
+
+		pf, ok := d.GetFunction(functionName)
+		if !ok {
+			continue
+		}
+
+		supported := r.Lookup(functionName, pf.GetConstraint())
+		return CreateNewPolicyFunction(functionName, pf.GetConstraint(), supported, pf.GetMutability()), nil
+	}
+
+	return PolicyFunction{}, fmt.Errorf("function %s not found in dataplanes %s", functionName, strings.Join(candidates, ", "))
+}
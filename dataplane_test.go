@@ -0,0 +1,125 @@
+package xPlane
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestDataplaneRegistryOverloads(t *testing.T) {
+	flag.Parse()
+
+	r := NewDataplaneRegistry()
+
+	// SetHeader and RouteToVersion exist in both dataplanes, with different constraints.
+	_, err := r.Register("rpc", 10, []PolicyFunction{
+		CreatePolicyFunction("SetHeader", SENDER_RECEIVER, true),
+		CreatePolicyFunction("RouteToVersion", SENDER, true),
+	})
+	if err != nil {
+		t.Fatalf("Error registering dataplane: %v", err)
+	}
+	_, err = r.Register("l7", 4, []PolicyFunction{
+		CreatePolicyFunction("SetHeader", RECEIVER, true),
+		CreatePolicyFunction("RouteToVersion", SENDER_RECEIVER, true),
+	})
+	if err != nil {
+		t.Fatalf("Error registering dataplane: %v", err)
+	}
+
+	testcases := []struct {
+		function   string
+		constraint ConstraintType
+		expected   []int
+	}{
+		{"RouteToVersion", SENDER, []int{0, 1}},
+		{"RouteToVersion", RECEIVER, []int{1}},
+		{"SetHeader", SENDER, []int{0}},
+		{"SetHeader", RECEIVER, []int{0, 1}},
+		{"SetHeader", SENDER_RECEIVER, []int{0}},
+		{"Deny", SENDER_RECEIVER, nil},
+	}
+
+	for _, tc := range testcases {
+		got := r.Lookup(tc.function, tc.constraint)
+		if len(got) != len(tc.expected) {
+			t.Errorf("Lookup(%s, %d): expected %v, got %v", tc.function, tc.constraint, tc.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("Lookup(%s, %d): expected %v, got %v", tc.function, tc.constraint, tc.expected, got)
+			}
+		}
+	}
+
+	// Resolving against l7 uses its RECEIVER annotation, which both dataplanes support.
+	pf, err := r.ResolveFunction("SetHeader", []string{"l7"})
+	if err != nil {
+		t.Fatalf("Error resolving function: %v", err)
+	}
+	if pf.GetConstraint() != RECEIVER || len(pf.GetDataplanes()) != 2 {
+		t.Errorf("Expected a RECEIVER function supported by 2 dataplanes, got %v", pf)
+	}
+
+	if costs := r.GetCosts(); len(costs) != 2 || costs[0] != 10 || costs[1] != 4 {
+		t.Errorf("Expected costs [10 4], got %v", costs)
+	}
+}
+
+func TestDataplaneRegistryDuplicates(t *testing.T) {
+	flag.Parse()
+
+	r := NewDataplaneRegistry()
+	_, err := r.Register("rpc", 10, []PolicyFunction{
+		CreatePolicyFunction("SetHeader", SENDER, true),
+		CreatePolicyFunction("SetHeader", RECEIVER, true),
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a duplicate function in a dataplane")
+	}
+
+	// Re-registering keeps the index.
+	r.Register("rpc", 10, nil)
+	r.Register("l7", 4, nil)
+	index, _ := r.Register("rpc", 12, nil)
+	if index != 0 {
+		t.Errorf("Expected index 0 for a re-registered dataplane, got %d", index)
+	}
+	if d, _ := r.GetDataplane("rpc"); d.GetCost() != 12 {
+		t.Errorf("Expected the cost to be updated to 12, got %d", d.GetCost())
+	}
+}
+
+func TestDataplaneProtocols(t *testing.T) {
+	flag.Parse()
+
+	r := NewDataplaneRegistry()
+	r.Register("bpf-grpc", 1, nil)
+	r.Register("envoy", 10, nil)
+
+	if err := r.SetProtocols("bpf-grpc", []Protocol{PROTOCOL_GRPC}); err != nil {
+		t.Fatalf("Error setting protocols: %v", err)
+	}
+	if err := r.SetProtocols("bpf-thrift", []Protocol{PROTOCOL_THRIFT}); err == nil {
+		t.Errorf("Expected an error for an unregistered dataplane")
+	}
+	if err := r.SetProtocols("envoy", []Protocol{PROTOCOL_UNKNOWN}); err == nil {
+		t.Errorf("Expected an error for an empty protocol")
+	}
+
+	bpf, _ := r.GetDataplane("bpf-grpc")
+	if !bpf.SupportsProtocol(PROTOCOL_GRPC) || bpf.SupportsProtocol(PROTOCOL_THRIFT) || !bpf.SupportsProtocol(PROTOCOL_UNKNOWN) {
+		t.Errorf("Expected bpf-grpc to support gRPC and unknown protocols only")
+	}
+
+	envoy, _ := r.GetDataplane("envoy")
+	if !envoy.SupportsProtocol(PROTOCOL_THRIFT) {
+		t.Errorf("Expected a dataplane without protocols to support any protocol")
+	}
+
+	// Re-registering keeps the protocols.
+	r.Register("bpf-grpc", 2, nil)
+	if bpf, _ := r.GetDataplane("bpf-grpc"); bpf.SupportsProtocol(PROTOCOL_THRIFT) {
+		t.Errorf("Expected the protocols to be kept, got %v", bpf.GetProtocols())
+	}
+}
@@ -4,21 +4,20 @@ go 1.22.0
 
 toolchain go1.24.0
 
-require (
-	github.com/buger/jsonparser v1.1.1
-	github.com/golang/glog v1.1.2
-)
+require github.com/golang/glog v1.1.2
 
 require golang.org/x/exp v0.0.0-20230626212559-97b1e661b5df
 
+require gopkg.in/yaml.v3 v3.0.1
+
 require (
+	github.com/HdrHistogram/hdrhistogram-go v1.1.2
 	github.com/dominikbraun/graph v0.23.0
 	google.golang.org/grpc v1.61.0
 	google.golang.org/grpc/examples v0.0.0-20240125001036-5051eeae537c
 )
 
 require (
-	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
 	github.com/disintegration/imaging v1.6.2 // indirect
 	github.com/flopp/go-findfont v0.1.0 // indirect
 	github.com/fogleman/gg v1.3.0 // indirect
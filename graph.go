@@ -0,0 +1,268 @@
+package xPlane
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// Protocol is the application protocol used on an edge of the application graph.
+type Protocol string
+
+const (
+	PROTOCOL_UNKNOWN Protocol = ""
+	PROTOCOL_GRPC    Protocol = "grpc"
+	PROTOCOL_THRIFT  Protocol = "thrift"
+	PROTOCOL_HTTP    Protocol = "http"
+)
+
+// ServiceAttributes describes a service of the application graph.
+type ServiceAttributes struct {
+	Replicas  int               `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	Namespace string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Version   string            `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// EdgeAttributes describes the requests sent on an edge of the application graph.
+type EdgeAttributes struct {
+	Protocol Protocol `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Port     int      `json:"port,omitempty" yaml:"port,omitempty"`
+
+	// Requests per second.
+	RequestRate float64 `json:"requestRate,omitempty" yaml:"requestRate,omitempty"`
+
+	// Names of the methods called, e.g. gRPC methods or HTTP paths.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+}
+
+// Edge is a caller and a callee of the application graph.
+type Edge struct {
+	From string
+	To   string
+}
+
+// ApplicationGraph is a microservice application: the services, who calls whom, and their attributes.
+type ApplicationGraph struct {
+	services     []string
+	serviceAttrs map[string]ServiceAttributes
+
+	// Callees of every service, in the order they were added.
+	edges     map[string][]string
+	edgeAttrs map[Edge]EdgeAttributes
+
+	// Problems found while building the graph, reported by Validate.
+	errs []error
+}
+
+func NewApplicationGraph() *ApplicationGraph {
+	return &ApplicationGraph{
+		serviceAttrs: make(map[string]ServiceAttributes),
+		edges:        make(map[string][]string),
+		edgeAttrs:    make(map[Edge]EdgeAttributes),
+	}
+}
+
+// Add a service to the graph. Adding a service twice is reported by Validate.
+func (g *ApplicationGraph) AddService(name string, attrs ServiceAttributes) {
+	if _, ok := g.serviceAttrs[name]; ok {
+		g.errs = append(g.errs, fmt.Errorf("duplicate service %s", name))
+		return
+	}
+
+	g.services = append(g.services, name)
+	g.serviceAttrs[name] = attrs
+}
+
+// Add an edge from a caller to a callee. Self-loops and edges added twice are reported by Validate.
+func (g *ApplicationGraph) AddEdge(from string, to string, attrs EdgeAttributes) {
+	if from == to {
+		g.errs = append(g.errs, fmt.Errorf("self-loop on service %s", from))
+		return
+	}
+	if _, ok := g.edgeAttrs[Edge{from, to}]; ok {
+		g.errs = append(g.errs, fmt.Errorf("duplicate edge %s->%s", from, to))
+		return
+	}
+
+	g.edges[from] = append(g.edges[from], to)
+	g.edgeAttrs[Edge{from, to}] = attrs
+}
+
+// Validate checks that every edge connects services of the graph, and that no service, edge or
+// self-loop was rejected while building the graph. All problems are reported together.
+func (g *ApplicationGraph) Validate() error {
+	errs := append([]error{}, g.errs...)
+
+	for _, from := range g.sortedSources() {
+		if _, ok := g.serviceAttrs[from]; !ok {
+			errs = append(errs, fmt.Errorf("dangling service %s: caller is not in the graph", from))
+		}
+		for _, to := range g.edges[from] {
+			if _, ok := g.serviceAttrs[to]; !ok {
+				errs = append(errs, fmt.Errorf("dangling service %s: callee of %s is not in the graph", to, from))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (g *ApplicationGraph) sortedSources() []string {
+	sources := make([]string, 0, len(g.edges))
+	for svc := range g.edges {
+		sources = append(sources, svc)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// Get the services, in the order they were added.
+func (g *ApplicationGraph) GetServices() []string {
+	return append([]string{}, g.services...)
+}
+
+// Get the callees of every service, the representation used by the placement.
+func (g *ApplicationGraph) GetEdges() map[string][]string {
+	edges := make(map[string][]string, len(g.edges))
+	for svc, callees := range g.edges {
+		edges[svc] = append([]string{}, callees...)
+	}
+	return edges
+}
+
+// Get the callees of a service.
+func (g *ApplicationGraph) GetCallees(service string) []string {
+	return append([]string{}, g.edges[service]...)
+}
+
+func (g *ApplicationGraph) HasService(service string) bool {
+	_, ok := g.serviceAttrs[service]
+	return ok
+}
+
+func (g *ApplicationGraph) HasEdge(from string, to string) bool {
+	_, ok := g.edgeAttrs[Edge{from, to}]
+	return ok
+}
+
+func (g *ApplicationGraph) GetServiceAttributes(service string) (ServiceAttributes, bool) {
+	attrs, ok := g.serviceAttrs[service]
+	return attrs, ok
+}
+
+func (g *ApplicationGraph) GetEdgeAttributes(from string, to string) (EdgeAttributes, bool) {
+	attrs, ok := g.edgeAttrs[Edge{from, to}]
+	return attrs, ok
+}
+
+// Get the labels of a service, e.g. for PolicyContext.WithLabels.
+func (g *ApplicationGraph) GetLabels(service string) map[string]string {
+	return g.serviceAttrs[service].Labels
+}
+
+// ApplicationGraphFromEdges converts the callees of every service and the list of services to a graph,
+// and validates it. If services is nil, the services are all the callers and callees, sorted by name.
+func ApplicationGraphFromEdges(applEdges map[string][]string, services []string) (*ApplicationGraph, error) {
+	g := NewApplicationGraph()
+
+	if services == nil {
+		for svc, callees := range applEdges {
+			for _, s := range append([]string{svc}, callees...) {
+				if !slices.Contains(services, s) {
+					services = append(services, s)
+				}
+			}
+		}
+		sort.Strings(services)
+	}
+
+	for _, svc := range services {
+		g.AddService(svc, ServiceAttributes{})
+	}
+
+	sources := make([]string, 0, len(applEdges))
+	for svc := range applEdges {
+		sources = append(sources, svc)
+	}
+	sort.Strings(sources)
+	for _, from := range sources {
+		for _, to := range applEdges[from] {
+			g.AddEdge(from, to, EdgeAttributes{})
+		}
+	}
+
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ErrTooManyPaths is returned when an application graph has more request paths than the limit.
+var ErrTooManyPaths = errors.New("too many request paths")
+
+// GetRequestPaths returns the paths a request can take through the application: every path that starts at a
+// service no one calls, and follows callees until it reaches a service whose callees are all on the path already.
+// Services only reachable through a cycle start paths too. Returns ErrTooManyPaths if there are more than limit.
+func GetRequestPaths(applEdges map[string][]string, services []string, limit int) ([][]string, error) {
+	called := make(map[string]bool)
+	for _, callees := range applEdges {
+		for _, callee := range callees {
+			called[callee] = true
+		}
+	}
+
+	var paths [][]string
+	visited := make(map[string]bool)
+	onPath := make(map[string]bool)
+	var path []string
+
+	var walk func(svc string) error
+	walk = func(svc string) error {
+		path = append(path, svc)
+		onPath[svc] = true
+		visited[svc] = true
+		defer func() {
+			path = path[:len(path)-1]
+			onPath[svc] = false
+		}()
+
+		extended := false
+		for _, callee := range applEdges[svc] {
+			if onPath[callee] {
+				continue
+			}
+			extended = true
+			if err := walk(callee); err != nil {
+				return err
+			}
+		}
+
+		if !extended {
+			if len(paths) == limit {
+				return ErrTooManyPaths
+			}
+			paths = append(paths, append([]string{}, path...))
+		}
+		return nil
+	}
+
+	for _, svc := range services {
+		if !called[svc] {
+			if err := walk(svc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, svc := range services {
+		if !visited[svc] {
+			if err := walk(svc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return paths, nil
+}
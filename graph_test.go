@@ -0,0 +1,126 @@
+package xPlane
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplicationGraph(t *testing.T) {
+	flag.Parse()
+
+	g := NewApplicationGraph()
+	g.AddService("frontend", ServiceAttributes{Replicas: 2, Namespace: "hotel", Labels: map[string]string{"app": "frontend"}})
+	g.AddService("search", ServiceAttributes{Replicas: 1, Version: "v2"})
+	g.AddService("geo", ServiceAttributes{})
+	g.AddEdge("frontend", "search", EdgeAttributes{Protocol: PROTOCOL_GRPC, Port: 8082, RequestRate: 100, Methods: []string{"Nearby"}})
+	g.AddEdge("search", "geo", EdgeAttributes{Protocol: PROTOCOL_GRPC})
+
+	if err := g.Validate(); err != nil {
+		t.Fatalf("Expected a valid graph, got %v", err)
+	}
+
+	if !reflect.DeepEqual(g.GetServices(), []string{"frontend", "search", "geo"}) {
+		t.Errorf("Expected services in insertion order, got %v", g.GetServices())
+	}
+	if !reflect.DeepEqual(g.GetEdges(), map[string][]string{"frontend": {"search"}, "search": {"geo"}}) {
+		t.Errorf("Unexpected edges %v", g.GetEdges())
+	}
+
+	attrs, ok := g.GetEdgeAttributes("frontend", "search")
+	if !ok || attrs.Protocol != PROTOCOL_GRPC || attrs.Port != 8082 || attrs.RequestRate != 100 {
+		t.Errorf("Unexpected edge attributes %v", attrs)
+	}
+	if _, ok := g.GetEdgeAttributes("search", "frontend"); ok {
+		t.Errorf("Expected no edge from search to frontend")
+	}
+	if g.GetLabels("frontend")["app"] != "frontend" || g.GetLabels("geo") != nil {
+		t.Errorf("Unexpected labels")
+	}
+
+	// The returned edges are a copy.
+	g.GetEdges()["frontend"][0] = "geo"
+	if !g.HasEdge("frontend", "search") || g.GetCallees("frontend")[0] != "search" {
+		t.Errorf("Expected the graph to be unchanged")
+	}
+}
+
+func TestApplicationGraphValidate(t *testing.T) {
+	flag.Parse()
+
+	g := NewApplicationGraph()
+	g.AddService("A", ServiceAttributes{})
+	g.AddService("B", ServiceAttributes{})
+	g.AddService("A", ServiceAttributes{})
+	g.AddEdge("A", "B", EdgeAttributes{})
+	g.AddEdge("A", "B", EdgeAttributes{})
+	g.AddEdge("B", "B", EdgeAttributes{})
+	g.AddEdge("B", "C", EdgeAttributes{})
+
+	err := g.Validate()
+	if err == nil {
+		t.Fatalf("Expected validation errors")
+	}
+	for _, msg := range []string{"duplicate service A", "duplicate edge A->B", "self-loop on service B", "dangling service C"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error %q, got %v", msg, err)
+		}
+	}
+
+	// The hotel reservation graph used to have a typo node, missing from the services.
+	applEdges := map[string][]string{"frontend": {"search"}, "search": {"geo"}, "geo": {"geo=mongo"}}
+	if _, err := ApplicationGraphFromEdges(applEdges, []string{"frontend", "search", "geo", "geo-mongo"}); err == nil {
+		t.Errorf("Expected an error for the dangling service geo=mongo")
+	}
+
+	// Without a list of services, all callers and callees are services.
+	g, err = ApplicationGraphFromEdges(applEdges, nil)
+	if err != nil {
+		t.Fatalf("Error converting edges: %v", err)
+	}
+	if !reflect.DeepEqual(g.GetServices(), []string{"frontend", "geo", "geo=mongo", "search"}) {
+		t.Errorf("Unexpected services %v", g.GetServices())
+	}
+	if !reflect.DeepEqual(g.GetEdges(), applEdges) {
+		t.Errorf("Expected edges %v, got %v", applEdges, g.GetEdges())
+	}
+}
+
+func TestGetRequestPaths(t *testing.T) {
+	flag.Parse()
+
+	applEdges := map[string][]string{
+		"frontend": {"search", "profile"},
+		"search":   {"geo", "rate"},
+		"rate":     {"search"},
+		"geo":      {"geo-mongo"},
+		// A cycle no one calls into.
+		"worker": {"queue"},
+		"queue":  {"worker"},
+	}
+	services := []string{"frontend", "search", "profile", "geo", "rate", "geo-mongo", "worker", "queue"}
+
+	paths, err := GetRequestPaths(applEdges, services, 100)
+	if err != nil {
+		t.Fatalf("Error finding paths: %v", err)
+	}
+	expected := [][]string{
+		{"frontend", "search", "geo", "geo-mongo"},
+		{"frontend", "search", "rate"},
+		{"frontend", "profile"},
+		{"worker", "queue"},
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, paths)
+	}
+
+	if _, err := GetRequestPaths(applEdges, services, 3); err != ErrTooManyPaths {
+		t.Errorf("Expected ErrTooManyPaths, got %v", err)
+	}
+
+	p := &Placement{Sidecars: map[string]string{"frontend": "envoy", "rate": "envoy", "geo": "bpf"}}
+	if proxies := p.CountProxies(expected[0]); proxies != 2 {
+		t.Errorf("Expected 2 proxies, got %d", proxies)
+	}
+}
@@ -1,31 +1,17 @@
 package conflict
 
 import (
-	"strings"
+	"fmt"
 	xp "xPlane"
 	"xPlane/pkg/placement/smt"
 )
 
-// Check if the policy has overlapping context with the given set of contexts.
-func overlappingContext(policy xp.Policy, contexts [][]string, applGraph map[string][]string) bool {
-	// Enumerate all possible contexts for policy1.
-	allContexts := smt.ExpandPolicyContextDeprecated(policy.GetContext(), applGraph, true)
-
-	// Check if any of the contexts is a subset of the other.
-	// TODO: This might be a very inefficient way of doing this.
-	for _, context1 := range contexts {
-		for _, context2 := range allContexts {
-			strRepr1 := strings.Join(context1, ",")
-			strRepr2 := strings.Join(context2, ",")
-
-			if len(context1) > len(context2) {
-				// Check if context2 is a subset of context1.
-				if strings.Contains(strRepr1, strRepr2) {
-					return true
-				}
-			} else {
-				// Check if context1 is a subset of context2.
-				if strings.Contains(strRepr2, strRepr1) {
+// Check if the context matches any part of the given request paths.
+func matchesSubpath(context *xp.PolicyContext, paths [][]string) bool {
+	for _, path := range paths {
+		for start := 0; start < len(path)-1; start++ {
+			for end := start + 2; end <= len(path); end++ {
+				if context.Matches(path[start:end]) {
 					return true
 				}
 			}
@@ -35,23 +21,81 @@ func overlappingContext(policy xp.Policy, contexts [][]string, applGraph map[str
 	return false
 }
 
+// Check if the policy, whose context is given, has overlapping context with the new policy, whose request paths
+// are given. Two policies overlap if a part of a request path of one is matched by the context of the other.
+func overlappingContext(policy xp.Policy, context *xp.PolicyContext, newContext *xp.PolicyContext, newContexts [][]string, applGraph map[string][]string, opts smt.ExpandOptions) (bool, error) {
+	if matchesSubpath(context, newContexts) {
+		return true, nil
+	}
+
+	// Enumerate all possible contexts for the policy.
+	allContexts, err := smt.ExpandPolicyContext(policy.GetContext(), applGraph, true, opts)
+	if err != nil {
+		return false, err
+	}
+	return matchesSubpath(newContext, allContexts), nil
+}
+
 // Find conflicting policies given a set of already submitted policies,
-// and a new policy.
-func FindConflictingPolicies(policies []xp.Policy, newPolicy xp.Policy, applGraph map[string][]string) []xp.Policy {
+// and a new policy. The request paths of the policies are expanded within the limits of opts,
+// and an error is returned if they are exceeded, or if the context of a policy is invalid.
+//
+// Only the policies that mutate the CNO can conflict, and the others are skipped. The request paths of the new
+// policy are expanded once, and those of an existing policy only if its context matches none of them, so each
+// call may expand every existing policy, up to opts.MaxPaths paths each. Checking policies one at a time
+// against all the previous ones is quadratic in the number of policies.
+func FindConflictingPolicies(policies []xp.Policy, newPolicy xp.Policy, applGraph map[string][]string, opts smt.ExpandOptions) ([]xp.Policy, error) {
 	var conflictingPolicies []xp.Policy
 
+	newContext, err := newPolicy.GetContextExpr()
+	if err != nil {
+		return nil, err
+	}
+	newContext.WithLabels(opts.Labels)
+	contexts := make([]*xp.PolicyContext, len(policies))
+	for k, policy := range policies {
+		if contexts[k], err = policy.GetContextExpr(); err != nil {
+			return nil, fmt.Errorf("policy %d: %w", k, err)
+		}
+		contexts[k].WithLabels(opts.Labels)
+	}
+
+	if !newPolicy.ExistsMutableFunction() {
+		return conflictingPolicies, nil
+	}
+
 	// Get all contexts for the new policy.
-	newPolicyContexts := smt.ExpandPolicyContextDeprecated(newPolicy.GetContext(), applGraph, true)
-
-	for _, policy := range policies {
-		// A policy could be conflicting if it has overlapping context.
-		if overlappingContext(policy, newPolicyContexts, applGraph) {
-			// Check if both policies mutate the CNO.
-			if policy.ExistsMutableFunction() && newPolicy.ExistsMutableFunction() {
-				conflictingPolicies = append(conflictingPolicies, policy)
-			}
+	newPolicyContexts, err := smt.ExpandPolicyContext(newPolicy.GetContext(), applGraph, true, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, policy := range policies {
+		// Both policies must mutate the CNO to conflict.
+		if !policy.ExistsMutableFunction() {
+			continue
+		}
+
+		// A policy is conflicting if it has overlapping context.
+		overlapping, err := overlappingContext(policy, contexts[k], newContext, newPolicyContexts, applGraph, opts)
+		if err != nil {
+			return nil, fmt.Errorf("policy %d: %w", k, err)
 		}
+		if overlapping {
+			conflictingPolicies = append(conflictingPolicies, policy)
+		}
+	}
+
+	return conflictingPolicies, nil
+}
+
+// Find conflicting policies on an application graph, whose label selectors match the labels of the services.
+// See FindConflictingPolicies.
+func FindConflictingPoliciesForGraph(policies []xp.Policy, newPolicy xp.Policy, g *xp.ApplicationGraph, opts smt.ExpandOptions) ([]xp.Policy, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
 	}
+	opts.Labels = g.GetLabels
 
-	return conflictingPolicies
+	return FindConflictingPolicies(policies, newPolicy, g.GetEdges(), opts)
 }
@@ -1,10 +1,14 @@
 package conflict
 
 import (
+	"errors"
 	"flag"
+	"reflect"
+	"strings"
 	"testing"
 
 	xp "xPlane"
+	"xPlane/pkg/placement/smt"
 )
 
 func TestConflicts(t *testing.T) {
@@ -34,8 +38,178 @@ func TestConflicts(t *testing.T) {
 	newPolicy := xp.CreatePolicy([]string{"*", "B", "C"}, functions_p3)
 
 	// Check if the new policy conflicts with the existing ones.
-	conflicts := FindConflictingPolicies(policies, newPolicy, applGraph)
+	conflicts, err := FindConflictingPolicies(policies, newPolicy, applGraph, smt.DefaultExpandOptions)
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %v", err)
+	}
 	if len(conflicts) != 1 {
 		t.Errorf("Expected 1 conflicting policy, got %d", len(conflicts))
 	}
+
+	// An existing policy with an invalid context is an error, like an invalid new policy.
+	invalid := append(policies, xp.CreatePolicy([]string{"A", "[B"}, functions_p2))
+	if _, err := FindConflictingPolicies(invalid, newPolicy, applGraph, smt.DefaultExpandOptions); err == nil || !strings.HasPrefix(err.Error(), "policy 2: ") {
+		t.Errorf("Expected an error for the context of policy 2, got %v", err)
+	}
+	if _, err := FindConflictingPolicies(policies, invalid[2], applGraph, smt.DefaultExpandOptions); err == nil {
+		t.Errorf("Expected an error for the context of the new policy")
+	}
+}
+
+// Hotel reservation application graph.
+func hotelReservationGraph() map[string][]string {
+	applGraph := make(map[string][]string)
+	applGraph["frontend"] = []string{"recommend", "user", "profile", "search", "reserve"}
+	applGraph["search"] = []string{"geo", "rate"}
+	applGraph["recommend"] = []string{"recommend-mongo"}
+	applGraph["reserve"] = []string{"reserve-mongo", "reserve-memc"}
+	applGraph["user"] = []string{"user-mongo"}
+	applGraph["rate"] = []string{"rate-mongo", "rate-memc"}
+	applGraph["geo"] = []string{"geo-mongo"}
+	applGraph["profile"] = []string{"profile-mongo", "profile-memc"}
+
+	return applGraph
+}
+
+func TestConflictsServiceSets(t *testing.T) {
+	flag.Parse()
+
+	applGraph := hotelReservationGraph()
+
+	setHeader := []xp.PolicyFunction{xp.CreatePolicyFunction("set_header", xp.SENDER, true)}
+
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"frontend", "[search,reserve]"}, setHeader),
+		xp.CreatePolicy([]string{"[user,profile]", "*"}, setHeader),
+		xp.CreatePolicy([]string{"search", "[geo,rate]"}, setHeader)}
+
+	tests := []struct {
+		context   []string
+		conflicts int
+	}{
+		// Overlaps with the second service of the first set.
+		{[]string{"*", "reserve"}, 1},
+		// Overlaps with the second and third policies.
+		{[]string{"[profile,search]", "*"}, 2},
+		// Overlaps with no policy.
+		{[]string{"frontend", "[recommend,user]"}, 0},
+	}
+
+	for _, test := range tests {
+		newPolicy := xp.CreatePolicy(test.context, setHeader)
+		conflicts, err := FindConflictingPolicies(policies, newPolicy, applGraph, smt.DefaultExpandOptions)
+		if err != nil {
+			t.Fatalf("Error finding conflicts: %v", err)
+		}
+		if len(conflicts) != test.conflicts {
+			t.Errorf("Expected %d conflicting policies for %v, got %d", test.conflicts, test.context, len(conflicts))
+		}
+	}
+}
+
+func TestConflictsCyclicGraph(t *testing.T) {
+	flag.Parse()
+
+	// A graph with a cycle between B and C.
+	applGraph := make(map[string][]string)
+	applGraph["A"] = []string{"B"}
+	applGraph["B"] = []string{"C"}
+	applGraph["C"] = []string{"B", "D"}
+
+	setHeader := []xp.PolicyFunction{xp.CreatePolicyFunction("set_header", xp.SENDER, true)}
+	policies := []xp.Policy{xp.CreatePolicy([]string{"C", "B", "C"}, setHeader)}
+	newPolicy := xp.CreatePolicy([]string{"B", "C", "B", "C", "D"}, setHeader)
+
+	conflicts, err := FindConflictingPolicies(policies, newPolicy, applGraph, smt.DefaultExpandOptions)
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Errorf("Expected 1 conflicting policy, got %d", len(conflicts))
+	}
+
+	// Policies that do not mutate the CNO cannot conflict, so their paths are not expanded.
+	getHeader := []xp.PolicyFunction{xp.CreatePolicyFunction("get_header", xp.SENDER, false)}
+	readOnly := append(policies, xp.CreatePolicy([]string{"A", "*"}, getHeader))
+	conflicts, err = FindConflictingPolicies(readOnly, newPolicy, applGraph, smt.DefaultExpandOptions)
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Errorf("Expected 1 conflicting policy, got %d", len(conflicts))
+	}
+
+	// A wildcard that follows the cycle has paths of any length, which is reported instead of missing conflicts.
+	newPolicy = xp.CreatePolicy([]string{"*", "C", "D"}, setHeader)
+	_, err = FindConflictingPolicies(policies, newPolicy, applGraph, smt.DefaultExpandOptions)
+	if !errors.Is(err, smt.ErrPathBudgetExceeded) {
+		t.Errorf("Expected the path length to be exceeded, got %v", err)
+	}
+
+	// A small budget is reported instead of expanding forever.
+	_, err = FindConflictingPolicies(policies, newPolicy, applGraph, smt.ExpandOptions{MaxPathLength: 100, MaxPaths: 10})
+	if !errors.Is(err, smt.ErrPathBudgetExceeded) {
+		t.Errorf("Expected the path budget to be exceeded, got %v", err)
+	}
+}
+
+func TestConflictsForGraph(t *testing.T) {
+	flag.Parse()
+
+	setHeader := []xp.PolicyFunction{xp.CreatePolicyFunction("set_header", xp.SENDER, true)}
+	policies := []xp.Policy{xp.CreatePolicy([]string{"A", "*"}, setHeader)}
+	newPolicy := xp.CreatePolicy([]string{"A", "B"}, setHeader)
+
+	g := xp.NewApplicationGraph()
+	g.AddService("A", xp.ServiceAttributes{})
+	g.AddService("B", xp.ServiceAttributes{})
+	g.AddEdge("A", "B", xp.EdgeAttributes{Protocol: xp.PROTOCOL_HTTP})
+
+	conflicts, err := FindConflictingPoliciesForGraph(policies, newPolicy, g, smt.DefaultExpandOptions)
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Errorf("Expected 1 conflicting policy, got %d", len(conflicts))
+	}
+
+	// Invalid graphs are rejected.
+	g.AddEdge("B", "C", xp.EdgeAttributes{})
+	if _, err := FindConflictingPoliciesForGraph(policies, newPolicy, g, smt.DefaultExpandOptions); err == nil {
+		t.Errorf("Expected an error for an invalid graph")
+	}
+}
+
+func TestConflictsLabels(t *testing.T) {
+	flag.Parse()
+
+	g := xp.NewApplicationGraph()
+	g.AddService("fe", xp.ServiceAttributes{Labels: map[string]string{"app": "fe"}})
+	g.AddService("search", xp.ServiceAttributes{Labels: map[string]string{"app": "search"}})
+	g.AddService("geo", xp.ServiceAttributes{})
+	g.AddEdge("fe", "search", xp.EdgeAttributes{})
+	g.AddEdge("search", "geo", xp.EdgeAttributes{})
+
+	setHeader := []xp.PolicyFunction{xp.CreatePolicyFunction("set_header", xp.SENDER, true)}
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"{app=fe}", "search"}, setHeader),
+		xp.CreatePolicy([]string{"{app=search}", "geo"}, setHeader),
+	}
+
+	// The selectors match the labels of the services, in the context of both policies.
+	conflicts, err := FindConflictingPoliciesForGraph(policies, xp.CreatePolicy([]string{"fe", "search"}, setHeader), g, smt.DefaultExpandOptions)
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %v", err)
+	}
+	if len(conflicts) != 1 || !reflect.DeepEqual(conflicts[0].GetContext(), []string{"{app=fe}", "search"}) {
+		t.Errorf("Expected {app=fe}->search to conflict, got %v", conflicts)
+	}
+
+	conflicts, err = FindConflictingPoliciesForGraph(policies, xp.CreatePolicy([]string{"{app=search}", "*"}, setHeader), g, smt.DefaultExpandOptions)
+	if err != nil {
+		t.Fatalf("Error finding conflicts: %v", err)
+	}
+	if len(conflicts) != 1 || !reflect.DeepEqual(conflicts[0].GetContext(), []string{"{app=search}", "geo"}) {
+		t.Errorf("Expected {app=search}->geo to conflict, got %v", conflicts)
+	}
 }
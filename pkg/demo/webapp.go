@@ -1,12 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
 	"net/http"
-	"os"
 	"os/exec"
 	"regexp"
 	"strconv"
@@ -18,19 +19,14 @@ import (
 
 	xp "xPlane"
 	"xPlane/pkg/placement"
+	"xPlane/pkg/placement/smt"
 )
 
-type Application struct {
-	applGraph map[string][]string
-	services  []string
-	policies  []xp.Policy
-}
+var solverName = flag.String("solver", "z3", "Solver used for placement: z3, go, heuristic, portfolio or write")
 
-type Interface struct {
-	cost int
-	egressActions  []string
-	ingressActions []string
-	noTagActions   []string
+type Application struct {
+	graph    *xp.ApplicationGraph
+	policies []xp.Policy
 }
 
 var tmpl = template.Must(template.New("index").Parse(`
@@ -80,7 +76,7 @@ var tmpl = template.Must(template.New("index").Parse(`
         <div class="legend-item"><div class="color-box orange"></div> Dataplane 2</div>
     </div>
 	<div class="img">
-		<img src="image/tmp.png" alt="Graph visualization" />
+		<img alt="Graph visualization" />
 	</div>
 	<script>
         document.getElementById("graphForm").addEventListener("submit", function(event) {
@@ -96,7 +92,7 @@ var tmpl = template.Must(template.New("index").Parse(`
 				"policy": policy
 			};
 
-			// The fetch returns an SVG object in the data. We can append this to the SVG element in the DOM
+			// The fetch returns the placement, with the rendered graph as a data URL in its image field.
             fetch("/submit", {
                 method: "POST",
                 headers: {"Content-Type": "application/json"},
@@ -104,46 +100,25 @@ var tmpl = template.Must(template.New("index").Parse(`
             })
 			.then(response => response.json())
 			.then(data => {
-				document.querySelector(".img img").src = "image/tmp.png?" + new Date().getTime();
+				document.querySelector(".img img").src = data.image;
 			});
         });
     </script>
 </body>
 </html>`))
 
-func findMatchingDataplanes(action string, interfaces []Interface) (matchingDataplanes []int, constraint xp.ConstraintType) {
-	for i, iface := range interfaces {
-		if slices.Contains(iface.egressActions, action) {
-			matchingDataplanes = append(matchingDataplanes, i)
-			constraint = xp.SENDER
-		} else if slices.Contains(iface.ingressActions, action) {
-			matchingDataplanes = append(matchingDataplanes, i)
-			constraint = xp.RECEIVER
-		} else if slices.Contains(iface.noTagActions, action) {
-			matchingDataplanes = append(matchingDataplanes, i)
-			constraint = xp.SENDER_RECEIVER
-		} else {
-			continue
-		}
-	}
-
-	if len(matchingDataplanes) == 0 {
-		return []int{}, xp.SENDER_RECEIVER
-	}
-
-	return
-}
-
-// parseActions parses the actions from the interface string.
+// parseActions parses the actions from the interface string, and registers every interface as a dataplane.
 // This is only a temporary parsing solution in Golang for the demo -- the actual Copper parser is written in Rust.
-func parseActions(input string) (interfaces []Interface) {
+func parseActions(input string) (*xp.DataplaneRegistry, error) {
 	input = strings.TrimSpace(input)
 	interfaceStrs := strings.Split(input, "---")
 
 	costRegex := regexp.MustCompile(`cost: ([0-9]+)`)
+	actRegex := regexp.MustCompile(`act ([a-zA-Z0-9_]+)`)
 	actionRegex := regexp.MustCompile(`action ([a-zA-Z0-9_]+)\(.*\)`)
 
-	for _, iface := range interfaceStrs {
+	registry := xp.NewDataplaneRegistry()
+	for i, iface := range interfaceStrs {
 		matches := costRegex.FindStringSubmatch(iface)
 		cost := 0
 		if len(matches) >= 2 {
@@ -152,9 +127,12 @@ func parseActions(input string) (interfaces []Interface) {
 			}
 		}
 
-		egressActions := make([]string, 0)
-		ingressActions := make([]string, 0)
-		noTagActions := make([]string, 0)
+		name := fmt.Sprintf("dataplane-%d", i)
+		if matches := actRegex.FindStringSubmatch(iface); len(matches) >= 2 {
+			name = matches[1]
+		}
+
+		functions := make([]xp.PolicyFunction, 0)
 
 		var currentTag string		
 		egTag := "[Egress]"
@@ -175,29 +153,26 @@ func parseActions(input string) (interfaces []Interface) {
 				actionName := matches[1]
 				switch currentTag {
 				case "egress":
-					egressActions = append(egressActions, actionName)
+					functions = append(functions, xp.CreatePolicyFunction(actionName, xp.SENDER, true))
 				case "ingress":
-					ingressActions = append(ingressActions, actionName)
+					functions = append(functions, xp.CreatePolicyFunction(actionName, xp.RECEIVER, true))
 				default:
-					noTagActions = append(noTagActions, actionName)
+					functions = append(functions, xp.CreatePolicyFunction(actionName, xp.SENDER_RECEIVER, true))
 				}
 			}
 		}
 
-		interfaces = append(interfaces, Interface{
-			cost: cost,
-			egressActions:  egressActions,
-			ingressActions: ingressActions,
-			noTagActions:   noTagActions,
-		})
+		if _, err := registry.Register(name, cost, functions); err != nil {
+			return nil, err
+		}
 	}
 
-	return
+	return registry, nil
 }
 
 // parsePolicy parses the policy from the policy string.
 // This is only a temporary parsing solution in Golang for the demo -- the actual Copper parser is written in Rust.
-func parsePolicy(policiesStr string, interfaces []Interface) (policies []xp.Policy, err error) {
+func parsePolicy(policiesStr string, registry *xp.DataplaneRegistry) (policies []xp.Policy, err error) {
 	policyStrs := strings.Split(policiesStr, "---")
 	contextRegex := regexp.MustCompile(`context \((.*)\)`)
 	
@@ -210,17 +185,16 @@ func parsePolicy(policiesStr string, interfaces []Interface) (policies []xp.Poli
 			return []xp.Policy{}, fmt.Errorf("context not found in policy")
 		}
 
-		// Split context by '->'
+		// Parse the context, e.g. "frontend->.*->rate".
 		contextStr := contextMatches[1]
 		contextStr = strings.TrimSpace(contextStr)
-		if contextStr[0] == '"' {
-			contextStr = contextStr[1:]
-		}
-		if contextStr[len(contextStr)-1] == '"' {
-			contextStr = contextStr[:len(contextStr)-1]
-		}
+		contextStr = strings.Trim(contextStr, "\"")
 
-		context := strings.Split(contextStr, "->")
+		contextExpr, err := xp.ParseContext(contextStr)
+		if err != nil {
+			return []xp.Policy{}, err
+		}
+		context := contextExpr.Tokens()
 		fmt.Printf("Context: %v\n", context)
 
 		// Extract which actions are used in the policy.
@@ -231,12 +205,10 @@ func parsePolicy(policiesStr string, interfaces []Interface) (policies []xp.Poli
 		policyFunctions := make([]xp.PolicyFunction, 0)
 		for _, match := range actionMatches {
 			action := match[1]
-			matchingDataplanes, constraint := findMatchingDataplanes(action, interfaces)
-			if len(matchingDataplanes) == 0 {
+			function, err := registry.ResolveFunction(action, nil)
+			if err != nil {
 				return []xp.Policy{}, fmt.Errorf("action %s not found in any interface", action)
 			}
-
-			function := xp.CreateNewPolicyFunction(action, constraint, matchingDataplanes, true)
 			policyFunctions = append(policyFunctions, function)
 		}
 
@@ -247,15 +219,17 @@ func parsePolicy(policiesStr string, interfaces []Interface) (policies []xp.Poli
 	return
 }
 
-func renderImg(appl Application, sidecars map[string]int, impls [][]string) error {
+// renderImg renders the application graph with the sidecars and policies of the placement as a PNG image.
+// The image is rendered in memory, so concurrent requests do not share any file.
+func renderImg(appl Application, p *xp.Placement) ([]byte, error) {
 	// Render the application graph in dot format.
 	g := graph.New(graph.StringHash, graph.Directed())
 
 	// Add the services as nodes.
 	colors := []string{"red", "orange", "yellow", "green"}
-	for _, s := range appl.services {
+	for _, s := range appl.graph.GetServices() {
 		// If the service has a sidecar, color it accordingly.
-		sidecar := sidecars[s]
+		sidecar := p.GetDataplaneIndex(s)
 		color := "white"
 		if sidecar != -1 {
 			color = colors[sidecar]
@@ -264,7 +238,7 @@ func renderImg(appl Application, sidecars map[string]int, impls [][]string) erro
 		// Find if the service implements any policy.
 		// Iterate over impls, if s is in impls[i], then add i to the set of policies.
 		policySet := make([]int, 0)
-		for i, impl := range impls {
+		for i, impl := range p.GetImplementations() {
 			if slices.Contains(impl, s) {
 				policySet = append(policySet, i+1)
 			}
@@ -272,8 +246,8 @@ func renderImg(appl Application, sidecars map[string]int, impls [][]string) erro
 		
 		policyStr := ""
 		if len(policySet) > 0 {
-			for _, p := range policySet {
-				policyStr += fmt.Sprintf("P%d ", p)
+			for _, j := range policySet {
+				policyStr += fmt.Sprintf("P%d ", j)
 			}
 		}
 
@@ -281,28 +255,28 @@ func renderImg(appl Application, sidecars map[string]int, impls [][]string) erro
 	}
 
 	// Add the edges.
-	for s, edges := range appl.applGraph {
+	for s, edges := range appl.graph.GetEdges() {
 		for _, e := range edges {
 			g.AddEdge(s, e)
 		}
 	}
 
-	// Write the dot output to a temporary file, tmp.dot.
-	f, err := os.Create("tmp.dot")
-	if err != nil {
-		return err
+	// Write the dot output to a buffer.
+	var dot bytes.Buffer
+	if err := draw.DOT(g, &dot, draw.GraphAttribute("size", "6,4")); err != nil {
+		return nil, err
 	}
-	defer f.Close()
 
-	draw.DOT(g, f, draw.GraphAttribute("size", "6,4"))
-
-	// Execute the dot command to render the graph as a SVG.
-	cmd := exec.Command("dot", "-Tpng", "tmp.dot", "-Gdpi=250", "-o", "image/tmp.png")
+	// Execute the dot command to render the graph as a PNG, from its standard input to its standard output.
+	var png bytes.Buffer
+	cmd := exec.Command("dot", "-Tpng", "-Gdpi=250")
+	cmd.Stdin = &dot
+	cmd.Stdout = &png
 	if err := cmd.Run(); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return png.Bytes(), nil
 }
 
 func main() {
@@ -312,9 +286,6 @@ func main() {
 		tmpl.Execute(w, nil)
 	})
 
-	fs := http.FileServer(http.Dir("image"))
-	http.Handle("/image/", http.StripPrefix("/image/", fs))
-
 	http.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
 		var inputData struct {
 			Graph     struct {
@@ -330,47 +301,63 @@ func main() {
 			return
 		}
 
-		interfaces := parseActions(inputData.Interface)
-		fmt.Printf("Interfaces: %v\n", interfaces)
+		registry, err := parseActions(inputData.Interface)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("Interfaces: %v\n", registry.GetDataplanes())
 
-		policies, err := parsePolicy(inputData.Policy, interfaces)
+		policies, err := parsePolicy(inputData.Policy, registry)
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		
+		applGraph, err := xp.ApplicationGraphFromEdges(inputData.Graph.Edges, inputData.Graph.Nodes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		appl := Application{
-			applGraph: inputData.Graph.Edges,
-			services: inputData.Graph.Nodes,
+			graph:    applGraph,
 			policies: policies,
 		}
 
-		fmt.Printf("Application: %v\n", appl.applGraph)
+		fmt.Printf("Application: %v\n", appl.graph.GetEdges())
 		fmt.Printf("Policies: %v\n", appl.policies)
 
-		// Sidecar costs -- for now, we assume all sidecars have the same cost.
-		sidecarCosts := make([]int, 0)
-		for _, iface := range interfaces {
-			sidecarCosts = append(sidecarCosts, iface.cost)
-		}
-		
 		// Invoke the control plane to find the placements.
 		sidecarAssignment := make(map[string]int)
-		sidecars, impls := placement.GetPlacement(appl.policies, appl.applGraph, appl.services, sidecarAssignment, sidecarCosts)
+		solver, err := smt.NewSolver(*solverName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result, err := placement.GetPlacementForGraphContext(r.Context(), appl.policies, appl.graph, sidecarAssignment, registry.GetDataplanes(), placement.Options{Solver: solver})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 
-		fmt.Printf("Sidecars: %v\n", sidecars)
-		fmt.Printf("Implementations: %v\n", impls)
+		fmt.Printf("Sidecars: %v\n", result.Sidecars)
+		fmt.Printf("Implementations: %v\n", result.GetImplementations())
 
-		err = renderImg(appl, sidecars, impls)
+		img, err := renderImg(appl, result)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Respond with the SVG string in body.
+		// Respond with the placement in body, and the image as a data URL.
+		response := struct {
+			*xp.Placement
+			Image string `json:"image"`
+		}{result, "data:image/png;base64," + base64.StdEncoding.EncodeToString(img)}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode("OK")
+		json.NewEncoder(w).Encode(response)
 	})
 
 	fmt.Printf("Starting server on :8080\n")
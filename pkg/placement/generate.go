@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 
 	xp "xPlane"
 
@@ -30,14 +31,14 @@ const (
 )
 
 // Give random functions based on their probabilities.
-func getRandomFunction(functions []xp.PolicyFunction, probabilities []float64) []int {
+func getRandomFunction(r *rand.Rand, functions []xp.PolicyFunction, probabilities []float64) []int {
 	indexes := make([]int, 0)
 
 	// Repeat until indexes is non-empty.
 	for len(indexes) == 0 {
 		for p, prob := range probabilities {
 			// Get a random number between 0 and 1.
-			randNum := rand.Float64()
+			randNum := r.Float64()
 
 			// If randNum is less than the probability of the func_index function, choose the func_index function.
 			if randNum < prob {
@@ -270,11 +271,18 @@ func GenerateDAG(density float64, graphSize GraphSize) (map[string][]string, []s
 }
 
 func GeneratePolicies(applEdges map[string][]string, numPolicies int) []xp.Policy {
-	// Get a list of all keys in applEdges.
+	return GeneratePoliciesWithRand(rand.New(rand.NewSource(rand.Int63())), applEdges, numPolicies)
+}
+
+// GeneratePoliciesWithRand is GeneratePolicies with the given source of randomness, so that a seeded one
+// generates the same policies for the same graph.
+func GeneratePoliciesWithRand(r *rand.Rand, applEdges map[string][]string, numPolicies int) []xp.Policy {
+	// Get a sorted list of all keys in applEdges.
 	nonLeafServices := make([]string, 0)
 	for k := range applEdges {
 		nonLeafServices = append(nonLeafServices, k)
 	}
+	sort.Strings(nonLeafServices)
 
 	glog.Info("Generating ", numPolicies, " policies")
 
@@ -302,7 +310,7 @@ func GeneratePolicies(applEdges map[string][]string, numPolicies int) []xp.Polic
 		context := make([]string, 0)
 
 		// Start with a random service from nonLeafServices.
-		svc := nonLeafServices[rand.Intn(len(nonLeafServices))]
+		svc := nonLeafServices[r.Intn(len(nonLeafServices))]
 		context = append(context, svc)
 
 		length := 1
@@ -311,7 +319,7 @@ func GeneratePolicies(applEdges map[string][]string, numPolicies int) []xp.Polic
 			// Our choice of start node ensures that there is at least one edge.
 			edges := applEdges[svc]
 			if len(edges) > 0 {
-				context = append(context, edges[rand.Intn(len(edges))])
+				context = append(context, edges[r.Intn(len(edges))])
 				svc = context[len(context)-1]
 			} else {
 				break
@@ -325,7 +333,7 @@ func GeneratePolicies(applEdges map[string][]string, numPolicies int) []xp.Polic
 
 		// For each service in the context, replace with * based on a probability.
 		for j := 0; j < len(context); j++ {
-			if rand.Float64() < 0.25 {
+			if r.Float64() < 0.25 {
 				if j != 0 && context[j-1] != "*" {
 					context[j] = "*"
 				}
@@ -333,7 +341,7 @@ func GeneratePolicies(applEdges map[string][]string, numPolicies int) []xp.Polic
 		}
 
 		// Choose a random subset of functions based on the probabilities.
-		samples := getRandomFunction(functions, probabilities)
+		samples := getRandomFunction(r, functions, probabilities)
 		policyFunctions := make([]xp.PolicyFunction, 0)
 		for _, j := range samples {
 			counts[j] += 1
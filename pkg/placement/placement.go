@@ -1,11 +1,16 @@
 package placement
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 	xp "xPlane"
 	"xPlane/pkg/placement/smt"
 
 	glog "github.com/golang/glog"
+	"golang.org/x/exp/slices"
 )
 
 type platformInfo struct {
@@ -52,9 +57,75 @@ func runParallelSearches(pi platformInfo, targets []int) (int, smtOutput) {
 	return -1, smtOutput{}
 }
 
+// Build the Placement from the solver output: the dataplane index of every service (-1 if none)
+// and the services implementing every policy. An empty dataplane list denotes a single generic sidecar.
+// Label selectors match the labels returned by labels, which is nil if no service has labels.
+func buildPlacement(policies []xp.Policy, applGraph map[string][]string, services []string, labels func(string) map[string]string, dataplanes []xp.Dataplane, sidecars map[string]int, impls [][]string) *xp.Placement {
+	placement := &xp.Placement{
+		Sidecars: make(map[string]string),
+		Policies: make([]xp.PolicyPlacement, len(policies)),
+	}
+
+	dataplaneName := func(i int) string {
+		if i < 0 || i >= len(dataplanes) {
+			return ""
+		}
+		return dataplanes[i].GetName()
+	}
+
+	for _, d := range dataplanes {
+		placement.Dataplanes = append(placement.Dataplanes, d.GetName())
+	}
+
+	for svc, i := range sidecars {
+		if i == -1 {
+			continue
+		}
+		placement.Sidecars[svc] = dataplaneName(i)
+		if i < len(dataplanes) {
+			placement.Cost += dataplanes[i].GetCost()
+		}
+	}
+
+	for j, policy := range policies {
+		placement.Policies[j] = xp.PolicyPlacement{Policy: j, Context: policy.GetContext()}
+		if j >= len(impls) {
+			continue
+		}
+
+		// The policy is enforced at the senders only if all of them implement it.
+		senders, _ := smt.GetEnforcementNodes(policy.GetContext(), applGraph, services, labels)
+		side := xp.RECEIVER
+		if policy.GetConstraint() != xp.RECEIVER && len(senders) > 0 {
+			side = xp.SENDER
+			for _, svc := range senders {
+				if !slices.Contains(impls[j], svc) {
+					side = xp.RECEIVER
+					break
+				}
+			}
+		}
+
+		for _, svc := range impls[j] {
+			placement.Policies[j].EnforcementPoints = append(placement.Policies[j].EnforcementPoints, xp.EnforcementPoint{
+				Service:   svc,
+				Side:      side,
+				Dataplane: dataplaneName(sidecars[svc]),
+			})
+		}
+	}
+
+	return placement
+}
+
 // Find the optimal placement for the given policies by running search in parallel.
 // Requires all dataplane functions to be registered.
-func GetPlacementParallel(policies []xp.Policy, applGraph map[string][]string, services []string, hasSidecars []bool, maxThreads int) ([]string, [][]string) {
+//
+// This uses the deprecated formulation, which places a single kind of sidecar:
+// the dataplane of every sidecar in the result is left empty. See GetPlacementPortfolio to race solvers on the
+// current formulation.
+func GetPlacementParallel(policies []xp.Policy, applGraph map[string][]string, services []string, hasSidecars []bool, maxThreads int) *xp.Placement {
+	start := time.Now()
 	pi := platformInfo{policies, applGraph, services, hasSidecars}
 
 	// Get the optimal placement for the given policies.
@@ -106,60 +177,320 @@ func GetPlacementParallel(policies []xp.Policy, applGraph map[string][]string, s
 	glog.Infof("Optimal placement: %d %v", len(sidecars), sidecars)
 	glog.Infof("Optimal implementations: %v", impls)
 
-	return sidecars, impls
+	sidecarsMap := make(map[string]int)
+	for _, s := range sidecars {
+		sidecarsMap[s] = 0
+	}
+
+	placement := buildPlacement(policies, applGraph, services, nil, nil, sidecarsMap, impls)
+	placement.Cost = len(sidecars)
+	placement.Stats = xp.SolverStats{
+		Solver:     "z3-go",
+		Status:     "sat",
+		Optimal:    sidecars != nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if sidecars == nil {
+		placement.Stats.Status = "unsat"
+	}
+	placement.Fingerprint = xp.ComputeFingerprint(policies, applGraph, services, nil, nil)
+
+	return placement
 }
 
+// ErrModelWritten is returned when the solver only wrote the model, and no placement was computed.
+var ErrModelWritten = errors.New("model written, no placement computed")
+
+// Largest number of request paths the latency budget and the proxy counts of the placements consider.
+const maxRequestPaths = 100000
+
+// ErrNoPlacement is returned when the solver finds no placement for the given policies.
+var ErrNoPlacement = errors.New("no placement found for the given policies")
+
 // Find the optimal placement for the given policies. Requires all dataplane functions to be registered.
-// Uses the z3 solver's SMT-LIB to find the optimal placement.
-func GetPlacement(policies []xp.Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, sidecarCosts []int) (map[string]int, [][]string) {
-	// Generate the SMT-LIB file.
-	err := smt.GenerateOptimizationFile(policies, applGraph, services, sidecarAssignments, sidecarCosts)
+// Uses the z3 solver's SMT-LIB to find the optimal placement, on the components of the reduced model (see smt.Reduce).
+func GetPlacement(policies []xp.Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []xp.Dataplane) (*xp.Placement, error) {
+	return GetPlacementWithSolver(smt.NewReducingSolver(smt.NewZ3Solver()), policies, applGraph, services, sidecarAssignments, dataplanes)
+}
+
+// Find the optimal placement for the given policies with the given solver. See GetPlacement.
+func GetPlacementWithSolver(solver smt.Solver, policies []xp.Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []xp.Dataplane) (*xp.Placement, error) {
+	return GetPlacementWithSolverContext(context.Background(), solver, policies, applGraph, services, sidecarAssignments, dataplanes)
+}
+
+// GetPlacementWithSolverContext is GetPlacementWithSolver, stopped when the context is done. See smt.Solver.SolveContext.
+func GetPlacementWithSolverContext(ctx context.Context, solver smt.Solver, policies []xp.Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []xp.Dataplane) (*xp.Placement, error) {
+	start := time.Now()
+
+	model, err := smt.BuildModel(policies, applGraph, services, sidecarAssignments, xp.GetDataplaneCosts(dataplanes))
 	if err != nil {
-		glog.Error("Error generating SMT-LIB file: ", err)
-		return nil, nil
+		glog.Error("Error building the model: ", err)
+		return nil, err
 	}
 
-	// Run the SMT solver and get the optimal placement for the given policies.
-	_, sidecars, impls := smt.RunSolver(services, len(sidecarCosts), len(policies))
+	return solvePlacement(ctx, start, solver, model, policies, applGraph, services, nil, dataplanes, xp.ComputeFingerprint(policies, applGraph, services, sidecarAssignments, dataplanes))
+}
 
-	// Print the optimal placement.
-	sidecarsMap := make(map[string]int)
-	for i, s := range sidecars {
-		if s != -1 {
-			sidecarsMap[i] = s
+// Find the cheapest placement for the given policies by racing the solvers of the portfolio, see smt.Portfolio.Race.
+// Every placement cheaper than the ones before it is passed to improved as soon as a solver finds it, if improved
+// is not nil. Returns the best placement found by the deadline of the portfolio or of the context: Stats.Optimal
+// tells if a solver proved it optimal, and Stats.Solver is the solver that found it.
+func GetPlacementPortfolio(ctx context.Context, portfolio *smt.Portfolio, policies []xp.Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []xp.Dataplane, improved func(*xp.Placement)) (*xp.Placement, error) {
+	start := time.Now()
+
+	model, err := smt.BuildModel(policies, applGraph, services, sidecarAssignments, xp.GetDataplaneCosts(dataplanes))
+	if err != nil {
+		glog.Error("Error building the model: ", err)
+		return nil, err
+	}
+
+	fingerprint := xp.ComputeFingerprint(policies, applGraph, services, sidecarAssignments, dataplanes)
+	solver := &racingSolver{portfolio: portfolio, model: model, solver: -1}
+	if improved != nil {
+		solver.improved = func(inc smt.Incumbent) {
+			sidecars, impls := model.Decode(inc.Values)
+			placement := buildPlacement(policies, applGraph, services, nil, dataplanes, sidecars, impls)
+			placement.Cost = model.RunningCost(inc.Values)
+			placement.Stats = xp.SolverStats{
+				Solver:       portfolio.Solvers[inc.Solver].Name(),
+				Status:       string(smt.STATUS_SAT),
+				DurationMs:   time.Since(start).Milliseconds(),
+				NumVariables: len(model.Vars),
+				Gap:          -1,
+			}
+			placement.Fingerprint = fingerprint
+			improved(placement)
 		}
 	}
-	// glog.Infof("Optimal placement: %d %v", len(sidecarsMap), sidecarsMap)
-	// glog.Infof("Optimal implementations: %v", impls)
 
-	// Compute cost benefits.
-	maxCost := 0
-	for _, c := range sidecarCosts {
-		if c > maxCost {
-			maxCost = c
+	placement, err := solvePlacement(ctx, start, solver, model, policies, applGraph, services, nil, dataplanes, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if solver.solver != -1 {
+		placement.Stats.Solver = portfolio.Solvers[solver.solver].Name()
+	}
+	return placement, nil
+}
+
+// racingSolver races the solvers of a portfolio. It streams the incumbents of the placement model only, not the
+// ones of the models FindCore solves, and keeps the solver that found its best solution.
+type racingSolver struct {
+	portfolio *smt.Portfolio
+	model     *smt.Model
+	improved  func(smt.Incumbent)
+	solver    int
+}
+
+func (r *racingSolver) Name() string {
+	return r.portfolio.Name()
+}
+
+func (r *racingSolver) Solve(m *smt.Model) (*smt.Result, error) {
+	return r.SolveContext(context.Background(), m)
+}
+
+func (r *racingSolver) SolveContext(ctx context.Context, m *smt.Model) (*smt.Result, error) {
+	if m != r.model {
+		return r.portfolio.SolveContext(ctx, m)
+	}
+
+	race, err := r.portfolio.Race(ctx, m, r.improved)
+	if err != nil {
+		return nil, err
+	}
+	r.solver = race.Solver
+	return race.Result, nil
+}
+
+// Options of the placement of policies on an application graph. The zero value finds the optimal placement with
+// the solver of GetPlacement, under the default cost model. The options combine: e.g. a placement can fit the
+// cluster, stay within a latency budget and migrate from the deployed placement at the same time.
+type Options struct {
+	// Solver of the placement model. If nil, z3 on the components of the reduced model, see GetPlacement.
+	Solver smt.Solver
+
+	// Prices of the resources the dataplanes use, see smt.BuildWeightedModelForGraph. If nil, xp.DefaultCostModel.
+	CostModel *xp.CostModel
+
+	// Cluster the application runs on. If not nil, the dataplanes on every node must fit its capacity, and the
+	// error is a *smt.CapacityError with the nodes over budget if no placement fits.
+	Cluster *xp.Cluster
+
+	// Largest number of sidecars a request crosses on its path through the application (see xp.GetRequestPaths),
+	// or 0 for no limit.
+	LatencyBudget int
+
+	// Deployed placement and cost of migrating from it, see smt.Migration. If not nil, the placement is the cheapest
+	// to run and to migrate to: unlike assigned sidecars, deployed ones can be removed or switched at the cost of the
+	// migration, and Stats.Changes is the number of services that change.
+	Migration *smt.Migration
+
+	// Previous placement, e.g. the one of the policies before a policy was added, removed or changed. The solver
+	// tries it first (see smt.Model.SetHint), which makes solving again after a small change much faster. Unlike
+	// Migration, it changes the time to find the placement, not its cost. A hint with other dataplanes is ignored.
+	Hint *xp.Placement
+}
+
+// Find the optimal placement for the given policies on an application graph, with the given options.
+// Dataplanes are only placed where they support the protocol of the edges they enforce policies on, and their
+// cost is weighted by the replicas and request rates of the services under the cost model. See GetPlacement.
+func GetPlacementForGraph(policies []xp.Policy, g *xp.ApplicationGraph, sidecarAssignments map[string]int, dataplanes []xp.Dataplane, opts Options) (*xp.Placement, error) {
+	return GetPlacementForGraphContext(context.Background(), policies, g, sidecarAssignments, dataplanes, opts)
+}
+
+// GetPlacementForGraphContext is GetPlacementForGraph, stopped when the context is done. See smt.Solver.SolveContext.
+func GetPlacementForGraphContext(ctx context.Context, policies []xp.Policy, g *xp.ApplicationGraph, sidecarAssignments map[string]int, dataplanes []xp.Dataplane, opts Options) (*xp.Placement, error) {
+	start := time.Now()
+
+	solver := opts.Solver
+	if solver == nil {
+		solver = smt.NewReducingSolver(smt.NewZ3Solver())
+	}
+	costModel := xp.DefaultCostModel
+	if opts.CostModel != nil {
+		costModel = *opts.CostModel
+	}
+
+	model, err := smt.BuildWeightedModelForGraph(policies, g, sidecarAssignments, dataplanes, costModel)
+	if err != nil {
+		glog.Error("Error building the model: ", err)
+		return nil, err
+	}
+	if opts.Cluster != nil {
+		if err := model.AddCapacity(opts.Cluster, g, dataplanes); err != nil {
+			glog.Error("Error adding the capacity of the cluster: ", err)
+			return nil, err
+		}
+	}
+	if opts.LatencyBudget > 0 {
+		paths, err := xp.GetRequestPaths(g.GetEdges(), g.GetServices(), maxRequestPaths)
+		if err != nil {
+			glog.Error("Error finding the request paths: ", err)
+			return nil, err
+		}
+		if err := model.AddLatencyBudget(paths, opts.LatencyBudget); err != nil {
+			glog.Error("Error adding the latency budget: ", err)
+			return nil, err
+		}
+	}
+	if opts.Migration != nil {
+		if err := model.AddMigration(*opts.Migration); err != nil {
+			glog.Error("Error adding the migration: ", err)
+			return nil, err
+		}
+	}
+	if opts.Hint != nil {
+		if err := model.SetHint(opts.Hint, policies); err != nil {
+			glog.Warning("Ignoring the previous placement: ", err)
 		}
 	}
-	maxCost *= len(services)
 
-	// Compute the cost of the optimal placement.
-	cost := 0
-	instancesUsed := make(map[int]int)
-	for _, s := range services {
-		sidecar := sidecars[s]
-		if sidecar == -1 {
-			continue
+	fingerprint := xp.ComputeGraphFingerprint(policies, g, sidecarAssignments, dataplanes, costModel, opts.Cluster, opts.LatencyBudget, opts.Migration)
+	placement, err := solvePlacement(ctx, start, solver, model, policies, g.GetEdges(), g.GetServices(), g.GetLabels, dataplanes, fingerprint)
+	if errors.Is(err, ErrNoPlacement) && opts.Cluster != nil {
+		capacityErr, explainErr := smt.ExplainCapacity(ctx, solver, model)
+		if explainErr != nil {
+			glog.Error("Error explaining the placement failure: ", explainErr)
+			return nil, err
+		}
+		if capacityErr == nil {
+			return nil, err
 		}
+		glog.Error("No placement fits the cluster: ", capacityErr)
+		return nil, capacityErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Migration != nil {
+		placement.Stats.Changes = opts.Migration.Changes(placement.GetSidecarAssignment())
+		glog.Infof("Placement changes %d services", placement.Stats.Changes)
+	}
+	return placement, nil
+}
 
-		cost += sidecarCosts[sidecar]
-		instancesUsed[sidecar]++
+// Explain why a model has no solution, from the unsat core of the solver, or one found with FindCore.
+func explainUnsat(ctx context.Context, solver smt.Solver, model *smt.Model, result *smt.Result, policies []xp.Policy, dataplanes []xp.Dataplane) *smt.UnsatError {
+	core := result.Core
+	if core == nil {
+		var err error
+		if core, err = smt.FindCore(ctx, solver, model); err != nil {
+			glog.Warning("Error finding the unsat core: ", err)
+			return nil
+		}
 	}
-	glog.Infof("Cost of optimal placement: %d vs max cost: %d", cost, maxCost)
+
+	explanation := smt.Explain(model, core, policies, dataplanes)
+	for _, line := range explanation {
+		glog.Error("No placement: ", line)
+	}
+	return &smt.UnsatError{Core: core, Explanation: explanation}
+}
+
+// Solve the model and build the placement.
+func solvePlacement(ctx context.Context, start time.Time, solver smt.Solver, model *smt.Model, policies []xp.Policy, applGraph map[string][]string, services []string, labels func(string) map[string]string, dataplanes []xp.Dataplane, fingerprint string) (*xp.Placement, error) {
+	// Run the solver and get the optimal placement for the given policies.
+	result, err := solver.SolveContext(ctx, model)
+	if err != nil {
+		glog.Error("Error running the solver: ", err)
+		return nil, err
+	}
+	if result.Status == smt.STATUS_WRITTEN {
+		return nil, fmt.Errorf("%w to %s", ErrModelWritten, result.File)
+	}
+	if !result.HasSolution() {
+		if ctx.Err() != nil {
+			glog.Error("Placement stopped before finding a solution: ", ctx.Err())
+			return nil, ctx.Err()
+		}
+		glog.Error("No placement found for the given policies")
+		if result.Status == smt.STATUS_UNSAT {
+			if unsatErr := explainUnsat(ctx, solver, model, result, policies, dataplanes); unsatErr != nil {
+				return nil, fmt.Errorf("%w: %w", ErrNoPlacement, unsatErr)
+			}
+		}
+		return nil, fmt.Errorf("%w (%s)", ErrNoPlacement, result.Status)
+	}
+	sidecars, impls := model.Decode(result.Values)
+
+	placement := buildPlacement(policies, applGraph, services, labels, dataplanes, sidecars, impls)
+	placement.Cost = model.RunningCost(result.Values)
+	placement.Stats = xp.SolverStats{
+		Solver:       solver.Name(),
+		Status:       string(result.Status),
+		Optimal:      result.Status == smt.STATUS_OPTIMAL,
+		DurationMs:   time.Since(start).Milliseconds(),
+		NumVariables: len(model.Vars),
+		Gap:          result.Gap,
+	}
+	placement.Fingerprint = fingerprint
+
+	// Find the request path that crosses the most sidecars.
+	if paths, err := xp.GetRequestPaths(applGraph, services, maxRequestPaths); err != nil {
+		glog.Warning("Not counting the proxies on the request paths: ", err)
+	} else {
+		for _, path := range paths {
+			if proxies := placement.CountProxies(path); proxies > placement.Stats.MaxProxies {
+				placement.Stats.MaxProxies = proxies
+				placement.Stats.WorstPath = path
+			}
+		}
+		glog.Infof("At most %d proxies per request, on path %v", placement.Stats.MaxProxies, placement.Stats.WorstPath)
+	}
+
+	instancesUsed := make(map[string]int)
+	for _, d := range placement.Sidecars {
+		instancesUsed[d]++
+	}
+	glog.Infof("Cost of the placement: %d", placement.Cost)
 	glog.Info("Instances used: ", instancesUsed)
 
-	return sidecars, impls
+	return placement, nil
 }
 
-func GetPlacementBatches(policies []xp.Policy, applGraph map[string][]string, services []string, hasSidecars []bool, maxThreads int, batchSize int) ([]string, [][]string) {
+func GetPlacementBatches(policies []xp.Policy, applGraph map[string][]string, services []string, hasSidecars []bool, maxThreads int, batchSize int) *xp.Placement {
 	// Divide the policies into batches.
 	var batches [][]xp.Policy
 	for i := 0; i < len(policies); i += batchSize {
@@ -171,23 +502,19 @@ func GetPlacementBatches(policies []xp.Policy, applGraph map[string][]string, se
 	}
 
 	// Get the optimal placement for the given policies.
-	var sidecars []string
-	var impls [][]string
+	var placement *xp.Placement
 
 	for _, batch := range batches {
-		sidecars, impls = GetPlacementParallel(batch, applGraph, services, hasSidecars, maxThreads)
+		placement = GetPlacementParallel(batch, applGraph, services, hasSidecars, maxThreads)
 
 		// Update hasSidecar.
 		hasSidecars = make([]bool, len(services))
-		for _, s := range sidecars {
-			// Find the index of s in services.
-			for i, svc := range services {
-				if s == svc {
-					hasSidecars[i] = true
-				}
+		for i, svc := range services {
+			if _, ok := placement.Sidecars[svc]; ok {
+				hasSidecars[i] = true
 			}
 		}
 	}
 
-	return sidecars, impls
+	return placement
 }
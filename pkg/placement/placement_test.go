@@ -1,19 +1,37 @@
 package placement
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"math/rand"
 	"os"
+	"os/exec"
+	"path"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	xp "xPlane"
+	"xPlane/pkg/placement/smt"
 
 	histogram "github.com/HdrHistogram/hdrhistogram-go"
 	glog "github.com/golang/glog"
+	"golang.org/x/exp/slices"
 )
 
+// Create dataplanes with the given costs, that only serve as cost carriers for policies with explicit dataplanes.
+func createDataplanes(costs []int) []xp.Dataplane {
+	dataplanes := make([]xp.Dataplane, len(costs))
+	for i, c := range costs {
+		dataplanes[i] = xp.CreateDataplane(fmt.Sprintf("dataplane-%d", i), i, c, nil)
+	}
+	return dataplanes
+}
+
 func constructGraphAndRun(applGraph map[string][]string) (map[string]int, [][]string) {
 	// Create a dummy list of services.
 	servicesMap := make(map[string]int)
@@ -47,13 +65,24 @@ func constructGraphAndRun(applGraph map[string][]string) (map[string]int, [][]st
 		}
 	}
 
-	// Define sidecar costs array.
-	sidecarCosts := []int{100}
+	// Define the dataplanes.
+	dataplanes := createDataplanes([]int{100})
 
 	// Create an empty map for the initial placement.
 	sidecarAssignment := make(map[string]int)
 
-	return GetPlacement(policies, applGraph, services, sidecarAssignment, sidecarCosts)
+	placement, err := GetPlacement(policies, applGraph, services, sidecarAssignment, dataplanes)
+	if err != nil {
+		return nil, nil
+	}
+
+	// Get the dataplane of every service, -1 if it has no sidecar.
+	sidecars := make(map[string]int)
+	for _, svc := range services {
+		sidecars[svc] = placement.GetDataplaneIndex(svc)
+	}
+
+	return sidecars, placement.GetImplementations()
 }
 
 func TestPlacement(t *testing.T) {
@@ -78,19 +107,221 @@ func TestPlacement(t *testing.T) {
 		xp.CreatePolicy([]string{"A", "B"}, functions_p1),
 		xp.CreatePolicy([]string{"A", "C"}, functions_p2)}
 
-	// Define sidecar costs array.
-	sidecarCosts := []int{0, 1, 2}
+	// Define the dataplanes.
+	dataplanes := createDataplanes([]int{0, 1, 2})
 
 	// Create an empty map for the initial placement.
 	sidecarAssignment := make(map[string]int)
 
-	GetPlacement(policies, applGraph, services, sidecarAssignment, sidecarCosts)
+	GetPlacement(policies, applGraph, services, sidecarAssignment, dataplanes)
 }
 
-func TestSocialNetworkPlacement(t *testing.T) {
+func TestPlacementWithSolver(t *testing.T) {
 	flag.Parse()
 
-	// Create a dummy application graph.
+	applGraph := map[string][]string{"A": {"B", "C"}}
+	services := []string{"A", "B", "C"}
+
+	functions_p1 := []xp.PolicyFunction{
+		xp.CreateNewPolicyFunction("set_header", xp.SENDER, []int{0}, true),
+		xp.CreateNewPolicyFunction("get_header", xp.SENDER_RECEIVER, []int{0, 1}, false)}
+
+	functions_p2 := []xp.PolicyFunction{
+		xp.CreateNewPolicyFunction("set_header", xp.SENDER_RECEIVER, []int{2}, true)}
+
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"A", "B"}, functions_p1),
+		xp.CreatePolicy([]string{"A", "C"}, functions_p2)}
+
+	dataplanes := createDataplanes([]int{0, 1, 2})
+
+	// The first policy needs dataplane 0 at A, so the second one is enforced by dataplane 2 at C.
+	placement, err := GetPlacementWithSolver(smt.NewGoSolver(), policies, applGraph, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if placement.Sidecars["A"] != "dataplane-0" || placement.Sidecars["C"] != "dataplane-2" || placement.Cost != 2 {
+		t.Errorf("Unexpected placement %v with cost %d", placement.Sidecars, placement.Cost)
+	}
+	if placement.Stats.Solver != "go" || !placement.Stats.Optimal {
+		t.Errorf("Unexpected solver stats %v", placement.Stats)
+	}
+	verifyPlacement(t, "the go solver", placement, policies, applGraph, services)
+
+	// A canceled placement stops the solver.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetPlacementWithSolverContext(ctx, smt.NewGoSolver(), policies, applGraph, services, map[string]int{}, dataplanes); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the placement to be canceled, got %v", err)
+	}
+
+	// The write-only solver computes no placement.
+	writer := smt.NewWriteOnlySolver(path.Join(t.TempDir(), "model.smt"))
+	if _, err := GetPlacementWithSolver(writer, policies, applGraph, services, map[string]int{}, dataplanes); !errors.Is(err, ErrModelWritten) {
+		t.Errorf("Expected the model to be written only, got %v", err)
+	}
+}
+
+func TestPlacementPortfolio(t *testing.T) {
+	flag.Parse()
+
+	applEdges := hotelReservationGraph()
+	g, err := xp.ApplicationGraphFromEdges(applEdges, nil)
+	if err != nil {
+		t.Fatalf("Invalid graph: %v", err)
+	}
+	services := g.GetServices()
+	policies := GeneratePolicies(applEdges, 2*len(applEdges))
+	dataplanes := createDataplanes([]int{10, 8, 4, 2})
+
+	expected, err := GetPlacementWithSolver(smt.NewGoSolver(), policies, applEdges, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+
+	// Every placement streamed is valid and cheaper than the one before it, and the last one is returned.
+	var streamed []*xp.Placement
+	portfolio := smt.NewPortfolio(smt.NewHeuristicSolver(), smt.NewGoSolver())
+	placement, err := GetPlacementPortfolio(context.Background(), portfolio, policies, applEdges, services, map[string]int{}, dataplanes, func(p *xp.Placement) {
+		streamed = append(streamed, p)
+	})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if placement.Cost != expected.Cost || !placement.Stats.Optimal || placement.Stats.Gap != 0 {
+		t.Errorf("Expected a proven optimal cost %d, got %d with stats %v", expected.Cost, placement.Cost, placement.Stats)
+	}
+	verifyPlacement(t, "the portfolio", placement, policies, applEdges, services)
+
+	if len(streamed) == 0 || streamed[len(streamed)-1].Cost != placement.Cost || streamed[len(streamed)-1].Stats.Solver != placement.Stats.Solver {
+		t.Fatalf("Expected streamed placements ending with the result, got %d", len(streamed))
+	}
+	for k, p := range streamed {
+		verifyPlacement(t, fmt.Sprintf("streamed placement %d", k), p, policies, applEdges, services)
+		if k > 0 && p.Cost >= streamed[k-1].Cost {
+			t.Errorf("Expected streamed placement %d to improve on cost %d, got %d", k, streamed[k-1].Cost, p.Cost)
+		}
+	}
+}
+
+// Replace the last policy with a new random one, as an edit of the policies between two placements.
+func editPolicies(applEdges map[string][]string, policies []xp.Policy) []xp.Policy {
+	edited := append([]xp.Policy{}, policies[:len(policies)-1]...)
+	return append(edited, GeneratePolicies(applEdges, 1)...)
+}
+
+func TestWarmPlacement(t *testing.T) {
+	flag.Parse()
+
+	applEdges := socialNetworkGraph()
+	g, err := xp.ApplicationGraphFromEdges(applEdges, nil)
+	if err != nil {
+		t.Fatalf("Invalid graph: %v", err)
+	}
+	services := g.GetServices()
+	policies := GeneratePolicies(applEdges, 2*len(applEdges))
+	dataplanes := createDataplanes([]int{10, 8, 4, 2})
+
+	previous, err := GetPlacementWithSolver(smt.NewGoSolver(), policies, applEdges, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+
+	// Solving the same policies again keeps the previous placement.
+	placement, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver(), Hint: previous})
+	if err != nil {
+		t.Fatalf("Error computing warm placement: %v", err)
+	}
+	if placement.Cost != previous.Cost || !reflect.DeepEqual(placement.Sidecars, previous.Sidecars) {
+		t.Errorf("Expected the previous sidecars %v, got %v", previous.Sidecars, placement.Sidecars)
+	}
+
+	// After an edit, the warm placement is as cheap as the one solved from scratch.
+	edited := editPolicies(applEdges, policies)
+	expected, err := GetPlacementWithSolver(smt.NewGoSolver(), edited, applEdges, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	for _, solver := range []smt.Solver{smt.NewGoSolver(), smt.NewReducingSolver(smt.NewGoSolver()), smt.NewHeuristicSolver()} {
+		placement, err := GetPlacementForGraph(edited, g, map[string]int{}, dataplanes, Options{Solver: solver, Hint: previous})
+		if err != nil {
+			t.Fatalf("Error computing warm placement with %s: %v", solver.Name(), err)
+		}
+		if placement.Stats.Optimal && placement.Cost != expected.Cost || placement.Cost < expected.Cost {
+			t.Errorf("Expected cost %d with %s, got %d with stats %v", expected.Cost, solver.Name(), placement.Cost, placement.Stats)
+		}
+		verifyPlacement(t, "the warm placement with "+solver.Name(), placement, edited, applEdges, services)
+	}
+
+	// A previous placement with other dataplanes is ignored.
+	placement, err = GetPlacementForGraph(edited, g, map[string]int{}, createDataplanes([]int{10, 8, 4}), Options{Solver: smt.NewGoSolver(), Hint: previous})
+	if err != nil || !placement.Stats.Optimal {
+		t.Errorf("Expected an optimal placement without the previous one, got %v", err)
+	}
+}
+
+// Compare the time to solve the policies after an edit from scratch and from the placement before the edit.
+func BenchmarkWarmPlacement(b *testing.B) {
+	flag.Parse()
+
+	type instance struct {
+		name      string
+		applEdges map[string][]string
+		g         *xp.ApplicationGraph
+	}
+	var instances []instance
+	for _, name := range []string{"social-network", "hotel-reservation"} {
+		applEdges := socialNetworkGraph()
+		if name == "hotel-reservation" {
+			applEdges = hotelReservationGraph()
+		}
+		g, err := xp.ApplicationGraphFromEdges(applEdges, nil)
+		if err != nil {
+			b.Fatalf("Invalid graph %s: %v", name, err)
+		}
+		instances = append(instances, instance{name, applEdges, g})
+	}
+	applEdges, services := GenerateDAG(0.2, SMALL)
+	g, err := xp.ApplicationGraphFromEdges(applEdges, services)
+	if err != nil {
+		b.Fatalf("Invalid graph small-dag: %v", err)
+	}
+	instances = append(instances, instance{"small-dag", applEdges, g})
+
+	dataplanes := createDataplanes([]int{10, 8, 4, 2})
+	for _, inst := range instances {
+		name, applEdges, g := inst.name, inst.applEdges, inst.g
+		policies := GeneratePolicies(applEdges, 2*len(applEdges))
+		previous, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver()})
+		if err != nil {
+			b.Fatalf("Error computing placement for %s: %v", name, err)
+		}
+		edited := editPolicies(applEdges, policies)
+
+		for _, solver := range []smt.Solver{smt.NewGoSolver(), smt.NewReducingSolver(smt.NewGoSolver()), smt.NewHeuristicSolver()} {
+			solverName := solver.Name()
+			if _, ok := solver.(*smt.ReducingSolver); ok {
+				solverName = "reduced-" + solverName
+			}
+			b.Run(fmt.Sprintf("%s/%s/cold", name, solverName), func(b *testing.B) {
+				for k := 0; k < b.N; k++ {
+					if _, err := GetPlacementForGraph(edited, g, map[string]int{}, dataplanes, Options{Solver: solver}); err != nil {
+						b.Fatalf("Error computing placement: %v", err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/%s/warm", name, solverName), func(b *testing.B) {
+				for k := 0; k < b.N; k++ {
+					if _, err := GetPlacementForGraph(edited, g, map[string]int{}, dataplanes, Options{Solver: solver, Hint: previous}); err != nil {
+						b.Fatalf("Error computing placement: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func socialNetworkGraph() map[string][]string {
 	applGraph := make(map[string][]string)
 	applGraph["nginx"] = []string{"social-graph", "user", "compose-post", "user-timeline", "home-timeline"}
 	applGraph["social-graph"] = []string{"user", "graph-mongo", "graph-redis"}
@@ -103,6 +334,344 @@ func TestSocialNetworkPlacement(t *testing.T) {
 	applGraph["user-mention"] = []string{"user-mention-mongo", "user-mention-memcached"}
 	applGraph["post-storage"] = []string{"post-storage-mongo", "post-storage-redis"}
 
+	return applGraph
+}
+
+func hotelReservationGraph() map[string][]string {
+	applGraph := make(map[string][]string)
+	applGraph["frontend"] = []string{"recommend", "user", "profile", "search", "reserve"}
+	applGraph["search"] = []string{"geo", "rate"}
+	applGraph["recommend"] = []string{"recommend-mongo"}
+	applGraph["reserve"] = []string{"reserve-mongo", "reserve-memc"}
+	applGraph["user"] = []string{"user-mongo"}
+	applGraph["rate"] = []string{"rate-mongo", "rate-memc"}
+	applGraph["geo"] = []string{"geo-mongo"}
+	applGraph["profile"] = []string{"profile-mongo", "profile-memc"}
+
+	return applGraph
+}
+
+func onlineBoutiqueGraph() map[string][]string {
+	applGraph := make(map[string][]string)
+	applGraph["frontend"] = []string{"ad", "recommendation", "catalog", "cart", "shipping", "checkout", "currency"}
+	applGraph["checkout"] = []string{"catalog", "cart", "shipping", "currency", "payment", "email"}
+	applGraph["cart"] = []string{"redis-cache"}
+	applGraph["recommendation"] = []string{"catalog"}
+
+	return applGraph
+}
+
+func TestIncrementalPlacement(t *testing.T) {
+	flag.Parse()
+
+	applGraph := hotelReservationGraph()
+	g, _ := xp.ApplicationGraphFromEdges(applGraph, nil)
+	services := g.GetServices()
+	dataplanes := createDataplanes([]int{1, 2})
+
+	// Dataplane 1 supports both functions, dataplane 0 only counts.
+	countFunc := xp.CreateNewPolicyFunction("count", xp.SENDER_RECEIVER, []int{0, 1}, false)
+	routeFunc := xp.CreateNewPolicyFunction("route", xp.SENDER, []int{1}, true)
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"frontend", "search"}, []xp.PolicyFunction{countFunc}),
+		xp.CreatePolicy([]string{"search", "*"}, []xp.PolicyFunction{countFunc}),
+		xp.CreatePolicy([]string{"*", "profile"}, []xp.PolicyFunction{countFunc}),
+	}
+
+	deployed, err := GetPlacementWithSolver(smt.NewGoSolver(), policies, applGraph, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+
+	// A new policy needs dataplane 1 at frontend.
+	policies = append(policies, xp.CreatePolicy([]string{"frontend", "*"}, []xp.PolicyFunction{routeFunc}))
+
+	// Migrating for free gives the optimal placement from scratch.
+	migration := smt.Migration{Deployed: deployed.GetSidecarAssignment()}
+	fresh, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver(), Migration: &migration})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	optimal, _ := GetPlacementWithSolver(smt.NewGoSolver(), policies, applGraph, services, map[string]int{}, dataplanes)
+	if fresh.Cost != optimal.Cost {
+		t.Errorf("Expected cost %d, got %d", optimal.Cost, fresh.Cost)
+	}
+
+	// Costly changes keep the deployed sidecars and only add the one the new policy needs, without a limit on
+	// the number of changes.
+	migration.Cost = smt.MigrationCost{Add: 10, Remove: 10, Switch: 10}
+	incremental, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver(), Migration: &migration})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if incremental.Stats.Changes != 1 || incremental.Sidecars["frontend"] != "dataplane-1" {
+		t.Errorf("Expected one change at frontend, got %v with %d changes", incremental.Sidecars, incremental.Stats.Changes)
+	}
+	if incremental.Stats.Changes > fresh.Stats.Changes {
+		t.Errorf("Expected at most %d changes, got %d", fresh.Stats.Changes, incremental.Stats.Changes)
+	}
+	verifyPlacement(t, "the incremental placement", incremental, policies, applGraph, services)
+
+	// No changes leave the new policy without a dataplane.
+	migration.MaxChanges = smt.NO_CHANGES
+	_, err = GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver(), Migration: &migration})
+	var unsatErr *smt.UnsatError
+	if !errors.Is(err, ErrNoPlacement) || !errors.As(err, &unsatErr) {
+		t.Fatalf("Expected no placement without changes, got %v", err)
+	}
+	expected := []string{
+		"policy 3 [frontend *] needs SENDER enforcement on {frontend}",
+		"the migration limits the number of services whose dataplane changes",
+	}
+	if !reflect.DeepEqual(unsatErr.Explanation, expected) {
+		t.Errorf("Expected explanation %q, got %q", expected, unsatErr.Explanation)
+	}
+}
+
+func TestPlacementWithCapacity(t *testing.T) {
+	flag.Parse()
+
+	g, _ := xp.ApplicationGraphFromEdges(hotelReservationGraph(), nil)
+
+	r := xp.NewDataplaneRegistry()
+	r.Register("envoy", 1, nil)
+	r.SetResources("envoy", xp.ResourceProfile{CPUPerInstance: 10, MemoryPerInstance: 50})
+
+	countFunc := xp.CreateNewPolicyFunction("count", xp.SENDER_RECEIVER, []int{0}, false)
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"frontend", "search"}, []xp.PolicyFunction{countFunc}),
+		xp.CreatePolicy([]string{"search", "geo"}, []xp.PolicyFunction{countFunc}),
+	}
+
+	// Every service runs a pod on its own node.
+	cluster := &xp.Cluster{Nodes: make(map[string]xp.NodeCapacity)}
+	for _, svc := range g.GetServices() {
+		cluster.Nodes["node-"+svc] = xp.NodeCapacity{CPU: 100, Memory: 100}
+		cluster.Pods = append(cluster.Pods, xp.Pod{Name: svc + "-0", Service: svc, Node: "node-" + svc})
+	}
+
+	placement, err := GetPlacementForGraph(policies, g, map[string]int{}, r.GetDataplanes(), Options{Solver: smt.NewGoSolver(), Cluster: cluster})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if _, ok := placement.Sidecars["search"]; !ok || len(placement.Sidecars) != 1 {
+		t.Errorf("Expected a single sidecar at search, got %v", placement.Sidecars)
+	}
+
+	// Search enforces both policies or none, and cannot fit a dataplane anymore.
+	cluster.Nodes["node-search"] = xp.NodeCapacity{CPU: 100, Memory: 10}
+	placement, err = GetPlacementForGraph(policies, g, map[string]int{}, r.GetDataplanes(), Options{Solver: smt.NewGoSolver(), Cluster: cluster})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if _, ok := placement.Sidecars["search"]; ok || len(placement.Sidecars) != 2 {
+		t.Errorf("Expected sidecars at frontend and geo, got %v", placement.Sidecars)
+	}
+
+	// Frontend only sends requests to search.
+	cluster.Nodes["node-frontend"] = xp.NodeCapacity{}
+	_, err = GetPlacementForGraph(policies, g, map[string]int{}, r.GetDataplanes(), Options{Solver: smt.NewGoSolver(), Cluster: cluster})
+	var capacityErr *smt.CapacityError
+	if !errors.As(err, &capacityErr) {
+		t.Fatalf("Expected a capacity error, got %v", err)
+	}
+	for _, o := range capacityErr.Overloads {
+		if o.Node != "node-search" && o.Node != "node-frontend" {
+			t.Errorf("Unexpected overloaded node %s", o)
+		}
+	}
+}
+
+func TestPlacementWithLatencyBudget(t *testing.T) {
+	flag.Parse()
+
+	g := xp.NewApplicationGraph()
+	g.AddService("frontend", xp.ServiceAttributes{})
+	g.AddService("search", xp.ServiceAttributes{})
+	g.AddService("geo", xp.ServiceAttributes{})
+	g.AddService("rate", xp.ServiceAttributes{Replicas: 3})
+	g.AddEdge("frontend", "search", xp.EdgeAttributes{})
+	g.AddEdge("search", "geo", xp.EdgeAttributes{})
+	g.AddEdge("search", "rate", xp.EdgeAttributes{})
+	dataplanes := createDataplanes([]int{1})
+
+	senderFunc := xp.CreateNewPolicyFunction("sender", xp.SENDER, []int{0}, false)
+	receiverFunc := xp.CreateNewPolicyFunction("receiver", xp.RECEIVER, []int{0}, false)
+	countFunc := xp.CreateNewPolicyFunction("count", xp.SENDER_RECEIVER, []int{0}, false)
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"frontend", "search"}, []xp.PolicyFunction{senderFunc}),
+		xp.CreatePolicy([]string{"search", "geo"}, []xp.PolicyFunction{receiverFunc}),
+		xp.CreatePolicy([]string{"search", "rate"}, []xp.PolicyFunction{countFunc}),
+	}
+
+	// The cheapest placement enforces the last policy at search, so requests to geo cross three proxies.
+	placement, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver()})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if placement.Stats.MaxProxies != 3 || !reflect.DeepEqual(placement.Stats.WorstPath, []string{"frontend", "search", "geo"}) {
+		t.Errorf("Expected 3 proxies on the path to geo, got %d on %v", placement.Stats.MaxProxies, placement.Stats.WorstPath)
+	}
+
+	// With two proxies at most, the replicas of rate enforce it instead.
+	unbounded := placement
+	placement, err = GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver(), LatencyBudget: 2})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if placement.Fingerprint == unbounded.Fingerprint {
+		t.Errorf("Expected the fingerprint to depend on the latency budget")
+	}
+	if placement.Stats.MaxProxies != 2 || placement.Cost != 5 {
+		t.Errorf("Expected 2 proxies and cost 5, got %d proxies and cost %d", placement.Stats.MaxProxies, placement.Cost)
+	}
+	if _, ok := placement.Sidecars["rate"]; !ok {
+		t.Errorf("Expected a sidecar at rate, got %v", placement.Sidecars)
+	}
+
+	// Requests to geo cross at least the sidecars of frontend and geo.
+	if _, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver(), LatencyBudget: 1}); !errors.Is(err, ErrNoPlacement) {
+		t.Errorf("Expected no placement, got %v", err)
+	}
+}
+
+func TestPlacementOptions(t *testing.T) {
+	flag.Parse()
+
+	g := xp.NewApplicationGraph()
+	g.AddService("frontend", xp.ServiceAttributes{})
+	g.AddService("search", xp.ServiceAttributes{})
+	g.AddService("geo", xp.ServiceAttributes{})
+	g.AddService("rate", xp.ServiceAttributes{Replicas: 3})
+	g.AddEdge("frontend", "search", xp.EdgeAttributes{})
+	g.AddEdge("search", "geo", xp.EdgeAttributes{})
+	g.AddEdge("search", "rate", xp.EdgeAttributes{})
+
+	r := xp.NewDataplaneRegistry()
+	r.Register("envoy", 1, nil)
+	r.SetResources("envoy", xp.ResourceProfile{CPUPerInstance: 10, MemoryPerInstance: 50})
+	dataplanes := r.GetDataplanes()
+
+	senderFunc := xp.CreateNewPolicyFunction("sender", xp.SENDER, []int{0}, false)
+	receiverFunc := xp.CreateNewPolicyFunction("receiver", xp.RECEIVER, []int{0}, false)
+	countFunc := xp.CreateNewPolicyFunction("count", xp.SENDER_RECEIVER, []int{0}, false)
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"frontend", "search"}, []xp.PolicyFunction{senderFunc}),
+		xp.CreatePolicy([]string{"search", "geo"}, []xp.PolicyFunction{receiverFunc}),
+		xp.CreatePolicy([]string{"search", "rate"}, []xp.PolicyFunction{countFunc}),
+	}
+
+	// Every pod runs on the node of its service.
+	cluster := &xp.Cluster{Nodes: make(map[string]xp.NodeCapacity)}
+	for _, svc := range g.GetServices() {
+		cluster.Nodes["node-"+svc] = xp.NodeCapacity{CPU: 1000, Memory: 1000}
+		for k := 0; k < g.GetReplicas(svc); k++ {
+			cluster.Pods = append(cluster.Pods, xp.Pod{Name: fmt.Sprintf("%s-%d", svc, k), Service: svc, Node: "node-" + svc})
+		}
+	}
+
+	// The deployed placement enforces the last policy at search.
+	deployed, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver()})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if _, ok := deployed.Sidecars["search"]; !ok || len(deployed.Sidecars) != 3 {
+		t.Fatalf("Expected sidecars at frontend, search and geo, got %v", deployed.Sidecars)
+	}
+
+	// Within two proxies, migrating moves the sidecar of search to rate, which fits its node.
+	opts := Options{
+		Solver:        smt.NewGoSolver(),
+		Cluster:       cluster,
+		LatencyBudget: 2,
+		Migration:     &smt.Migration{Deployed: deployed.GetSidecarAssignment(), Cost: smt.MigrationCost{Add: 1, Remove: 1, Switch: 1}, MaxChanges: 2},
+		Hint:          deployed,
+	}
+	placement, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, opts)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if _, ok := placement.Sidecars["rate"]; !ok || len(placement.Sidecars) != 3 {
+		t.Errorf("Expected sidecars at frontend, geo and rate, got %v", placement.Sidecars)
+	}
+	if placement.Stats.MaxProxies != 2 || placement.Stats.Changes != 2 {
+		t.Errorf("Expected 2 proxies and 2 changes, got %d proxies and %d changes", placement.Stats.MaxProxies, placement.Stats.Changes)
+	}
+	if placement.Fingerprint == deployed.Fingerprint {
+		t.Errorf("Expected the fingerprint to depend on the options")
+	}
+	verifyPlacement(t, "the placement with options", placement, policies, g.GetEdges(), g.GetServices())
+
+	// A single change cannot both remove search and add rate.
+	opts.Migration.MaxChanges = 1
+	if _, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, opts); !errors.Is(err, ErrNoPlacement) {
+		t.Errorf("Expected no placement with a single change, got %v", err)
+	}
+
+	// The three pods of rate do not fit their node anymore.
+	opts.Migration.MaxChanges = 2
+	cluster.Nodes["node-rate"] = xp.NodeCapacity{CPU: 1000, Memory: 100}
+	_, err = GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, opts)
+	var capacityErr *smt.CapacityError
+	if !errors.As(err, &capacityErr) {
+		t.Fatalf("Expected a capacity error, got %v", err)
+	}
+	if len(capacityErr.Overloads) != 1 || capacityErr.Overloads[0].Node != "node-rate" {
+		t.Errorf("Expected node-rate over budget, got %v", capacityErr)
+	}
+}
+
+// Create a graph where two frontends have the label app=fe, and an admin service without labels also calls search.
+func labeledGraph() *xp.ApplicationGraph {
+	g := xp.NewApplicationGraph()
+	g.AddService("fe-web", xp.ServiceAttributes{Labels: map[string]string{"app": "fe"}})
+	g.AddService("fe-mobile", xp.ServiceAttributes{Labels: map[string]string{"app": "fe"}})
+	g.AddService("admin", xp.ServiceAttributes{})
+	g.AddService("search", xp.ServiceAttributes{})
+	g.AddService("geo", xp.ServiceAttributes{})
+	g.AddEdge("fe-web", "search", xp.EdgeAttributes{})
+	g.AddEdge("fe-mobile", "search", xp.EdgeAttributes{})
+	g.AddEdge("admin", "search", xp.EdgeAttributes{})
+	g.AddEdge("search", "geo", xp.EdgeAttributes{})
+	return g
+}
+
+func TestLabelSelectors(t *testing.T) {
+	flag.Parse()
+
+	g := labeledGraph()
+	dataplanes := createDataplanes([]int{1})
+	senderFunc := xp.CreateNewPolicyFunction("sender", xp.SENDER, []int{0}, false)
+	policies := []xp.Policy{xp.CreatePolicy([]string{"{app=fe}", "search"}, []xp.PolicyFunction{senderFunc})}
+
+	// The policy is enforced at the senders with the label only.
+	placement, err := GetPlacementForGraph(policies, g, map[string]int{}, dataplanes, Options{Solver: smt.NewGoSolver()})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if placement.Cost != 2 || !reflect.DeepEqual(placement.Sidecars, map[string]string{"fe-web": "dataplane-0", "fe-mobile": "dataplane-0"}) {
+		t.Errorf("Expected sidecars at the frontends only, got %v with cost %d", placement.Sidecars, placement.Cost)
+	}
+	for _, ep := range placement.Policies[0].EnforcementPoints {
+		if ep.Side != xp.SENDER {
+			t.Errorf("Expected the policy to be enforced on the sender side, got %v", ep)
+		}
+	}
+
+	violations, err := Verify(placement, policies, g)
+	if err != nil {
+		t.Fatalf("Error verifying the placement: %v", err)
+	}
+	if violations != nil {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+}
+
+func TestSocialNetworkPlacement(t *testing.T) {
+	flag.Parse()
+
+	applGraph := socialNetworkGraph()
+
 	// Run for the given application graph.
 	start := time.Now()
 	constructGraphAndRun(applGraph)
@@ -114,16 +683,7 @@ func TestSocialNetworkPlacement(t *testing.T) {
 func TestHotelReservationPlacement(t *testing.T) {
 	flag.Parse()
 
-	// Create a dummy application graph.
-	applGraph := make(map[string][]string)
-	applGraph["frontend"] = []string{"recommend", "user", "profile", "search", "reserve"}
-	applGraph["search"] = []string{"geo", "rate"}
-	applGraph["recommend"] = []string{"recommend-mongo"}
-	applGraph["reserve"] = []string{"reserve-mongo", "reserve-memc"}
-	applGraph["user"] = []string{"user-mongo"}
-	applGraph["rate"] = []string{"rate-mongo", "rate-memc"}
-	applGraph["geo"] = []string{"geo=mongo"}
-	applGraph["profile"] = []string{"profile-mongo", "profile-memc"}
+	applGraph := hotelReservationGraph()
 
 	// Run for the given application graph.
 	start := time.Now()
@@ -136,12 +696,7 @@ func TestHotelReservationPlacement(t *testing.T) {
 func TestOnlineBoutiquePlacement(t *testing.T) {
 	flag.Parse()
 
-	// Create a dummy application graph.
-	applGraph := make(map[string][]string)
-	applGraph["frontend"] = []string{"ad", "recommendation", "catalog", "cart", "shipping", "checkout", "currency"}
-	applGraph["checkout"] = []string{"catalog", "cart", "shipping", "currency", "payment", "email"}
-	applGraph["cart"] = []string{"redis-cache"}
-	applGraph["recommendation"] = []string{"catalog"}
+	applGraph := onlineBoutiqueGraph()
 
 	// Run for the given application graph.
 	start := time.Now()
@@ -151,6 +706,127 @@ func TestOnlineBoutiquePlacement(t *testing.T) {
 	glog.Info("Time: ", elapsed.Milliseconds(), " ms")
 }
 
+func TestGoSolverDeathStarBench(t *testing.T) {
+	flag.Parse()
+
+	type instance struct {
+		name      string
+		applEdges map[string][]string
+		services  []string
+	}
+	instances := make([]instance, 0)
+	for name, applEdges := range map[string]map[string][]string{
+		"social-network":    socialNetworkGraph(),
+		"hotel-reservation": hotelReservationGraph(),
+		"online-boutique":   onlineBoutiqueGraph(),
+	} {
+		g, err := xp.ApplicationGraphFromEdges(applEdges, nil)
+		if err != nil {
+			t.Fatalf("Invalid graph %s: %v", name, err)
+		}
+		instances = append(instances, instance{name, applEdges, g.GetServices()})
+	}
+	applEdges, services := GenerateDAG(0.2, SMALL)
+	instances = append(instances, instance{"small-dag", applEdges, services})
+
+	// Cross-check the optimal costs against z3 if it is installed.
+	_, err := exec.LookPath("z3")
+	z3 := err == nil
+	if !z3 {
+		t.Log("z3 not found, not cross-checking the costs")
+	}
+
+	dataplanes := createDataplanes([]int{10, 8, 4, 2})
+	for _, inst := range instances {
+		for k := 0; k < 3; k++ {
+			policies := GeneratePolicies(inst.applEdges, 2*len(inst.applEdges))
+			m, err := smt.BuildModel(policies, inst.applEdges, inst.services, map[string]int{}, xp.GetDataplaneCosts(dataplanes))
+			if err != nil {
+				t.Fatalf("Error building model for %s: %v", inst.name, err)
+			}
+
+			start := time.Now()
+			result, err := smt.NewGoSolver().Solve(m)
+			if err != nil || result.Status != smt.STATUS_OPTIMAL {
+				t.Fatalf("Expected an optimal placement for %s, got %v %v", inst.name, result, err)
+			}
+			if err := m.Check(result.Values); err != nil {
+				t.Errorf("Expected a feasible placement for %s: %v", inst.name, err)
+			}
+			glog.Infof("%s: %d policies, cost %d in %d ms", inst.name, len(policies), result.Cost, time.Since(start).Milliseconds())
+			sidecars, impls := m.Decode(result.Values)
+			verifyPlacement(t, inst.name, buildPlacement(policies, inst.applEdges, inst.services, nil, dataplanes, sidecars, impls), policies, inst.applEdges, inst.services)
+
+			// The reduced model has the same optimal cost.
+			reduced, err := smt.NewReducingSolver(smt.NewGoSolver()).Solve(m)
+			if err != nil || reduced.Status != smt.STATUS_OPTIMAL || reduced.Cost != result.Cost {
+				t.Fatalf("Expected an optimal reduced placement for %s with cost %d, got %v %v", inst.name, result.Cost, reduced, err)
+			}
+			sidecars, impls = m.Decode(reduced.Values)
+			verifyPlacement(t, inst.name+" reduced", buildPlacement(policies, inst.applEdges, inst.services, nil, dataplanes, sidecars, impls), policies, inst.applEdges, inst.services)
+
+			// The heuristic finds a feasible placement, no cheaper than the optimal one.
+			heuristic, err := smt.NewHeuristicSolver().Solve(m)
+			if err != nil || !heuristic.HasSolution() || m.Check(heuristic.Values) != nil {
+				t.Fatalf("Expected a feasible heuristic placement for %s, got %v %v", inst.name, heuristic, err)
+			}
+			if heuristic.Cost < result.Cost {
+				t.Errorf("Expected a heuristic cost of at least %d for %s, got %d", result.Cost, inst.name, heuristic.Cost)
+			}
+			glog.Infof("%s: heuristic cost %d", inst.name, heuristic.Cost)
+			sidecars, impls = m.Decode(heuristic.Values)
+			verifyPlacement(t, inst.name+" with the heuristic", buildPlacement(policies, inst.applEdges, inst.services, nil, dataplanes, sidecars, impls), policies, inst.applEdges, inst.services)
+
+			if !z3 {
+				continue
+			}
+			z3Solver := smt.NewZ3Solver()
+			z3Solver.File = path.Join(t.TempDir(), "model.smt")
+			expected, err := z3Solver.Solve(m)
+			if err != nil || !expected.HasSolution() {
+				t.Fatalf("Error solving %s with z3: %v %v", inst.name, expected, err)
+			}
+			if result.Cost != expected.Cost {
+				t.Errorf("Expected cost %d for %s, got %d", expected.Cost, inst.name, result.Cost)
+			}
+			sidecars, impls = m.Decode(expected.Values)
+			verifyPlacement(t, inst.name+" with z3", buildPlacement(policies, inst.applEdges, inst.services, nil, dataplanes, sidecars, impls), policies, inst.applEdges, inst.services)
+		}
+	}
+}
+
+func TestHeuristicLargeGraph(t *testing.T) {
+	flag.Parse()
+
+	// A graph with thousands of services, every one called by one or two of the services before it.
+	r := rand.New(rand.NewSource(1))
+	numServices := 3000
+	services := make([]string, numServices)
+	applEdges := make(map[string][]string)
+	for k := range services {
+		services[k] = fmt.Sprintf("svc-%d", k)
+		for c := 0; k > 0 && c < 2; c++ {
+			caller := services[r.Intn(k)]
+			if c == 0 || !slices.Contains(applEdges[caller], services[k]) {
+				applEdges[caller] = append(applEdges[caller], services[k])
+			}
+		}
+	}
+	policies := GeneratePoliciesWithRand(r, applEdges, 2*len(applEdges))
+	dataplanes := createDataplanes([]int{10, 8, 4, 2})
+
+	start := time.Now()
+	placement, err := GetPlacementWithSolver(smt.NewHeuristicSolver(), policies, applEdges, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	if placement.Stats.Solver != "heuristic" || placement.Stats.Optimal || placement.Stats.Gap != -1 {
+		t.Errorf("Unexpected solver stats %v", placement.Stats)
+	}
+	glog.Infof("Heuristic placement of %d policies on %d services: cost %d in %d ms", len(policies), numServices, placement.Cost, time.Since(start).Milliseconds())
+	verifyPlacement(t, "the large graph", placement, policies, applEdges, services)
+}
+
 var fileName = flag.String("file", "placement_test", "File to read the DAG from")
 var generate = flag.Bool("generate", false, "Generate a random DAG")
 var fast = flag.Bool("fast", false, "Use the fast solver")
@@ -393,9 +1069,9 @@ func TestComplete(t *testing.T) {
 			GetPlacementParallel(policies, applEdges, services, hasSidecar, *threads)
 		} else {
 			// Using 4 sidecars.
-			sidecarCosts := []int{10, 8, 4, 2}
+			dataplanes := createDataplanes([]int{10, 8, 4, 2})
 			sidecarAssignment := make(map[string]int)
-			GetPlacement(policies, applEdges, services, sidecarAssignment, sidecarCosts)
+			GetPlacement(policies, applEdges, services, sidecarAssignment, dataplanes)
 		}
 	}
 
@@ -429,13 +1105,16 @@ func TestAdditionalPolicy(t *testing.T) {
 	// }
 
 	sidecarAssignment := make(map[string]int)
-	sidecarCosts := []int{10, 8, 4, 2}
+	dataplanes := createDataplanes([]int{10, 8, 4, 2})
 
 	// Get the optimal placement for the given policies.
-	updatedAssignments, _ := GetPlacement(policies, applEdges, services, sidecarAssignment, sidecarCosts)
+	placement, err := GetPlacement(policies, applEdges, services, sidecarAssignment, dataplanes)
+	if err != nil {
+		t.Fatal("Error getting placement: ", err)
+	}
 
 	// Update the sidecar assignment.
-	for k, v := range updatedAssignments {
+	for k, v := range placement.GetSidecarAssignment() {
 		sidecarAssignment[k] = v
 	}
 
@@ -447,7 +1126,7 @@ func TestAdditionalPolicy(t *testing.T) {
 
 		// Get the optimal placement for the given policies.
 		start := time.Now()
-		GetPlacement(policies, applEdges, services, sidecarAssignment, sidecarCosts)
+		GetPlacement(policies, applEdges, services, sidecarAssignment, dataplanes)
 		elapsed := time.Since(start)
 
 		times[i] = float64(elapsed.Milliseconds())
@@ -0,0 +1,189 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"xPlane"
+
+	"github.com/golang/glog"
+)
+
+// Prefix of the names of the capacity constraints, followed by the resource and the index of the node.
+const capacityPrefix = "capacity_"
+
+// AddCapacity limits the CPU and the memory the dataplanes use on every node of the cluster to its capacity.
+// Every pod of a service runs an instance of the dataplane of the service, which uses the resources of the
+// dataplane for its share of the requests of the service, see ResourceProfile.InstanceUsage.
+//
+// The usage of the pods of a service on a node is rounded up to whole millicores and MiB, and the capacity of
+// the node is rounded down, so that no placement that fits the model overloads a node.
+func (m *Model) AddCapacity(c *xPlane.Cluster, g *xPlane.ApplicationGraph, dataplanes []xPlane.Dataplane) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if len(dataplanes) != m.NumDataplanes() {
+		return fmt.Errorf("expected %d dataplanes, got %d", m.NumDataplanes(), len(dataplanes))
+	}
+
+	svcMap := getSvcMapFromList(m.Services)
+	m.Nodes = c.GetNodes()
+	nodeMap := getSvcMapFromList(m.Nodes)
+	rates := g.GetRequestRates()
+
+	cpu := make([][]Term, len(m.Nodes))
+	memory := make([][]Term, len(m.Nodes))
+
+	pods := make(map[string][]xPlane.Pod)
+	for _, pod := range c.Pods {
+		if _, ok := svcMap[pod.Service]; !ok {
+			glog.Warningf("Pod %s of service %s is not in the graph", pod.Name, pod.Service)
+			continue
+		}
+		pods[pod.Service] = append(pods[pod.Service], pod)
+	}
+
+	for s, svc := range m.Services {
+		if len(pods[svc]) == 0 {
+			continue
+		}
+
+		// Number of pods of the service on every node.
+		count := make([]int, len(m.Nodes))
+		for _, pod := range pods[svc] {
+			count[nodeMap[pod.Node]]++
+		}
+
+		for _, d := range dataplanes {
+			i := d.GetIndex()
+			podCPU, podMemory := d.GetResources().InstanceUsage(len(pods[svc]), rates[svc])
+			for n, k := range count {
+				if k == 0 {
+					continue
+				}
+				if coef := int(math.Ceil(float64(k) * podCPU)); coef != 0 {
+					cpu[n] = append(cpu[n], Term{coef, m.X[i][s]})
+				}
+				if coef := int(math.Ceil(float64(k) * podMemory)); coef != 0 {
+					memory[n] = append(memory[n], Term{coef, m.X[i][s]})
+				}
+			}
+		}
+	}
+
+	for n, node := range m.Nodes {
+		capacity := c.Nodes[node]
+		if len(cpu[n]) > 0 {
+			m.Add(fmt.Sprintf("%scpu_%d", capacityPrefix, n), cpu[n], LE, int(math.Floor(capacity.CPU)))
+		}
+		if len(memory[n]) > 0 {
+			m.Add(fmt.Sprintf("%smemory_%d", capacityPrefix, n), memory[n], LE, int(math.Floor(capacity.Memory)))
+		}
+	}
+
+	return nil
+}
+
+// Get the resource and the node of a capacity constraint, or false if the constraint is not a capacity constraint.
+func (m *Model) capacityOf(c *Constraint) (string, string, bool) {
+	name, ok := strings.CutPrefix(c.Name, capacityPrefix)
+	if !ok {
+		return "", "", false
+	}
+	k := strings.LastIndex(name, "_")
+	if k == -1 {
+		return "", "", false
+	}
+	n, err := strconv.Atoi(name[k+1:])
+	if err != nil || n < 0 || n >= len(m.Nodes) {
+		return "", "", false
+	}
+	return name[:k], m.Nodes[n], true
+}
+
+// Get a copy of the model with the capacity constraints of the given node only, or of no node if node is empty.
+func (m *Model) withCapacityOf(node string) *Model {
+	relaxed := *m
+	relaxed.Constraints = make([]Constraint, 0, len(m.Constraints))
+	for k := range m.Constraints {
+		if _, n, ok := m.capacityOf(&m.Constraints[k]); !ok || n == node {
+			relaxed.Constraints = append(relaxed.Constraints, m.Constraints[k])
+		}
+	}
+	return &relaxed
+}
+
+// Overload is a node whose dataplanes use more of a resource than its capacity.
+type Overload struct {
+	Node     string
+	Resource string
+	Load     int
+	Capacity int
+}
+
+func (o Overload) String() string {
+	return fmt.Sprintf("%s needs %d %s for dataplanes but has %d", o.Node, o.Load, o.Resource, o.Capacity)
+}
+
+// CapacityError is returned when no placement fits the capacity of the nodes, with the nodes over budget.
+type CapacityError struct {
+	Overloads []Overload
+}
+
+func (e *CapacityError) Error() string {
+	list := make([]string, 0, len(e.Overloads))
+	for _, o := range e.Overloads {
+		list = append(list, o.String())
+	}
+	return "nodes over budget: " + strings.Join(list, ", ")
+}
+
+// ExplainCapacity finds the nodes over budget in a model with capacity constraints that has no solution.
+// Returns nil if the model has no solution even without the capacity constraints.
+//
+// A node is over budget if no placement fits its capacity alone. If every node fits on its own but not all of them
+// together, the nodes over budget are the ones the cheapest placement without capacity constraints overloads.
+// The load of a node is the one of the cheapest placement without capacity constraints.
+func ExplainCapacity(ctx context.Context, solver Solver, m *Model) (*CapacityError, error) {
+	relaxed, err := solver.SolveContext(ctx, m.withCapacityOf(""))
+	if err != nil {
+		return nil, err
+	}
+	if !relaxed.HasSolution() {
+		return nil, nil
+	}
+
+	overloads := func(node string) []Overload {
+		var list []Overload
+		for k := range m.Constraints {
+			c := &m.Constraints[k]
+			resource, n, ok := m.capacityOf(c)
+			if !ok || (node != "" && n != node) || c.Holds(relaxed.Values) {
+				continue
+			}
+			list = append(list, Overload{Node: n, Resource: resource, Load: activity(c.Terms, relaxed.Values), Capacity: c.RHS})
+		}
+		return list
+	}
+
+	e := &CapacityError{}
+	for _, node := range m.Nodes {
+		result, err := solver.SolveContext(ctx, m.withCapacityOf(node))
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if result.Status == STATUS_UNSAT {
+			e.Overloads = append(e.Overloads, overloads(node)...)
+		}
+	}
+	if len(e.Overloads) == 0 {
+		e.Overloads = overloads("")
+	}
+
+	return e, nil
+}
@@ -0,0 +1,236 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"xPlane"
+
+	"golang.org/x/exp/slices"
+)
+
+// Families of the constraints of a policy, named after the family, the index of the policy and the index of the service.
+var policyFamilies = []string{"sender", "only_sender", "receiver", "only_receiver", "supported"}
+
+// Families of constraints Explain describes in words.
+var explainedFamilies = []string{"existing", "one_dataplane", "max_changes", "proxies"}
+
+// Get the group of a constraint, the unit an unsat core is explained in: "policy_j" for the constraints of
+// policy j, "node_n" for the capacity of node n, and the family of the constraint otherwise, e.g. "one_dataplane".
+func constraintGroup(name string) string {
+	for _, family := range policyFamilies {
+		if rest, ok := strings.CutPrefix(name, family+"_"); ok {
+			if j, _, ok := strings.Cut(rest, "_"); ok {
+				return "policy_" + j
+			}
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(name, capacityPrefix); ok {
+		if k := strings.LastIndex(rest, "_"); k != -1 {
+			return "node_" + rest[k+1:]
+		}
+	}
+
+	// Strip the indices.
+	parts := strings.Split(name, "_")
+	for len(parts) > 1 {
+		if _, err := strconv.Atoi(parts[len(parts)-1]); err != nil {
+			break
+		}
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, "_")
+}
+
+// Get a copy of the model with the constraints of the given groups only.
+func (m *Model) withGroups(keep map[string]bool) *Model {
+	subset := *m
+	subset.Constraints = make([]Constraint, 0, len(m.Constraints))
+	for _, c := range m.Constraints {
+		if keep[constraintGroup(c.Name)] {
+			subset.Constraints = append(subset.Constraints, c)
+		}
+	}
+	return &subset
+}
+
+// FindCore finds why a model has no solution with a solver that proves it, but does not find unsat cores.
+// It drops the groups of constraints (the constraints of a policy, the capacity of a node, or a family such as
+// one_dataplane) one at a time, as long as the rest still has no solution. Returns the names of the constraints
+// of the groups left, a minimal set of groups that has no solution.
+func FindCore(ctx context.Context, solver Solver, m *Model) ([]string, error) {
+	var groups []string
+	keep := make(map[string]bool)
+	for _, c := range m.Constraints {
+		if g := constraintGroup(c.Name); !keep[g] {
+			keep[g] = true
+			groups = append(groups, g)
+		}
+	}
+
+	for _, g := range groups {
+		keep[g] = false
+		result, err := solver.SolveContext(ctx, m.withGroups(keep))
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if result.Status != STATUS_UNSAT {
+			keep[g] = true
+		}
+	}
+
+	var core []string
+	for _, c := range m.Constraints {
+		if keep[constraintGroup(c.Name)] {
+			core = append(core, c.Name)
+		}
+	}
+	return core, nil
+}
+
+// UnsatError is returned when a model has no solution, with the unsat core and its explanation.
+type UnsatError struct {
+	Core        []string
+	Explanation []string
+}
+
+func (e *UnsatError) Error() string {
+	if len(e.Explanation) == 0 {
+		return "no solution"
+	}
+	return strings.Join(e.Explanation, "; ")
+}
+
+// Explain an unsat core of a model built for the given policies and dataplanes in words, e.g. "policy 3 [frontend *]
+// needs SENDER enforcement on {frontend} but no dataplane supporting RouteToVersion is allowed there".
+func Explain(m *Model, core []string, policies []xPlane.Policy, dataplanes []xPlane.Dataplane) []string {
+	inCore := make(map[string]bool, len(core))
+	for _, name := range core {
+		inCore[name] = true
+	}
+
+	groups := make(map[string][]*Constraint)
+	for k := range m.Constraints {
+		c := &m.Constraints[k]
+		if inCore[c.Name] {
+			g := constraintGroup(c.Name)
+			groups[g] = append(groups[g], c)
+		}
+	}
+
+	dataplaneName := func(i int) string {
+		if i >= 0 && i < len(dataplanes) {
+			return dataplanes[i].GetName()
+		}
+		return fmt.Sprintf("dataplane %d", i)
+	}
+	serviceNames := func(list []int) string {
+		names := make([]string, 0, len(list))
+		for _, s := range list {
+			names = append(names, m.Services[s])
+		}
+		return "{" + strings.Join(names, ", ") + "}"
+	}
+
+	var explanation []string
+
+	// Services with an assigned dataplane, on a side of the policies of the core, and whether a policy
+	// already explains that its assigned dataplanes cannot enforce it.
+	var pinned []int
+	explained := false
+	for j := range m.Sides {
+		if groups[fmt.Sprintf("policy_%d", j)] == nil || j >= len(policies) {
+			continue
+		}
+
+		var functions []string
+		for _, f := range policies[j].GetFunctions() {
+			functions = append(functions, f.GetFunctionName())
+		}
+
+		var needs []string
+		for _, side := range []struct {
+			constraint xPlane.ConstraintType
+			services   []int
+		}{{xPlane.SENDER, m.Sides[j].Senders}, {xPlane.RECEIVER, m.Sides[j].Receivers}} {
+			if side.services == nil {
+				continue
+			}
+
+			// Services of the side no dataplane can enforce the policy at, and services whose assigned dataplane cannot.
+			var unsupported, assigned []int
+			for _, s := range side.services {
+				i := m.Assigned[s]
+				switch {
+				case len(m.Sides[j].Dataplanes[s]) == 0:
+					unsupported = append(unsupported, s)
+				case i != -1 && !slices.Contains(m.Sides[j].Dataplanes[s], i):
+					assigned = append(assigned, s)
+				}
+				if i != -1 && !slices.Contains(pinned, s) {
+					pinned = append(pinned, s)
+				}
+			}
+
+			need := fmt.Sprintf("%s enforcement on ", strings.ToUpper(side.constraint.String()))
+			switch {
+			case len(unsupported) > 0:
+				need += fmt.Sprintf("%s but no dataplane supporting %s is allowed there", serviceNames(unsupported), strings.Join(functions, ", "))
+			case len(assigned) > 0 && groups["existing"] != nil:
+				var list []string
+				for _, s := range assigned {
+					list = append(list, fmt.Sprintf("%s runs %s", m.Services[s], dataplaneName(m.Assigned[s])))
+				}
+				need += fmt.Sprintf("%s but %s, which does not support %s", serviceNames(assigned), strings.Join(list, " and "), strings.Join(functions, ", "))
+				explained = true
+			default:
+				need += serviceNames(side.services)
+			}
+			needs = append(needs, need)
+		}
+
+		explanation = append(explanation, fmt.Sprintf("policy %d %v needs %s", j, policies[j].GetContext(), strings.Join(needs, ", or ")))
+	}
+
+	if groups["existing"] != nil && !explained {
+		var list []string
+		for _, s := range pinned {
+			list = append(list, fmt.Sprintf("%s runs %s", m.Services[s], dataplaneName(m.Assigned[s])))
+		}
+		if len(list) == 0 {
+			explanation = append(explanation, "services keep their assigned dataplanes")
+		} else {
+			explanation = append(explanation, fmt.Sprintf("services keep their assigned dataplanes: %s", strings.Join(list, ", ")))
+		}
+	}
+	if groups["one_dataplane"] != nil {
+		explanation = append(explanation, "every service runs at most one dataplane")
+	}
+	if groups["max_changes"] != nil {
+		explanation = append(explanation, "the migration limits the number of services whose dataplane changes")
+	}
+	if c := groups["proxies"]; c != nil {
+		explanation = append(explanation, fmt.Sprintf("requests cross at most %d proxies", c[0].RHS))
+	}
+
+	for k := range m.Constraints {
+		c := &m.Constraints[k]
+		if !inCore[c.Name] {
+			continue
+		}
+		g := constraintGroup(c.Name)
+		if resource, node, ok := m.capacityOf(c); ok {
+			explanation = append(explanation, fmt.Sprintf("node %s has %d %s for dataplanes", node, c.RHS, resource))
+		} else if !strings.HasPrefix(g, "policy_") && !slices.Contains(explainedFamilies, g) {
+			// Constraints of other families, e.g. added to the model by hand.
+			explanation = append(explanation, fmt.Sprintf("constraint %s", c.Name))
+		}
+	}
+
+	return explanation
+}
@@ -0,0 +1,527 @@
+package smt
+
+import (
+	"context"
+	"math"
+)
+
+// GoSolver is an embedded exact solver that needs no external tools. It is a branch and bound over the
+// values of the variables: it propagates the constraints, bounds the cost of every partial assignment,
+// and prunes the assignments that cannot beat the best solution found.
+//
+// The side of every policy (the Y variables of the model) is decided first, by strong branching: both
+// values of every undecided side are tried, a side is fixed if one value cannot beat the best solution,
+// and the search branches on the side whose values have the highest bound. Then the dataplanes are
+// chosen, the service with the fewest dataplanes left to enforce its policies first.
+//
+// With a hint, the values of the hint are tried first, and a hint that satisfies the constraints is the first
+// solution, so that solving again after a small change of the policies starts close to the previous placement.
+type GoSolver struct {
+	// Maximum number of search nodes. Without it, the search runs until it proves optimality.
+	MaxNodes int
+}
+
+func NewGoSolver() *GoSolver {
+	return &GoSolver{MaxNodes: 10000000}
+}
+
+func (g *GoSolver) Name() string {
+	return "go"
+}
+
+func (g *GoSolver) Solve(m *Model) (*Result, error) {
+	return g.SolveContext(context.Background(), m)
+}
+
+func (g *GoSolver) SolveContext(ctx context.Context, m *Model) (*Result, error) {
+	return g.SolveImproving(ctx, m, nil)
+}
+
+// SolveImproving is SolveContext, and calls improved with every solution the search finds, each cheaper than the last.
+func (g *GoSolver) SolveImproving(ctx context.Context, m *Model, improved func(values []bool, cost int)) (*Result, error) {
+	s := newGoSearch(m, g.MaxNodes)
+	s.done = ctx.Done()
+	s.improved = improved
+
+	// A hint that is a solution is the first incumbent, so the search only looks for cheaper ones.
+	if values, ok := m.hintValues(); ok {
+		s.best, s.bestCost = values, m.Cost(values)
+		if improved != nil {
+			improved(s.best, s.bestCost)
+		}
+	}
+	complete := s.search()
+
+	if s.best == nil {
+		if complete {
+			return &Result{Status: STATUS_UNSAT}, nil
+		}
+		return &Result{Status: STATUS_UNKNOWN}, nil
+	}
+
+	if !complete {
+		return &Result{Status: STATUS_SAT, Values: s.best, Cost: s.bestCost, Gap: -1}, nil
+	}
+	return &Result{Status: STATUS_OPTIMAL, Values: s.best, Cost: s.bestCost}, nil
+}
+
+// A constraint in the form sum(terms) <= rhs.
+type leConstraint struct {
+	terms []Term
+	rhs   int
+
+	// Largest absolute coefficient of the terms. A constraint forces no value while its slack is at least this.
+	maxCoef int
+}
+
+// A variable appears in a constraint with the given coefficient.
+type occurrence struct {
+	constraint int
+	coef       int
+}
+
+// The bound of an assignment that is infeasible, or cannot beat the best solution.
+const noBound = math.MaxInt
+
+type goSearch struct {
+	model       *Model
+	constraints []leConstraint
+
+	// Index of the constraint of the model every constraint comes from.
+	source []int
+
+	// Constraints every variable appears in, and its cost in the objective.
+	occurs [][]occurrence
+	cost   []int
+
+	// Constraints that require paying for a variable when they are violated with all unassigned variables at 0.
+	covers []int
+
+	// Value of every variable: -1 if unassigned, 0 or 1 otherwise.
+	values []int8
+
+	// Activity of the assigned terms of every constraint, and the sum of the negative coefficients of its
+	// unassigned terms. Their sum is the lowest activity the constraint can reach.
+	fixed []int
+	free  []int
+
+	// Lowest cost the objective can reach, given the assigned variables.
+	minCost int
+
+	// Assigned variables, in order, to undo assignments when backtracking.
+	trail []int
+
+	// Variables deciding the side of every policy.
+	decisions []int
+
+	// Variables counted in the current bound are marked with the current stamp.
+	marks []int
+	stamp int
+
+	best     []bool
+	bestCost int
+	nodes    int
+	maxNodes int
+	stopped  bool
+
+	// Closed when the search must stop, checked every stopCheckInterval nodes.
+	done <-chan struct{}
+
+	// Called with every solution found, if not nil.
+	improved func(values []bool, cost int)
+}
+
+// Number of search nodes, or of iterations of the heuristic, between checks of whether to stop.
+const stopCheckInterval = 1024
+
+func newGoSearch(m *Model, maxNodes int) *goSearch {
+	s := &goSearch{
+		model:    m,
+		occurs:   make([][]occurrence, len(m.Vars)),
+		cost:     make([]int, len(m.Vars)),
+		values:   make([]int8, len(m.Vars)),
+		marks:    make([]int, len(m.Vars)),
+		maxNodes: maxNodes,
+	}
+
+	// Every constraint is turned into one or two constraints of the form sum(terms) <= rhs.
+	for k, c := range m.Constraints {
+		if c.Sense == LE || c.Sense == EQ {
+			s.addConstraint(c.Terms, c.RHS)
+			s.source = append(s.source, k)
+		}
+		if c.Sense == GE || c.Sense == EQ {
+			negated := make([]Term, len(c.Terms))
+			for i, t := range c.Terms {
+				negated[i] = Term{-t.Coef, t.Var}
+			}
+			s.addConstraint(negated, -c.RHS)
+			s.source = append(s.source, k)
+		}
+	}
+
+	for _, t := range m.Objective {
+		s.cost[t.Var] += t.Coef
+	}
+	s.minCost = m.Offset
+	for v, c := range s.cost {
+		s.values[v] = -1
+		if c < 0 {
+			s.minCost += c
+		}
+	}
+
+	s.fixed = make([]int, len(s.constraints))
+	s.free = make([]int, len(s.constraints))
+	for k, c := range s.constraints {
+		covers := false
+		for _, t := range c.terms {
+			s.occurs[t.Var] = append(s.occurs[t.Var], occurrence{k, t.Coef})
+			if t.Coef < 0 {
+				s.free[k] += t.Coef
+				if s.cost[t.Var] > 0 {
+					covers = true
+				}
+			}
+		}
+		if covers {
+			s.covers = append(s.covers, k)
+		}
+	}
+
+	for _, y := range m.Y {
+		if y >= 0 {
+			s.decisions = append(s.decisions, y)
+		}
+	}
+
+	return s
+}
+
+func (s *goSearch) addConstraint(terms []Term, rhs int) {
+	c := leConstraint{terms: terms, rhs: rhs}
+	for _, t := range terms {
+		if t.Coef > c.maxCoef {
+			c.maxCoef = t.Coef
+		} else if -t.Coef > c.maxCoef {
+			c.maxCoef = -t.Coef
+		}
+	}
+	s.constraints = append(s.constraints, c)
+}
+
+func (s *goSearch) assign(v int, value int8) {
+	s.values[v] = value
+	s.trail = append(s.trail, v)
+
+	for _, o := range s.occurs[v] {
+		if o.coef < 0 {
+			s.free[o.constraint] -= o.coef
+		}
+		if value == 1 {
+			s.fixed[o.constraint] += o.coef
+		}
+	}
+	if s.cost[v] < 0 {
+		s.minCost -= s.cost[v]
+	}
+	if value == 1 {
+		s.minCost += s.cost[v]
+	}
+}
+
+func (s *goSearch) undo(size int) {
+	for len(s.trail) > size {
+		v := s.trail[len(s.trail)-1]
+		s.trail = s.trail[:len(s.trail)-1]
+
+		for _, o := range s.occurs[v] {
+			if o.coef < 0 {
+				s.free[o.constraint] += o.coef
+			}
+			if s.values[v] == 1 {
+				s.fixed[o.constraint] -= o.coef
+			}
+		}
+		if s.cost[v] < 0 {
+			s.minCost += s.cost[v]
+		}
+		if s.values[v] == 1 {
+			s.minCost -= s.cost[v]
+		}
+		s.values[v] = -1
+	}
+}
+
+// Assign a variable and propagate the constraints it appears in. Returns false on a conflict,
+// and the caller undoes the assignments.
+func (s *goSearch) assignAndPropagate(v int, value int8) bool {
+	s.assign(v, value)
+
+	queue := make([]int, 0, len(s.occurs[v]))
+	for _, o := range s.occurs[v] {
+		queue = append(queue, o.constraint)
+	}
+	return s.propagate(queue)
+}
+
+// Propagate the constraints: assign the variables whose value is forced, and return false on a conflict.
+func (s *goSearch) propagate(queue []int) bool {
+	for len(queue) > 0 {
+		k := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		c := &s.constraints[k]
+		slack := c.rhs - s.fixed[k] - s.free[k]
+		if slack < 0 {
+			return false
+		}
+		if slack >= c.maxCoef {
+			continue
+		}
+
+		for _, t := range c.terms {
+			if s.values[t.Var] != -1 {
+				continue
+			}
+
+			// Taking the other value than the one counted in the lowest activity would exceed the right hand side.
+			if t.Coef > slack {
+				s.assign(t.Var, 0)
+			} else if -t.Coef > slack {
+				s.assign(t.Var, 1)
+			} else {
+				continue
+			}
+			for _, o := range s.occurs[t.Var] {
+				queue = append(queue, o.constraint)
+			}
+		}
+	}
+	return true
+}
+
+// A lower bound of the cost of any solution extending the assigned variables: the cost of the assigned
+// variables, plus the cheapest variable of every violated covering constraint, for constraints that
+// share no unassigned variables with each other.
+func (s *goSearch) bound() int {
+	bound := s.minCost
+	s.stamp++
+
+	for _, k := range s.covers {
+		c := &s.constraints[k]
+		if s.fixed[k] <= c.rhs {
+			continue
+		}
+
+		// Some unassigned variable with a negative coefficient must be 1.
+		cheapest := noBound
+		disjoint := true
+		for _, t := range c.terms {
+			if s.values[t.Var] != -1 || t.Coef >= 0 {
+				continue
+			}
+			if s.marks[t.Var] == s.stamp {
+				disjoint = false
+				break
+			}
+			if s.cost[t.Var] < cheapest {
+				cheapest = s.cost[t.Var]
+			}
+		}
+		if !disjoint || cheapest == noBound || cheapest <= 0 {
+			continue
+		}
+
+		for _, t := range c.terms {
+			if s.values[t.Var] == -1 && t.Coef < 0 {
+				s.marks[t.Var] = s.stamp
+			}
+		}
+		bound += cheapest
+	}
+
+	return bound
+}
+
+// Check if the bound cannot beat the best solution.
+func (s *goSearch) pruned(bound int) bool {
+	return s.best != nil && bound >= s.bestCost
+}
+
+// Get the bound after assigning a value to a variable, or noBound if it is infeasible or pruned.
+func (s *goSearch) probe(v int, value int8) int {
+	size := len(s.trail)
+	defer s.undo(size)
+
+	if !s.assignAndPropagate(v, value) {
+		return noBound
+	}
+	if bound := s.bound(); !s.pruned(bound) {
+		return bound
+	}
+	return noBound
+}
+
+// Strong branching on the sides of the policies. Returns the side to branch on and its values, best bound
+// first, or -1 if every side is decided. Sides with only one value left are fixed on the way, and false
+// is returned if a side has no value left.
+func (s *goSearch) pickDecision() (int, [2]int8, bool) {
+	for {
+		v, order := -1, [2]int8{0, 1}
+		score, tieScore := -1, -1
+		fixed := false
+
+		for _, y := range s.decisions {
+			if s.values[y] != -1 {
+				continue
+			}
+
+			b0, b1 := s.probe(y, 0), s.probe(y, 1)
+			if b0 == noBound && b1 == noBound {
+				return -1, order, false
+			}
+			if b0 == noBound || b1 == noBound {
+				value := int8(0)
+				if b0 == noBound {
+					value = 1
+				}
+				if !s.assignAndPropagate(y, value) {
+					return -1, order, false
+				}
+				fixed = true
+				continue
+			}
+
+			low, high := min(b0, b1), max(b0, b1)
+			if low > score || (low == score && high > tieScore) {
+				v, score, tieScore = y, low, high
+				if b1 < b0 {
+					order = [2]int8{1, 0}
+				} else {
+					order = [2]int8{0, 1}
+				}
+			}
+		}
+
+		// Fixing a side changes the bounds of the others.
+		if !fixed {
+			return v, order, true
+		}
+		if s.pruned(s.bound()) {
+			return -1, order, false
+		}
+	}
+}
+
+// Pick the variable to branch on once every side is decided: the cheapest variable of the violated
+// covering constraint with the fewest unassigned variables, or else the first unassigned variable.
+// Returns -1 if every variable is assigned.
+func (s *goSearch) pickVariable() (int, [2]int8) {
+	v, fewest := -1, 0
+	for _, k := range s.covers {
+		c := &s.constraints[k]
+		if s.fixed[k] <= c.rhs {
+			continue
+		}
+
+		candidates, cheapest := 0, -1
+		for _, t := range c.terms {
+			if s.values[t.Var] != -1 || t.Coef >= 0 {
+				continue
+			}
+			candidates++
+			if cheapest == -1 || s.cost[t.Var] < s.cost[cheapest] {
+				cheapest = t.Var
+			}
+		}
+		if cheapest != -1 && (v == -1 || candidates < fewest) {
+			v, fewest = cheapest, candidates
+		}
+	}
+	if v != -1 {
+		return v, [2]int8{1, 0}
+	}
+
+	for i, value := range s.values {
+		if value == -1 {
+			// Try the cheaper value first.
+			if s.cost[i] < 0 {
+				return i, [2]int8{1, 0}
+			}
+			return i, [2]int8{0, 1}
+		}
+	}
+	return -1, [2]int8{}
+}
+
+// Get the order of the values to try for a variable: the value of the hint first, if it has one.
+func (s *goSearch) phase(v int, order [2]int8) [2]int8 {
+	if hint := s.model.Hint; hint != nil && hint[v] != -1 {
+		return [2]int8{hint[v], 1 - hint[v]}
+	}
+	return order
+}
+
+// Search the unassigned variables, and return false if the search was stopped before it completed.
+func (s *goSearch) search() bool {
+	all := make([]int, len(s.constraints))
+	for k := range all {
+		all[k] = k
+	}
+	if s.propagate(all) {
+		s.branch()
+	}
+	return !s.stopped
+}
+
+func (s *goSearch) branch() {
+	s.nodes++
+	if s.maxNodes > 0 && s.nodes > s.maxNodes {
+		s.stopped = true
+		return
+	}
+	if s.nodes%stopCheckInterval == 1 && isDone(s.done) {
+		s.stopped = true
+		return
+	}
+
+	if s.pruned(s.bound()) {
+		return
+	}
+
+	v, order, ok := s.pickDecision()
+	if !ok {
+		return
+	}
+	if v == -1 {
+		v, order = s.pickVariable()
+	}
+	if v != -1 {
+		order = s.phase(v, order)
+	}
+
+	// All variables are assigned, and propagation guarantees every constraint holds.
+	if v == -1 {
+		s.best = make([]bool, len(s.values))
+		for i, value := range s.values {
+			s.best[i] = value == 1
+		}
+		s.bestCost = s.model.Cost(s.best)
+		if s.improved != nil {
+			s.improved(s.best, s.bestCost)
+		}
+		return
+	}
+
+	for _, value := range order {
+		size := len(s.trail)
+		if s.assignAndPropagate(v, value) {
+			s.branch()
+		}
+		s.undo(size)
+		if s.stopped {
+			return
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package smt
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// HeuristicSolver finds a good placement fast on graphs too large for the exact solvers, without proving
+// it optimal. It chooses the side of every policy greedily, then improves the choice by simulated annealing
+// and a final descent. Every service runs the cheapest dataplane that supports all the policies it enforces.
+type HeuristicSolver struct {
+	// Number of moves of the simulated annealing.
+	Iterations int
+
+	// Number of moves of the simulated annealing when the model has a hint. Starting from the hint, the annealing
+	// needs fewer moves, and starts cooler so that it stays close to the hint.
+	WarmIterations int
+
+	// Seed of the random moves, for reproducible placements.
+	Seed int64
+
+	// If set, the model is also solved with this exact solver, and the gap of the heuristic cost to the
+	// optimal cost is reported in the result.
+	Exact Solver
+}
+
+func NewHeuristicSolver() *HeuristicSolver {
+	return &HeuristicSolver{Iterations: 100000, WarmIterations: 10000, Seed: 1}
+}
+
+func (h *HeuristicSolver) Name() string {
+	return "heuristic"
+}
+
+func (h *HeuristicSolver) Solve(m *Model) (*Result, error) {
+	return h.SolveContext(context.Background(), m)
+}
+
+// SolveContext stops the simulated annealing when the context is done, and returns the best placement found.
+func (h *HeuristicSolver) SolveContext(ctx context.Context, m *Model) (*Result, error) {
+	return h.SolveImproving(ctx, m, nil)
+}
+
+// SolveImproving is SolveContext, and calls improved with every feasible placement the search finds, each cheaper
+// than the last: the greedy one first, then the ones of the simulated annealing and the descent. The sides of the
+// policies in the hint of the model are kept in the greedy placement, which only chooses the others.
+func (h *HeuristicSolver) SolveImproving(ctx context.Context, m *Model, improved func(values []bool, cost int)) (*Result, error) {
+	if m.Sides == nil || m.Assigned == nil {
+		return nil, errors.New("the heuristic needs a model built by BuildModel")
+	}
+
+	l := newLocalSearch(m)
+	l.improved = improved
+	l.warmStart()
+	l.greedy()
+	iterations, warm := h.Iterations, m.Hint != nil
+	if warm {
+		iterations = h.WarmIterations
+	}
+	l.anneal(iterations, warm, rand.New(rand.NewSource(h.Seed)), ctx.Done())
+	l.descend()
+
+	result := &Result{Status: STATUS_UNKNOWN, Gap: -1}
+	if l.best == nil {
+		glog.Warning("No feasible placement found by the heuristic")
+		return result, nil
+	}
+
+	values := l.values(l.best)
+	if err := m.Check(values); err != nil {
+		// The model has constraints the heuristic does not search.
+		glog.Warning("Heuristic placement is not feasible: ", err)
+		return result, nil
+	}
+	result.Status = STATUS_SAT
+	result.Values = values
+	result.Cost = m.Cost(values)
+
+	if h.Exact != nil {
+		exact, err := h.Exact.SolveContext(ctx, m)
+		if err != nil {
+			glog.Warning("Error running the exact solver: ", err)
+		} else if exact.Status == STATUS_OPTIMAL {
+			result.Gap = float64(result.Cost-exact.Cost) / float64(max(exact.Cost, 1))
+			glog.Infof("Heuristic cost %d, optimal cost %d, gap %.2f%%", result.Cost, exact.Cost, 100*result.Gap)
+		}
+	}
+
+	return result, nil
+}
+
+// The choice of a side for a policy allowed at both sides.
+const (
+	sideSenders   = 1
+	sideReceivers = 0
+)
+
+// Local search over the sides of the policies.
+type localSearch struct {
+	model *Model
+
+	// Cost of running dataplane i at service s.
+	cost [][]int
+
+	// Side chosen for every policy, or -1 if only one side is allowed.
+	side []int
+
+	// Policies allowed at both sides, the ones the search chooses.
+	choices []int
+
+	// Number of policies enforced at every service, and how many of them every dataplane supports.
+	need  []int
+	allow [][]int
+
+	// Cost of every service, and the total cost. A service with no dataplane supporting all its policies
+	// costs the penalty, so infeasible placements are always more expensive than feasible ones.
+	serviceCost []int
+	total       int
+	penalty     int
+	infeasible  int
+
+	// Best feasible sides found, and their cost.
+	best     []int
+	bestCost int
+
+	// Services changed by a move.
+	touched []int
+	marks   []bool
+
+	// Called with the values of every best sides found that satisfy the constraints of the model, if not nil.
+	improved func(values []bool, cost int)
+}
+
+func newLocalSearch(m *Model) *localSearch {
+	numServices := len(m.Services)
+	numDataplanes := m.NumDataplanes()
+
+	l := &localSearch{
+		model:       m,
+		cost:        make([][]int, numDataplanes),
+		side:        make([]int, len(m.Sides)),
+		need:        make([]int, numServices),
+		allow:       make([][]int, numServices),
+		serviceCost: make([]int, numServices),
+		marks:       make([]bool, numServices),
+	}
+
+	costs := make([]int, len(m.Vars))
+	for _, t := range m.Objective {
+		costs[t.Var] += t.Coef
+	}
+	minCost, maxCost := 0, 0
+	for i := range l.cost {
+		l.cost[i] = make([]int, numServices)
+		for s := range l.cost[i] {
+			l.cost[i][s] = costs[m.X[i][s]]
+			minCost = min(minCost, l.cost[i][s])
+			maxCost = max(maxCost, l.cost[i][s])
+		}
+	}
+	l.penalty = (maxCost-minCost)*numServices + 1
+
+	for s := range l.allow {
+		l.allow[s] = make([]int, numDataplanes)
+	}
+
+	// Policies allowed at one side only are enforced there from the start.
+	for j, sides := range m.Sides {
+		l.side[j] = -1
+		if sides.Senders != nil && sides.Receivers != nil {
+			l.choices = append(l.choices, j)
+		} else if sides.Senders != nil {
+			l.enforce(j, sides.Senders, 1)
+		} else {
+			l.enforce(j, sides.Receivers, 1)
+		}
+	}
+
+	for _, s := range l.touched {
+		l.marks[s] = false
+	}
+	l.touched = l.touched[:0]
+
+	for s := range l.serviceCost {
+		l.serviceCost[s] = l.computeCost(s)
+		l.total += l.serviceCost[s]
+		if l.serviceCost[s] >= l.penalty {
+			l.infeasible++
+		}
+	}
+
+	return l
+}
+
+// Add (delta 1) or remove (delta -1) the enforcement of policy j at the services, and mark them as touched.
+func (l *localSearch) enforce(j int, services []int, delta int) {
+	for _, s := range services {
+		l.need[s] += delta
+		for _, i := range l.model.Sides[j].Dataplanes[s] {
+			l.allow[s][i] += delta
+		}
+		if !l.marks[s] {
+			l.marks[s] = true
+			l.touched = append(l.touched, s)
+		}
+	}
+}
+
+// Get the services of the chosen side of policy j.
+func (l *localSearch) services(j int, side int) []int {
+	if side == sideSenders {
+		return l.model.Sides[j].Senders
+	}
+	return l.model.Sides[j].Receivers
+}
+
+// Get the dataplane of a service: the assigned one, or the cheapest one that supports all its policies.
+// Returns -1 if the service runs no dataplane, and -2 if no dataplane supports all its policies.
+func (l *localSearch) dataplane(s int) int {
+	if i := l.model.Assigned[s]; i != -1 {
+		if l.allow[s][i] != l.need[s] {
+			return -2
+		}
+		return i
+	}
+
+	// A service that enforces no policy runs no dataplane, unless keeping a deployed one is cheaper.
+	best, bestCost := -2, 0
+	if l.need[s] == 0 {
+		best = -1
+	}
+	for i := range l.allow[s] {
+		if l.allow[s][i] == l.need[s] && (best == -2 || l.cost[i][s] < bestCost) {
+			best, bestCost = i, l.cost[i][s]
+		}
+	}
+	return best
+}
+
+func (l *localSearch) computeCost(s int) int {
+	switch i := l.dataplane(s); i {
+	case -1:
+		return 0
+	case -2:
+		return l.penalty
+	default:
+		return l.cost[i][s]
+	}
+}
+
+// Update the cost of the touched services, and return the change of the total cost.
+func (l *localSearch) update() int {
+	delta := 0
+	for _, s := range l.touched {
+		l.marks[s] = false
+
+		c := l.computeCost(s)
+		if l.serviceCost[s] >= l.penalty {
+			l.infeasible--
+		}
+		if c >= l.penalty {
+			l.infeasible++
+		}
+		delta += c - l.serviceCost[s]
+		l.serviceCost[s] = c
+	}
+	l.touched = l.touched[:0]
+	l.total += delta
+	return delta
+}
+
+// Set the side of policy j, and return the change of the total cost.
+func (l *localSearch) setSide(j int, side int) int {
+	if l.side[j] == side {
+		return 0
+	}
+	if l.side[j] != -1 {
+		l.enforce(j, l.services(j, l.side[j]), -1)
+	}
+	l.enforce(j, l.services(j, side), 1)
+	l.side[j] = side
+	return l.update()
+}
+
+// Keep the sides if they are the best feasible ones found.
+func (l *localSearch) record() {
+	if l.infeasible == 0 && (l.best == nil || l.total < l.bestCost) {
+		l.best = append(l.best[:0], l.side...)
+		l.bestCost = l.total
+
+		if l.improved != nil {
+			// The sides are the best ones, so getting their values changes none.
+			if values := l.values(l.best); l.model.Check(values) == nil {
+				l.improved(values, l.model.Cost(values))
+			}
+		}
+	}
+}
+
+// Start from the sides of the policies in the hint of the model, if it has one.
+func (l *localSearch) warmStart() {
+	hint := l.model.Hint
+	if hint == nil {
+		return
+	}
+	for _, j := range l.choices {
+		if y := l.model.Y[j]; y != -1 && hint[y] != -1 {
+			l.setSide(j, int(hint[y]))
+		}
+	}
+}
+
+// Choose the side of every policy left in turn, the one that adds the least cost to the policies already placed.
+// Policies with the largest enforcement sets are placed first, since they decide most of the dataplanes.
+func (l *localSearch) greedy() {
+	var order []int
+	for _, j := range l.choices {
+		if l.side[j] == -1 {
+			order = append(order, j)
+		}
+	}
+	size := func(j int) int {
+		return len(l.model.Sides[j].Senders) + len(l.model.Sides[j].Receivers)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return size(order[a]) > size(order[b])
+	})
+
+	for _, j := range order {
+		senders := l.setSide(j, sideSenders)
+		receivers := senders + l.setSide(j, sideReceivers)
+
+		// On a tie, the fewer services enforce the policy the better.
+		if senders < receivers || (senders == receivers && len(l.model.Sides[j].Senders) <= len(l.model.Sides[j].Receivers)) {
+			l.setSide(j, sideSenders)
+		}
+	}
+	l.record()
+}
+
+// Flip the sides of random policies, keeping flips that increase the cost with a probability that decreases
+// as the temperature cools down. A warm annealing keeps more of the sides it starts from. Stops early when done
+// is closed.
+func (l *localSearch) anneal(iterations int, warm bool, r *rand.Rand, done <-chan struct{}) {
+	if len(l.choices) == 0 || iterations <= 0 {
+		return
+	}
+
+	// Start at the cost of the most expensive dataplane, or of the cheapest one if warm, and cool down to a
+	// hundredth of the cheapest one.
+	high, low := 1.0, math.MaxFloat64
+	for i := range l.cost {
+		for _, c := range l.cost[i] {
+			if c > 0 {
+				high = math.Max(high, float64(c))
+				low = math.Min(low, float64(c))
+			}
+		}
+	}
+	low = math.Min(low, high)
+	if warm {
+		high = low
+	}
+	low /= 100
+	cooling := math.Pow(low/high, 1/float64(iterations))
+
+	temperature := high
+	for k := 0; k < iterations; k++ {
+		if k%stopCheckInterval == 0 && isDone(done) {
+			glog.Warningf("Heuristic stopped after %d of %d iterations", k, iterations)
+			break
+		}
+
+		j := l.choices[r.Intn(len(l.choices))]
+		side := l.side[j]
+		delta := l.setSide(j, 1-side)
+		if delta > 0 && r.Float64() >= math.Exp(-float64(delta)/temperature) {
+			l.setSide(j, side)
+		} else {
+			l.record()
+		}
+		temperature *= cooling
+	}
+
+	// Continue from the best sides found.
+	if l.best != nil {
+		for _, j := range l.choices {
+			l.setSide(j, l.best[j])
+		}
+	}
+}
+
+// Flip the sides of policies as long as it decreases the cost.
+func (l *localSearch) descend() {
+	for improved := true; improved; {
+		improved = false
+		for _, j := range l.choices {
+			side := l.side[j]
+			if l.setSide(j, 1-side) < 0 {
+				improved = true
+			} else {
+				l.setSide(j, side)
+			}
+		}
+	}
+	l.record()
+}
+
+// Get the values of the variables of the model for the given sides.
+func (l *localSearch) values(sides []int) []bool {
+	m := l.model
+	for _, j := range l.choices {
+		l.setSide(j, sides[j])
+	}
+
+	values := make([]bool, len(m.Vars))
+	for s := range m.Services {
+		if i := l.dataplane(s); i >= 0 {
+			values[m.X[i][s]] = true
+		}
+	}
+	for j := range m.Sides {
+		var enforcing []int
+		switch {
+		case l.side[j] != -1:
+			enforcing = l.services(j, l.side[j])
+			values[m.Y[j]] = l.side[j] == sideSenders
+		case m.Sides[j].Senders != nil:
+			enforcing = m.Sides[j].Senders
+		default:
+			enforcing = m.Sides[j].Receivers
+		}
+		for _, s := range enforcing {
+			values[m.E[j][s]] = true
+		}
+	}
+	return values
+}
@@ -0,0 +1,106 @@
+package smt
+
+import (
+	"fmt"
+	"strings"
+	"xPlane"
+
+	"github.com/golang/glog"
+	"golang.org/x/exp/slices"
+)
+
+// SetHint sets the values of the variables in a previous placement as the hint of the model, for the solvers to
+// start from. The model must be built for the given policies, and the placement must have the dataplanes of the
+// model, in the same order.
+//
+// Policies are matched to the policies of the previous placement by context, in order, so that policies can be
+// added, removed or changed between the placements. The variables of the policies with no match, e.g. a new policy,
+// have no hint. The services missing from the placement run no dataplane.
+func (m *Model) SetHint(p *xPlane.Placement, policies []xPlane.Policy) error {
+	if len(p.Dataplanes) != m.NumDataplanes() {
+		return fmt.Errorf("expected %d dataplanes in the previous placement, got %d", m.NumDataplanes(), len(p.Dataplanes))
+	}
+	if len(policies) != m.NumPolicies() {
+		return fmt.Errorf("expected %d policies, got %d", m.NumPolicies(), len(policies))
+	}
+
+	m.Hint = make([]int8, len(m.Vars))
+	for v := range m.Hint {
+		m.Hint[v] = -1
+	}
+	set := func(v int, value bool) {
+		m.Hint[v] = 0
+		if value {
+			m.Hint[v] = 1
+		}
+	}
+
+	for s, svc := range m.Services {
+		i := p.GetDataplaneIndex(svc)
+		for k := range m.X {
+			set(m.X[k][s], k == i)
+		}
+	}
+
+	used := make([]bool, len(p.Policies))
+	matched := 0
+	for j := range policies {
+		k := -1
+		for n, pp := range p.Policies {
+			if !used[n] && slices.Equal(pp.Context, policies[j].GetContext()) {
+				k = n
+				break
+			}
+		}
+		if k == -1 {
+			continue
+		}
+		used[k] = true
+		matched++
+
+		points := p.Policies[k].EnforcementPoints
+		enforcing := make(map[string]bool, len(points))
+		for _, ep := range points {
+			enforcing[ep.Service] = true
+		}
+		for s, v := range m.E[j] {
+			if v != -1 {
+				set(v, enforcing[m.Services[s]])
+			}
+		}
+		if y := m.Y[j]; y != -1 && len(points) > 0 {
+			set(y, points[0].Side == xPlane.SENDER)
+		}
+	}
+
+	glog.Infof("Hint from the previous placement for %d of %d policies", matched, len(policies))
+	return nil
+}
+
+// Get the values of the hint if it has a value for every variable, and they satisfy the constraints.
+func (m *Model) hintValues() ([]bool, bool) {
+	if m.Hint == nil || slices.Contains(m.Hint, -1) {
+		return nil, false
+	}
+	values := make([]bool, len(m.Vars))
+	for v, value := range m.Hint {
+		values[v] = value == 1
+	}
+	return values, m.Check(values) == nil
+}
+
+// Write the hint of the model in SMT-LIB, after the objective. A hint that satisfies the constraints bounds the
+// objective by its cost, which z3 would otherwise have to find. The dataplanes of the hint are soft assertions,
+// a second objective after the cost: among the cheapest placements, z3 keeps the most dataplanes of the hint.
+func (m *Model) writeSMTLIBHint(b *strings.Builder) {
+	if values, ok := m.hintValues(); ok {
+		fmt.Fprintf(b, "(assert (<= %s %d))\n", smtSum(m, m.Objective), activity(m.Objective, values))
+	}
+	for i := range m.X {
+		for _, v := range m.X[i] {
+			if m.Hint[v] != -1 {
+				fmt.Fprintf(b, "(assert-soft (= %s %d) :id hint)\n", m.Vars[v], m.Hint[v])
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package smt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AddLatencyBudget limits the number of dataplanes a request crosses on every path through the application,
+// see xPlane.GetRequestPaths: every sidecar on the path adds a proxy hop to the latency of the request.
+func (m *Model) AddLatencyBudget(paths [][]string, maxProxies int) error {
+	if maxProxies < 0 {
+		return fmt.Errorf("invalid maximum number of proxies %d", maxProxies)
+	}
+
+	svcMap := getSvcMapFromList(m.Services)
+
+	// Paths through the same services cross the same sidecars, so they share a constraint.
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		indices := make([]int, 0, len(path))
+		for _, svc := range path {
+			s, ok := svcMap[svc]
+			if !ok {
+				return fmt.Errorf("service %s of path %v is not in the graph", svc, path)
+			}
+			indices = append(indices, s)
+		}
+		sort.Ints(indices)
+
+		key := strings.Trim(fmt.Sprint(indices), "[]")
+		if seen[key] || len(indices) <= maxProxies {
+			continue
+		}
+		seen[key] = true
+
+		terms := make([]Term, 0, len(indices)*m.NumDataplanes())
+		for _, s := range indices {
+			for i := range m.X {
+				terms = append(terms, Term{1, m.X[i][s]})
+			}
+		}
+		m.Add(fmt.Sprintf("proxies_%d", len(seen)-1), terms, LE, maxProxies)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Lines of CPLEX-LP are wrapped after this many bytes, well below the limit of 510 of CPLEX.
+const lpLineLength = 255
+
+// WriteLP writes the model in the CPLEX-LP format, e.g. for HiGHS, CPLEX or Gurobi. Every variable is binary
+// and named like in SMT-LIB, and every constraint is a row with its name. The objective is the one of
+// WriteSMTLIB, without the offset.
+func (m *Model) WriteLP(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\\ Placement of %d services: %d variables, %d constraints\n", len(m.Services), len(m.Vars), len(m.Constraints))
+
+	// Add the objective function.
+	b.WriteString("Minimize\n")
+	writeLPLine(&b, append([]string{" obj:"}, infixTerms(m, m.Objective, " ")...))
+
+	// Add the constraints.
+	b.WriteString("Subject To\n")
+	for _, c := range m.Constraints {
+		terms := infixTerms(m, c.Terms, " ")
+		if len(terms) == 0 {
+			// A row needs a variable.
+			if len(m.Vars) == 0 {
+				return fmt.Errorf("constraint %s has no variables", c.Name)
+			}
+			terms = []string{"0 " + m.Vars[0]}
+		}
+		line := append([]string{" " + c.Name + ":"}, terms...)
+		writeLPLine(&b, append(line, c.Sense.String(), strconv.Itoa(c.RHS)))
+	}
+
+	// Define the variables.
+	b.WriteString("Binaries\n")
+	writeLPLine(&b, append([]string{""}, m.Vars...))
+	b.WriteString("End\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Write the words of a line of CPLEX-LP, wrapped on the following lines if it is too long.
+func writeLPLine(b *strings.Builder, words []string) {
+	n := 0
+	for k, word := range words {
+		if k > 0 && n+len(word) >= lpLineLength {
+			b.WriteString("\n  ")
+			n = 2
+		} else if k > 0 {
+			b.WriteString(" ")
+			n++
+		}
+		b.WriteString(word)
+		n += len(word)
+	}
+	b.WriteString("\n")
+}
+
+// ErrNoHiGHSStatus is returned when a solution file of HiGHS has no model status.
+var ErrNoHiGHSStatus = errors.New("no model status in HiGHS solution")
+
+// ParseHiGHSSolution parses the solution file HiGHS writes for a model written by WriteLP, e.g. with
+// --solution_file: the model status, and the primal value of every column by name if it has a feasible solution.
+// The values are kept if HiGHS stops before proving them optimal, e.g. at its time limit, and they satisfy the
+// constraints.
+func ParseHiGHSSolution(m *Model, out []byte) (*Result, error) {
+	lines := strings.Split(string(out), "\n")
+
+	status := ""
+	primal := false
+	var named map[string]string
+	for k := 0; k < len(lines); k++ {
+		text := strings.TrimSpace(lines[k])
+		switch {
+		case text == "Model status" && k+1 < len(lines):
+			k++
+			status = strings.TrimSpace(lines[k])
+
+		case strings.HasPrefix(text, "# Primal solution values"):
+			primal = true
+		case strings.HasPrefix(text, "# Dual solution values"):
+			primal = false
+
+		case strings.HasPrefix(text, "# Columns ") && primal:
+			count, err := strconv.Atoi(strings.TrimPrefix(text, "# Columns "))
+			if err != nil || k+count >= len(lines) {
+				return nil, fmt.Errorf("line %d of HiGHS solution: invalid column count %q", k+1, text)
+			}
+			named = make(map[string]string, count)
+			for n, line := range lines[k+1 : k+1+count] {
+				fields := strings.Fields(line)
+				if len(fields) != 2 {
+					return nil, fmt.Errorf("line %d of HiGHS solution: expected a name and a value, got %q", k+n+2, line)
+				}
+				value, err := lpValue(fields[0], fields[1])
+				if err != nil {
+					return nil, err
+				}
+				named[fields[0]] = value
+			}
+			k += count
+		}
+	}
+
+	switch status {
+	case "":
+		return nil, ErrNoHiGHSStatus
+	case "Infeasible":
+		return &Result{Status: STATUS_UNSAT}, nil
+	}
+	if named == nil {
+		return &Result{Status: STATUS_UNKNOWN}, nil
+	}
+
+	values, err := valuesByName(m, named)
+	if err != nil {
+		return nil, err
+	}
+	if status == "Optimal" {
+		return &Result{Status: STATUS_OPTIMAL, Values: values, Cost: m.Cost(values)}, nil
+	}
+	return feasibleResult(m, values, "HiGHS"), nil
+}
+
+// Get the binary value of a column of an LP solution, a floating point number within the tolerance of a MIP solver.
+func lpValue(name string, value string) (string, error) {
+	const tolerance = 1e-6
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", &InvalidValueError{Name: name, Value: value}
+	}
+	switch {
+	case math.Abs(f) <= tolerance:
+		return "0", nil
+	case math.Abs(f-1) <= tolerance:
+		return "1", nil
+	default:
+		return "", &InvalidValueError{Name: name, Value: value}
+	}
+}
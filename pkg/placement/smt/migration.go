@@ -0,0 +1,133 @@
+package smt
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// MigrationCost is the cost of changing the dataplane of a service: adding a dataplane where there is none,
+// removing the deployed one, or switching the deployed one to another dataplane.
+type MigrationCost struct {
+	Add    int
+	Remove int
+	Switch int
+}
+
+const (
+	// NO_CHANGE_LIMIT lets a migration change any number of services. It is the zero value of MaxChanges.
+	NO_CHANGE_LIMIT = 0
+	// NO_CHANGES keeps the deployed dataplane at every service.
+	NO_CHANGES = -1
+)
+
+// Migration describes the placement that is deployed, and how costly it is to move away from it.
+type Migration struct {
+	// Index of the dataplane deployed at every service that has one.
+	Deployed map[string]int
+
+	// Cost of changing every service, and of the services missing from ServiceCosts.
+	ServiceCosts map[string]MigrationCost
+	Cost         MigrationCost
+
+	// Maximum number of services whose dataplane changes. The zero value, NO_CHANGE_LIMIT, does not limit them,
+	// and NO_CHANGES forbids any change.
+	MaxChanges int
+}
+
+func (mig *Migration) costOf(service string) MigrationCost {
+	if c, ok := mig.ServiceCosts[service]; ok {
+		return c
+	}
+	return mig.Cost
+}
+
+// Count the services whose dataplane differs from the deployed one, given the dataplane of every service (-1 if none).
+func (mig *Migration) Changes(sidecars map[string]int) int {
+	changes := 0
+	for svc, i := range sidecars {
+		deployed, ok := mig.Deployed[svc]
+		if !ok {
+			deployed = -1
+		}
+		if i != deployed {
+			changes++
+		}
+	}
+	for svc := range mig.Deployed {
+		if _, ok := sidecars[svc]; !ok {
+			changes++
+		}
+	}
+	return changes
+}
+
+// AddMigration adds the cost of migrating from the deployed placement to the objective, and limits the
+// number of services that change. Unlike assigned dataplanes, deployed ones can be removed or switched.
+//
+// A service s with deployed dataplane d costs Remove * (1 - sum(X[i][s])) + Switch * sum(X[i][s] for i != d):
+// the constant part is added to the offset of the objective. A service with no dataplane costs Add * sum(X[i][s]).
+func (m *Model) AddMigration(mig Migration) error {
+	svcMap := getSvcMapFromList(m.Services)
+
+	deployed := make([]int, len(m.Services))
+	for s := range deployed {
+		deployed[s] = -1
+	}
+	for svc, i := range mig.Deployed {
+		s, ok := svcMap[svc]
+		if !ok {
+			glog.Warningf("Deployed service %s is not in the graph anymore", svc)
+			continue
+		}
+		if i < 0 || i >= m.NumDataplanes() {
+			return fmt.Errorf("service %s is deployed with unknown dataplane %d", svc, i)
+		}
+		deployed[s] = i
+	}
+
+	// The number of changes is the number of services with a new dataplane, plus the number of services
+	// without their deployed dataplane.
+	changes := make([]Term, 0)
+	maxChanges := mig.MaxChanges
+	if maxChanges == NO_CHANGES {
+		maxChanges = 0
+	}
+
+	for s, svc := range m.Services {
+		cost := mig.costOf(svc)
+		d := deployed[s]
+
+		if d == -1 {
+			for i := range m.X {
+				if cost.Add != 0 {
+					m.Objective = append(m.Objective, Term{cost.Add, m.X[i][s]})
+				}
+				changes = append(changes, Term{1, m.X[i][s]})
+			}
+			continue
+		}
+
+		m.Offset += cost.Remove
+		for i := range m.X {
+			coef := cost.Switch - cost.Remove
+			if i == d {
+				coef = -cost.Remove
+			}
+			if coef != 0 {
+				m.Objective = append(m.Objective, Term{coef, m.X[i][s]})
+			}
+		}
+		changes = append(changes, Term{-1, m.X[d][s]})
+		maxChanges--
+	}
+
+	if mig.MaxChanges != NO_CHANGE_LIMIT {
+		if mig.MaxChanges < NO_CHANGES {
+			return fmt.Errorf("invalid maximum number of changes %d", mig.MaxChanges)
+		}
+		m.Add("max_changes", changes, LE, maxChanges)
+	}
+
+	return nil
+}
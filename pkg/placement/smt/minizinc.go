@@ -0,0 +1,106 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteMiniZinc writes the model in MiniZinc, e.g. for CP-SAT or Gecode through the minizinc command line tool.
+// Every variable is a 0..1 integer named like in SMT-LIB, every constraint is annotated with its name, and the
+// output prints the value of every variable. The objective is the one of WriteSMTLIB, without the offset.
+func (m *Model) WriteMiniZinc(w io.Writer) error {
+	var b strings.Builder
+
+	// Define the variables.
+	for _, v := range m.Vars {
+		fmt.Fprintf(&b, "var 0..1: %s;\n", v)
+	}
+
+	// Add the constraints.
+	for _, c := range m.Constraints {
+		fmt.Fprintf(&b, "constraint :: %q %s %s %d;\n", c.Name, miniZincSum(m, c.Terms), c.Sense, c.RHS)
+	}
+
+	// Add the objective function.
+	fmt.Fprintf(&b, "solve minimize %s;\n", miniZincSum(m, m.Objective))
+
+	// Print the value of every variable, like the default output of MiniZinc.
+	b.WriteString("output [\n")
+	for k, v := range m.Vars {
+		sep := ","
+		if k == len(m.Vars)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(&b, "  \"%s = \\(%s);\\n\"%s\n", v, v, sep)
+	}
+	b.WriteString("];\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func miniZincSum(m *Model, terms []Term) string {
+	if len(terms) == 0 {
+		return "0"
+	}
+	return strings.Join(infixTerms(m, terms, "*"), " ")
+}
+
+// Status lines of MiniZinc, printed after the solutions.
+const (
+	miniZincSolution = "----------"
+	miniZincOptimal  = "=========="
+)
+
+// ErrNoMiniZincStatus is returned when the output of MiniZinc has no solution and no status.
+var ErrNoMiniZincStatus = errors.New("no solution or status in MiniZinc output")
+
+// ParseMiniZincOutput parses the output of MiniZinc on a model written by WriteMiniZinc: a solution after
+// every improvement, with a line of "name = value;" for every variable and a line of dashes, followed by
+// "==========" once the last solution is optimal, or a status such as "=====UNSATISFIABLE=====".
+// The last solution is kept if MiniZinc stops before proving it optimal and it satisfies the constraints.
+func ParseMiniZincOutput(m *Model, out []byte) (*Result, error) {
+	var last map[string]string
+	named := make(map[string]string)
+	optimal := false
+
+	for k, line := range strings.Split(string(out), "\n") {
+		text := strings.TrimSpace(line)
+		switch {
+		case text == "" || strings.HasPrefix(text, "%"):
+			// A comment, e.g. statistics.
+		case text == miniZincSolution:
+			last, named = named, make(map[string]string)
+		case text == miniZincOptimal:
+			optimal = true
+		case text == "=====UNSATISFIABLE=====":
+			return &Result{Status: STATUS_UNSAT}, nil
+		case text == "=====UNKNOWN=====":
+			return &Result{Status: STATUS_UNKNOWN}, nil
+		case strings.HasPrefix(text, "=====") && strings.HasSuffix(text, "====="):
+			// =====ERROR=====, or =====UNBOUNDED=====, which a model of binary variables never is.
+			return nil, fmt.Errorf("minizinc: %s", strings.Trim(text, "="))
+		default:
+			name, value, ok := strings.Cut(strings.TrimSuffix(text, ";"), "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d of MiniZinc output: expected name = value, got %q", k+1, text)
+			}
+			named[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if last == nil {
+		return nil, ErrNoMiniZincStatus
+	}
+
+	values, err := valuesByName(m, last)
+	if err != nil {
+		return nil, err
+	}
+	if optimal {
+		return &Result{Status: STATUS_OPTIMAL, Values: values, Cost: m.Cost(values)}, nil
+	}
+	return feasibleResult(m, values, "MiniZinc"), nil
+}
@@ -0,0 +1,548 @@
+package smt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"xPlane"
+
+	"github.com/golang/glog"
+	"golang.org/x/exp/slices"
+)
+
+// Sense is the comparison of a linear constraint.
+type Sense int
+
+const (
+	LE Sense = iota
+	GE
+	EQ
+)
+
+func (s Sense) String() string {
+	switch s {
+	case LE:
+		return "<="
+	case GE:
+		return ">="
+	default:
+		return "="
+	}
+}
+
+// Term is a coefficient times a variable of the model.
+type Term struct {
+	Coef int
+	Var  int
+}
+
+// Constraint is a named linear constraint: the sum of the terms compared to RHS.
+type Constraint struct {
+	Name  string
+	Terms []Term
+	Sense Sense
+	RHS   int
+}
+
+// Check if the constraint holds for the given values of the variables.
+func (c *Constraint) Holds(values []bool) bool {
+	sum := activity(c.Terms, values)
+	switch c.Sense {
+	case LE:
+		return sum <= c.RHS
+	case GE:
+		return sum >= c.RHS
+	default:
+		return sum == c.RHS
+	}
+}
+
+func activity(terms []Term, values []bool) int {
+	sum := 0
+	for _, t := range terms {
+		if values[t.Var] {
+			sum += t.Coef
+		}
+	}
+	return sum
+}
+
+// Model is a solver-neutral placement problem: binary variables, linear constraints over them
+// and a linear cost to minimize. Every Solver takes a Model.
+type Model struct {
+	Vars        []string
+	Constraints []Constraint
+	Objective   []Term
+
+	// Constant part of the cost, that no value of the variables changes.
+	Offset int
+
+	// Layout of the placement variables, to read the placement from the values of the variables.
+	// X[i][m] is 1 if dataplane i runs at service m, and E[j][m] is 1 if policy j is enforced at service m,
+	// or -1 if service m never enforces policy j.
+	Services []string
+	X        [][]int
+	E        [][]int
+
+	// Y[j] is 1 if policy j is enforced by the senders and 0 if by the receivers, or -1 if only one side
+	// is allowed. Solvers decide these first, since they fix the E variables of the policy.
+	Y []int
+
+	// Cost of running dataplane i at service m, the part of the objective that is not migration cost.
+	Costs [][]int
+
+	// Structure of the placement, for solvers that search placements rather than values of the variables:
+	// the sides every policy can be enforced at, and the dataplane already assigned to every service, or -1.
+	Sides    []PolicySides
+	Assigned []int
+
+	// Nodes of the cluster, whose capacity the constraints added by AddCapacity refer to by index.
+	Nodes []string
+
+	// Values of the variables in a previous placement, that solvers try first: 1 or 0, or -1 if unknown.
+	// Nil if there is no previous placement. See SetHint.
+	Hint []int8
+}
+
+// PolicySides is the choice the model leaves for a policy: the services that enforce it if it is enforced by the
+// senders or by the receivers, nil if the side is not allowed, and the dataplanes that can enforce it at every service.
+type PolicySides struct {
+	Senders    []int
+	Receivers  []int
+	Dataplanes map[int][]int
+}
+
+// Add a binary variable and return its index.
+func (m *Model) NewVar(name string) int {
+	m.Vars = append(m.Vars, name)
+	return len(m.Vars) - 1
+}
+
+// Add a named constraint.
+func (m *Model) Add(name string, terms []Term, sense Sense, rhs int) {
+	m.Constraints = append(m.Constraints, Constraint{Name: name, Terms: terms, Sense: sense, RHS: rhs})
+}
+
+func (m *Model) NumDataplanes() int {
+	return len(m.X)
+}
+
+func (m *Model) NumPolicies() int {
+	return len(m.E)
+}
+
+// Get the cost of the given values of the variables.
+func (m *Model) Cost(values []bool) int {
+	return m.Offset + activity(m.Objective, values)
+}
+
+// Get the cost of running the dataplanes of the given values of the variables, without migration costs.
+func (m *Model) RunningCost(values []bool) int {
+	cost := 0
+	for i := range m.X {
+		for s := range m.Services {
+			if values[m.X[i][s]] {
+				cost += m.Costs[i][s]
+			}
+		}
+	}
+	return cost
+}
+
+// Check that the values satisfy every constraint, and return the first violated one otherwise.
+func (m *Model) Check(values []bool) error {
+	if len(values) != len(m.Vars) {
+		return fmt.Errorf("expected %d values, got %d", len(m.Vars), len(values))
+	}
+
+	for i := range m.Constraints {
+		if !m.Constraints[i].Holds(values) {
+			return fmt.Errorf("constraint %s is violated", m.Constraints[i].Name)
+		}
+	}
+	return nil
+}
+
+// Decode the values of the variables to the dataplane index of every service (-1 if none)
+// and the services implementing every policy, the input of the placement.
+func (m *Model) Decode(values []bool) (map[string]int, [][]string) {
+	sidecars := make(map[string]int)
+	for s, svc := range m.Services {
+		sidecars[svc] = -1
+		for i := range m.X {
+			if values[m.X[i][s]] {
+				sidecars[svc] = i
+			}
+		}
+	}
+
+	impls := make([][]string, len(m.E))
+	for j := range m.E {
+		for s, svc := range m.Services {
+			if m.E[j][s] != -1 && values[m.E[j][s]] {
+				impls[j] = append(impls[j], svc)
+			}
+		}
+	}
+
+	return sidecars, impls
+}
+
+// BuildModel formulates the placement of the policies on the application graph, given the dataplanes
+// already assigned to services and the cost of every dataplane.
+//
+// A policy is enforced either at all the senders or at all the receivers of the requests it applies to,
+// as allowed by its constraint, by a dataplane that supports it. Every service runs at most one dataplane.
+func BuildModel(policies []xPlane.Policy, applEdges map[string][]string, services []string, sidecarAssignment map[string]int, sidecarCost []int) (*Model, error) {
+	costs := make([][]int, len(sidecarCost))
+	for i := range costs {
+		costs[i] = make([]int, len(services))
+		for s := range services {
+			costs[i][s] = sidecarCost[i]
+		}
+	}
+
+	return buildModel(policies, applEdges, services, nil, sidecarAssignment, costs, nil)
+}
+
+// BuildModelForGraph formulates the placement on an application graph, with the cost of the dataplanes
+// weighted by the replicas and request rates of the services under the default cost model.
+// See BuildWeightedModelForGraph.
+func BuildModelForGraph(policies []xPlane.Policy, g *xPlane.ApplicationGraph, sidecarAssignment map[string]int, dataplanes []xPlane.Dataplane) (*Model, error) {
+	return BuildWeightedModelForGraph(policies, g, sidecarAssignment, dataplanes, xPlane.DefaultCostModel)
+}
+
+// BuildWeightedModelForGraph formulates the placement on an application graph. See BuildModel.
+// A policy can only be enforced by a dataplane that supports the protocol of the edges it is enforced on,
+// and running a dataplane at a service costs the resources it uses there, see CostModel.DataplaneCost.
+func BuildWeightedModelForGraph(policies []xPlane.Policy, g *xPlane.ApplicationGraph, sidecarAssignment map[string]int, dataplanes []xPlane.Dataplane, costModel xPlane.CostModel) (*Model, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	compatible := func(i int, from string, to string) bool {
+		attrs, _ := g.GetEdgeAttributes(from, to)
+		return dataplanes[i].SupportsProtocol(attrs.Protocol)
+	}
+
+	services := g.GetServices()
+	rates := g.GetRequestRates()
+	costs := make([][]int, len(dataplanes))
+	for _, d := range dataplanes {
+		i := d.GetIndex()
+		costs[i] = make([]int, len(services))
+		for s, svc := range services {
+			costs[i][s] = costModel.DataplaneCost(&d, g.GetReplicas(svc), rates[svc])
+		}
+	}
+
+	return buildModel(policies, g.GetEdges(), services, g.GetLabels, sidecarAssignment, costs, compatible)
+}
+
+// canEnforce checks if dataplane i can enforce a policy on the request sent from one service to another.
+// A nil canEnforce allows any dataplane on any edge.
+type canEnforce func(i int, from string, to string) bool
+
+// Check if dataplane i can enforce a policy at the service, i.e. on every enforcement edge the service sends or receives.
+func canEnforceAt(compatible canEnforce, i int, service string, enforcementEdges map[string][]string) bool {
+	for sender, receivers := range enforcementEdges {
+		for _, receiver := range receivers {
+			if (sender == service || receiver == service) && !compatible(i, sender, receiver) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// UnresolvedPolicyError is returned when the context of a policy matches no request of the application, so
+// the policy could not be enforced anywhere, e.g. because the context names a service that is not in the graph.
+type UnresolvedPolicyError struct {
+	Policy  int
+	Context []string
+	// The hop of the context that matches no service, or "" if every hop matches some service.
+	Endpoint string
+}
+
+func (e *UnresolvedPolicyError) Error() string {
+	context := strings.Join(e.Context, "->")
+	if e.Endpoint == "" {
+		return fmt.Sprintf("policy %d (%s) matches no request of the application", e.Policy, context)
+	}
+	return fmt.Sprintf("policy %d (%s): %s matches no service of the application", e.Policy, context, e.Endpoint)
+}
+
+// Find the first hop of the policy context that no service matches, e.g. a misspelled service name.
+func unresolvedEndpoint(tokens []string, services []string, labels func(string) map[string]string) string {
+	context, err := xPlane.ParseContextTokens(tokens)
+	if err != nil {
+		return ""
+	}
+	context.WithLabels(labels)
+	for _, h := range context.Hops {
+		if h.Negated || h.Optional {
+			continue
+		}
+		if h.Kind == xPlane.HOP_SERVICES {
+			for _, svc := range h.Services {
+				if !slices.Contains(services, svc) {
+					return svc
+				}
+			}
+			continue
+		}
+		if !slices.ContainsFunc(services, func(svc string) bool { return context.MatchesService(h, svc) }) {
+			return h.String()
+		}
+	}
+	return ""
+}
+
+// Build the model, where costs[i][m] is the cost of running dataplane i at service m.
+// Label selectors match the labels returned by labels, which is nil if no service has labels.
+func buildModel(policies []xPlane.Policy, applEdges map[string][]string, services []string, labels func(string) map[string]string, sidecarAssignment map[string]int, costs [][]int, compatible canEnforce) (*Model, error) {
+	// Service map is needed to map service names to their index in the variables.
+	svcMap := getSvcMapFromList(services)
+
+	// Useful variables.
+	numPolicies := len(policies)
+	numServices := len(svcMap)
+	numDataplanes := len(costs)
+
+	// All policy contexts must be valid.
+	for _, p := range policies {
+		if _, err := p.GetContextExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Model{Services: append([]string{}, services...), Costs: costs}
+
+	// Define the "Exists" variables.
+	m.X = make([][]int, numDataplanes)
+	for i := 0; i < numDataplanes; i++ {
+		m.X[i] = make([]int, numServices)
+		for s := 0; s < numServices; s++ {
+			m.X[i][s] = m.NewVar(fmt.Sprintf("X_%d_%d", i, s))
+		}
+	}
+
+	// Constraint 1 : Policies must be implemented either by all penultimate nodes or all last nodes.
+	// Constraint 2 : Policies must be implemented as per their annotation constraints.
+	// The "Executes" variables are only defined at the services of the allowed sides, all other services
+	// do not implement the policy.
+	m.E = make([][]int, numPolicies)
+	m.Y = make([]int, numPolicies)
+	m.Sides = make([]PolicySides, numPolicies)
+	for j := 0; j < numPolicies; j++ {
+		penultimateNodes, lastNodes := getPolicyImpls(policies[j].GetContext(), applEdges, labels, svcMap)
+
+		senderAllowed := policies[j].GetConstraint() != xPlane.RECEIVER && len(penultimateNodes) > 0
+		receiverAllowed := policies[j].GetConstraint() != xPlane.SENDER && len(lastNodes) > 0
+		if !senderAllowed && !receiverAllowed {
+			context := policies[j].GetContext()
+			return nil, &UnresolvedPolicyError{Policy: j, Context: context, Endpoint: unresolvedEndpoint(context, services, labels)}
+		}
+		if senderAllowed {
+			m.Sides[j].Senders = penultimateNodes
+		}
+		if receiverAllowed {
+			m.Sides[j].Receivers = lastNodes
+		}
+
+		m.E[j] = make([]int, numServices)
+		for s := 0; s < numServices; s++ {
+			m.E[j][s] = -1
+		}
+		for _, s := range append(append([]int{}, m.Sides[j].Senders...), m.Sides[j].Receivers...) {
+			if m.E[j][s] == -1 {
+				m.E[j][s] = m.NewVar(fmt.Sprintf("E_%d_%d", j, s))
+			}
+		}
+
+		m.Y[j] = -1
+		if senderAllowed && receiverAllowed {
+			// Y is 1 if the policy is implemented by the penultimate nodes, and 0 if by the last nodes.
+			y := m.NewVar(fmt.Sprintf("Y_%d", j))
+			m.Y[j] = y
+			for _, s := range penultimateNodes {
+				m.Add(fmt.Sprintf("sender_%d_%d", j, s), []Term{{1, m.E[j][s]}, {-1, y}}, GE, 0)
+				if !slices.Contains(lastNodes, s) {
+					m.Add(fmt.Sprintf("only_sender_%d_%d", j, s), []Term{{1, m.E[j][s]}, {-1, y}}, LE, 0)
+				}
+			}
+			for _, s := range lastNodes {
+				m.Add(fmt.Sprintf("receiver_%d_%d", j, s), []Term{{1, m.E[j][s]}, {1, y}}, GE, 1)
+				if !slices.Contains(penultimateNodes, s) {
+					m.Add(fmt.Sprintf("only_receiver_%d_%d", j, s), []Term{{1, m.E[j][s]}, {1, y}}, LE, 1)
+				}
+			}
+		} else if senderAllowed {
+			for _, s := range penultimateNodes {
+				m.Add(fmt.Sprintf("sender_%d_%d", j, s), []Term{{1, m.E[j][s]}}, EQ, 1)
+			}
+		} else if receiverAllowed {
+			for _, s := range lastNodes {
+				m.Add(fmt.Sprintf("receiver_%d_%d", j, s), []Term{{1, m.E[j][s]}}, EQ, 1)
+			}
+		}
+	}
+
+	// Constraint 3 : For any service m, at most one i can be such that X[i][m] = 1.
+	for s := 0; s < numServices; s++ {
+		terms := make([]Term, 0, numDataplanes)
+		for i := 0; i < numDataplanes; i++ {
+			terms = append(terms, Term{1, m.X[i][s]})
+		}
+		m.Add(fmt.Sprintf("one_dataplane_%d", s), terms, LE, 1)
+	}
+
+	// Constraint 4 : If E[j][m] = 1, then X[i][m] = 1 for some i that supports policy j,
+	// and can parse the requests of every edge where m enforces policy j.
+	for j := 0; j < numPolicies; j++ {
+		supportedDataplanes := policies[j].GetDataplanes()
+
+		var enforcementEdges map[string][]string
+		if compatible != nil {
+			enforcementEdges = getEnforcementEdges(policies[j].GetContext(), applEdges, labels)
+		}
+
+		m.Sides[j].Dataplanes = make(map[int][]int)
+		for s := 0; s < numServices; s++ {
+			if m.E[j][s] == -1 {
+				continue
+			}
+
+			terms := []Term{{1, m.E[j][s]}}
+			m.Sides[j].Dataplanes[s] = []int{}
+			for i := 0; i < numDataplanes; i++ {
+				if !slices.Contains(supportedDataplanes, i) {
+					continue
+				}
+				if compatible != nil && !canEnforceAt(compatible, i, services[s], enforcementEdges) {
+					continue
+				}
+				terms = append(terms, Term{-1, m.X[i][s]})
+				m.Sides[j].Dataplanes[s] = append(m.Sides[j].Dataplanes[s], i)
+			}
+			m.Add(fmt.Sprintf("supported_%d_%d", j, s), terms, LE, 0)
+		}
+	}
+
+	// Constraint 5 : If dataplane i is already assigned to a service m, then X[i][m] = 1.
+	m.Assigned = make([]int, numServices)
+	for s := 0; s < numServices; s++ {
+		m.Assigned[s] = -1
+		if i, ok := sidecarAssignment[services[s]]; ok {
+			if i < 0 || i >= numDataplanes {
+				return nil, fmt.Errorf("service %s is assigned to unknown dataplane %d", services[s], i)
+			}
+			m.Add(fmt.Sprintf("existing_%d", s), []Term{{1, m.X[i][s]}}, EQ, 1)
+			m.Assigned[s] = i
+		}
+	}
+
+	// Add the objective function.
+	for i := 0; i < numDataplanes; i++ {
+		for s := 0; s < numServices; s++ {
+			m.Objective = append(m.Objective, Term{costs[i][s], m.X[i][s]})
+		}
+	}
+
+	glog.Infof("Built model with %d variables and %d constraints", len(m.Vars), len(m.Constraints))
+	return m, nil
+}
+
+// Write a sum of terms as an SMT-LIB expression.
+func smtSum(m *Model, terms []Term) string {
+	if len(terms) == 0 {
+		return "0"
+	}
+
+	list := make([]string, 0, len(terms))
+	for _, t := range terms {
+		if t.Coef == 1 {
+			list = append(list, m.Vars[t.Var])
+		} else {
+			list = append(list, fmt.Sprintf("(* %d %s)", t.Coef, m.Vars[t.Var]))
+		}
+	}
+	if len(list) == 1 {
+		return list[0]
+	}
+	return fmt.Sprintf("(+ %s)", strings.Join(list, " "))
+}
+
+// Get a sum of terms in the infix notation of MiniZinc and CPLEX-LP, one term with its sign at a time,
+// e.g. "2*X_0_0", "+ E_0_1", "- Y_0", with times between a coefficient and its variable.
+func infixTerms(m *Model, terms []Term, times string) []string {
+	list := make([]string, 0, len(terms))
+	for k, t := range terms {
+		coef, sign := t.Coef, "+ "
+		switch {
+		case k == 0 && coef < 0:
+			coef, sign = -coef, "-"
+		case k == 0:
+			sign = ""
+		case coef < 0:
+			coef, sign = -coef, "- "
+		}
+		if coef == 1 {
+			list = append(list, sign+m.Vars[t.Var])
+		} else {
+			list = append(list, fmt.Sprintf("%s%d%s%s", sign, coef, times, m.Vars[t.Var]))
+		}
+	}
+	return list
+}
+
+// Write the variables and the constraints of the model in SMT-LIB. Every constraint is a named assertion,
+// so that an unsat core refers to the constraints by name.
+func (m *Model) writeSMTLIBAssertions(b *strings.Builder) {
+	// Define the variables.
+	for _, v := range m.Vars {
+		fmt.Fprintf(b, "(declare-const %s Int)\n", v)
+		fmt.Fprintf(b, "(assert (or (= %s 0) (= %s 1)))\n", v, v)
+	}
+
+	// Add the constraints.
+	for _, c := range m.Constraints {
+		fmt.Fprintf(b, "(assert (! (%s %s %d) :named %s))\n", c.Sense, smtSum(m, c.Terms), c.RHS, c.Name)
+	}
+}
+
+// WriteSMTLIB writes the model in the SMT-LIB format understood by z3, followed by the commands
+// to minimize the cost and get the value of every variable. See writeSMTLIBHint for models with a hint.
+func (m *Model) WriteSMTLIB(w io.Writer) error {
+	var b strings.Builder
+	m.writeSMTLIBAssertions(&b)
+
+	// Add the objective function.
+	fmt.Fprintf(&b, "(minimize %s)\n", smtSum(m, m.Objective))
+	if m.Hint != nil {
+		m.writeSMTLIBHint(&b)
+	}
+
+	// Add instructions for the z3 solver.
+	b.WriteString("(check-sat)\n")
+	for _, v := range m.Vars {
+		fmt.Fprintf(&b, "(get-value (%s))\n", v)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteSMTLIBCore writes the constraints of the model in SMT-LIB, followed by the commands to check
+// if they have a solution and get a minimal unsat core otherwise: the names of constraints that have no solution together.
+func (m *Model) WriteSMTLIBCore(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("(set-option :produce-unsat-cores true)\n")
+	b.WriteString("(set-option :smt.core.minimize true)\n")
+	m.writeSMTLIBAssertions(&b)
+	b.WriteString("(check-sat)\n")
+	b.WriteString("(get-unsat-core)\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
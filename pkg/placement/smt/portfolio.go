@@ -0,0 +1,212 @@
+package smt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ImprovingSolver is a Solver that reports the solutions it finds while it searches, before it returns.
+type ImprovingSolver interface {
+	Solver
+
+	// SolveImproving is SolveContext, and calls improved with every solution found, each cheaper than the last.
+	// The values must not be modified.
+	SolveImproving(ctx context.Context, m *Model, improved func(values []bool, cost int)) (*Result, error)
+}
+
+// Incumbent is a solution found by a solver of a portfolio, cheaper than all the ones found before it.
+type Incumbent struct {
+	// Index of the solver in Portfolio.Solvers.
+	Solver int
+
+	Values  []bool
+	Cost    int
+	Elapsed time.Duration
+}
+
+// RaceResult is the outcome of a race of the solvers of a portfolio.
+type RaceResult struct {
+	// The best solution found, with STATUS_OPTIMAL if a solver proved it optimal.
+	*Result
+
+	// Index of the solver that found the values, or -1 if none did.
+	Solver int
+
+	// Whether a solver proved the cost optimal, or proved that the model has no solution.
+	Proven bool
+
+	// Result and error of every solver, indexed like Portfolio.Solvers. The solvers still running when
+	// the race ends are stopped, and return their best solution or the error of the context.
+	Results []*Result
+	Errors  []error
+}
+
+// Portfolio races several solvers, or configurations of a solver, on the same model: it runs them concurrently
+// until one proves a solution optimal or the model infeasible, all of them return, or the deadline passes,
+// and keeps the best solution found by any of them.
+type Portfolio struct {
+	Solvers []Solver
+
+	// Deadline of the race, or less if the context has an earlier deadline. Zero means no deadline.
+	Timeout time.Duration
+}
+
+// NewPortfolio returns a portfolio of the given solvers, or by default of z3 on the reduced model, the heuristic
+// solver, which finds good solutions early, and the embedded exact solver.
+func NewPortfolio(solvers ...Solver) *Portfolio {
+	if len(solvers) == 0 {
+		solvers = []Solver{NewReducingSolver(NewZ3Solver()), NewHeuristicSolver(), NewGoSolver()}
+	}
+	return &Portfolio{Solvers: solvers, Timeout: 60 * time.Second}
+}
+
+func (p *Portfolio) Name() string {
+	return "portfolio"
+}
+
+func (p *Portfolio) Solve(m *Model) (*Result, error) {
+	return p.SolveContext(context.Background(), m)
+}
+
+// SolveContext races the solvers and returns the best solution found. See Race.
+func (p *Portfolio) SolveContext(ctx context.Context, m *Model) (*Result, error) {
+	return p.SolveImproving(ctx, m, nil)
+}
+
+// SolveImproving races the solvers, and calls improved with every solution found by any of them, each cheaper
+// than the last. See Race.
+func (p *Portfolio) SolveImproving(ctx context.Context, m *Model, improved func(values []bool, cost int)) (*Result, error) {
+	race, err := p.Race(ctx, m, func(inc Incumbent) {
+		if improved != nil {
+			improved(inc.Values, inc.Cost)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return race.Result, nil
+}
+
+// Race runs the solvers concurrently, and calls improved with every solution found by any of them that is
+// cheaper than all the ones before it, as soon as it is found, if improved is not nil. The calls are never
+// concurrent. The solvers that implement ImprovingSolver report their solutions while they search, and the
+// others when they return.
+//
+// The race ends when a solver proves a solution optimal or the model infeasible, and the other solvers are
+// stopped, or when all of them return, or at the deadline. It returns the best solution found, which is
+// STATUS_OPTIMAL only if a solver proved it optimal. An error is returned only if every solver fails.
+func (p *Portfolio) Race(ctx context.Context, m *Model, improved func(Incumbent)) (*RaceResult, error) {
+	if len(p.Solvers) == 0 {
+		return nil, errors.New("no solvers in the portfolio")
+	}
+
+	start := time.Now()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	race := &RaceResult{
+		Result:  &Result{Status: STATUS_UNKNOWN, Gap: -1},
+		Solver:  -1,
+		Results: make([]*Result, len(p.Solvers)),
+		Errors:  make([]error, len(p.Solvers)),
+	}
+	var unsat *Result
+	var mu sync.Mutex
+
+	// Keep a solution if it is the best one found. Called with mu held.
+	offer := func(k int, values []bool, cost int) {
+		if race.Solver != -1 && cost >= race.Cost {
+			return
+		}
+		race.Result = &Result{Status: STATUS_SAT, Values: values, Cost: cost, Gap: -1}
+		race.Solver = k
+		glog.Infof("Portfolio: %s found cost %d after %v", p.Solvers[k].Name(), cost, time.Since(start))
+		if improved != nil {
+			improved(Incumbent{Solver: k, Values: values, Cost: cost, Elapsed: time.Since(start)})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for k, solver := range p.Solvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var result *Result
+			var err error
+			if s, ok := solver.(ImprovingSolver); ok {
+				result, err = s.SolveImproving(ctx, m, func(values []bool, cost int) {
+					mu.Lock()
+					defer mu.Unlock()
+					offer(k, values, cost)
+				})
+			} else {
+				result, err = solver.SolveContext(ctx, m)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			race.Results[k], race.Errors[k] = result, err
+			if err != nil {
+				// The solvers stopped at the end of the race return the error of the context.
+				if ctx.Err() == nil {
+					glog.Warningf("Portfolio: error running %s: %v", solver.Name(), err)
+				}
+				return
+			}
+
+			switch result.Status {
+			case STATUS_OPTIMAL:
+				offer(k, result.Values, result.Cost)
+				if !race.Proven {
+					glog.Infof("Portfolio: %s proved cost %d optimal after %v", solver.Name(), result.Cost, time.Since(start))
+				}
+				race.Proven = true
+				stop()
+			case STATUS_SAT:
+				offer(k, result.Values, result.Cost)
+			case STATUS_UNSAT:
+				if unsat == nil {
+					glog.Infof("Portfolio: %s proved there is no solution after %v", solver.Name(), time.Since(start))
+					unsat = result
+				}
+				stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case race.Proven:
+		race.Status = STATUS_OPTIMAL
+		race.Gap = 0
+	case race.Solver != -1:
+		if unsat != nil {
+			glog.Warning("Portfolio: a solver found no solution, but another one did")
+		}
+	case unsat != nil:
+		race.Result = unsat
+		race.Proven = true
+	default:
+		// Fail only if every solver failed.
+		var errs []error
+		for _, err := range race.Errors {
+			if err == nil {
+				return race, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	return race, nil
+}
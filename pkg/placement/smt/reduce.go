@@ -0,0 +1,667 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"golang.org/x/exp/slices"
+)
+
+// Prefix of the names of the constraints that fix a variable of a component to the value the reduction chose.
+const fixedPrefix = "fixed_"
+
+// Reduction is a model reduced to smaller independent models, see Reduce.
+type Reduction struct {
+	// Independent models left to solve. Solutions of all of them, together with the variables the reduction
+	// fixed, are a solution of the model, and an optimal one if all of them are optimal.
+	Components []*Model
+
+	// Whether the reduction found that the model has no solution.
+	Infeasible bool
+
+	// Number of policies merged into an earlier one, of variables fixed, and of services in no component.
+	MergedPolicies  int
+	FixedVars       int
+	RemovedServices int
+
+	model *Model
+
+	// Value of every variable fixed by the reduction, or -1.
+	fixed []int8
+
+	// Variable whose value every variable of a merged policy takes, or -1.
+	alias []int
+
+	// Variable of the model of every variable of every component. The first owned[c] variables of component c
+	// are decided by it, the others are only there for the structure of the component.
+	vars  [][]int
+	owned []int
+}
+
+// Reduce reduces a model before solving it:
+//   - Policies with the same constraints as an earlier policy, i.e. the same enforcement sets and the same
+//     dataplanes supporting their functions, are merged into it.
+//   - Variables whose value is forced by the constraints are fixed, as well as variables whose best value does not
+//     depend on the others, e.g. the dataplanes of services that enforce no policy.
+//   - The variables left are split into components that share no constraint, each an independent model with
+//     the services and the policies of its variables, so that the structural solvers can solve it.
+//
+// The names of the constraints of the components are the ones of the model, with the fixed variables moved to
+// the right hand side.
+func Reduce(m *Model) *Reduction {
+	r := &Reduction{model: m}
+
+	work := r.mergePolicies()
+	s := newGoSearch(work, 0)
+	if !r.fix(s) {
+		r.Infeasible = true
+		glog.Info("Reduction found no solution")
+		return r
+	}
+
+	r.fixed = append([]int8{}, s.values...)
+	for _, value := range r.fixed {
+		if value != -1 {
+			r.FixedVars++
+		}
+	}
+	r.split(s)
+
+	numVars := 0
+	for _, c := range r.Components {
+		numVars += len(c.Vars)
+	}
+	glog.Infof("Reduced model with %d variables to %d components with %d variables: %d policies merged, %d variables fixed, %d services removed",
+		len(m.Vars), len(r.Components), numVars, r.MergedPolicies, r.FixedVars, r.RemovedServices)
+	return r
+}
+
+// Get the policy of every E and Y variable of the model, and the service of every X and E variable, or -1.
+func (m *Model) layout() ([]int, []int) {
+	policyOf := make([]int, len(m.Vars))
+	serviceOf := make([]int, len(m.Vars))
+	for v := range m.Vars {
+		policyOf[v] = -1
+		serviceOf[v] = -1
+	}
+
+	for i := range m.X {
+		for s, v := range m.X[i] {
+			serviceOf[v] = s
+		}
+	}
+	for j := range m.E {
+		for s, v := range m.E[j] {
+			if v != -1 {
+				policyOf[v] = j
+				serviceOf[v] = s
+			}
+		}
+		if j < len(m.Y) && m.Y[j] != -1 {
+			policyOf[m.Y[j]] = j
+		}
+	}
+	return policyOf, serviceOf
+}
+
+// Merge every policy whose constraints are the ones of an earlier policy, up to the names of their variables,
+// into the earlier policy. Returns the model without the constraints of the merged policies, whose variables
+// take the values of the variables of the earlier policy.
+func (r *Reduction) mergePolicies() *Model {
+	m := r.model
+	r.alias = make([]int, len(m.Vars))
+	for v := range r.alias {
+		r.alias[v] = -1
+	}
+	policyOf, serviceOf := m.layout()
+
+	// Constraints of every policy. The variables of a policy that appear in constraints of other policies
+	// or in the objective cannot take the values of another policy.
+	constraints := make([][]int, len(m.E))
+	owner := make([]int, len(m.Constraints))
+	mergeable := make([]bool, len(m.E))
+	for j := range mergeable {
+		mergeable[j] = true
+	}
+	for _, t := range m.Objective {
+		if j := policyOf[t.Var]; j != -1 && t.Coef != 0 {
+			mergeable[j] = false
+		}
+	}
+	for k, c := range m.Constraints {
+		var policies []int
+		for _, t := range c.Terms {
+			if j := policyOf[t.Var]; j != -1 && !slices.Contains(policies, j) {
+				policies = append(policies, j)
+			}
+		}
+
+		owner[k] = -1
+		if len(policies) == 1 {
+			owner[k] = policies[0]
+			constraints[policies[0]] = append(constraints[policies[0]], k)
+		} else {
+			for _, j := range policies {
+				mergeable[j] = false
+			}
+		}
+	}
+
+	// The constraints of a policy, with its variables named by the service, and the index of the policy removed
+	// from the names of the constraints.
+	signature := func(j int) string {
+		var lines []string
+		for s, v := range m.E[j] {
+			if v != -1 {
+				lines = append(lines, fmt.Sprintf("E_%d", s))
+			}
+		}
+		if j < len(m.Y) && m.Y[j] != -1 {
+			lines = append(lines, "Y")
+		}
+
+		for _, k := range constraints[j] {
+			c := &m.Constraints[k]
+			var b strings.Builder
+			b.WriteString(policyConstraintName(c.Name, j))
+			fmt.Fprintf(&b, " %s %d:", c.Sense, c.RHS)
+			for _, t := range c.Terms {
+				switch {
+				case policyOf[t.Var] == j && serviceOf[t.Var] != -1:
+					fmt.Fprintf(&b, " %d*E_%d", t.Coef, serviceOf[t.Var])
+				case policyOf[t.Var] == j:
+					fmt.Fprintf(&b, " %d*Y", t.Coef)
+				default:
+					fmt.Fprintf(&b, " %d*%s", t.Coef, m.Vars[t.Var])
+				}
+			}
+			lines = append(lines, b.String())
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n")
+	}
+
+	merged := make([]bool, len(m.E))
+	first := make(map[string]int)
+	for j := range m.E {
+		if !mergeable[j] || len(constraints[j]) == 0 {
+			continue
+		}
+		sig := signature(j)
+		k, ok := first[sig]
+		if !ok {
+			first[sig] = j
+			continue
+		}
+
+		for s, v := range m.E[j] {
+			if v != -1 {
+				r.alias[v] = m.E[k][s]
+			}
+		}
+		if j < len(m.Y) && m.Y[j] != -1 {
+			r.alias[m.Y[j]] = m.Y[k]
+		}
+		merged[j] = true
+		r.MergedPolicies++
+	}
+
+	work := *m
+	work.Constraints = make([]Constraint, 0, len(m.Constraints))
+	for k := range m.Constraints {
+		if owner[k] == -1 || !merged[owner[k]] {
+			work.Constraints = append(work.Constraints, m.Constraints[k])
+		}
+	}
+	return &work
+}
+
+// Get the name of a constraint of policy j without the index of the policy, e.g. sender_5 for sender_3_5.
+func policyConstraintName(name string, j int) string {
+	for _, family := range policyFamilies {
+		if rest, ok := strings.CutPrefix(name, family+"_"+strconv.Itoa(j)+"_"); ok {
+			return family + "_" + rest
+		}
+	}
+	return name
+}
+
+// Get the constraints that can still be violated: the ones whose activity can exceed their right hand side
+// given the assigned variables.
+func (s *goSearch) liveConstraints() []bool {
+	live := make([]bool, len(s.constraints))
+	for k, c := range s.constraints {
+		highest := s.fixed[k]
+		for _, t := range c.terms {
+			if s.values[t.Var] == -1 && t.Coef > 0 {
+				highest += t.Coef
+			}
+		}
+		live[k] = highest > c.rhs
+	}
+	return live
+}
+
+// Fix the variables whose value is forced by the constraints, and the variables whose best value does not depend
+// on the others: a variable that only makes the constraints it appears in harder to satisfy is 0 if it costs
+// nothing or more, and one that only makes them easier is 1 if it costs nothing or less. Constraints that hold
+// whatever the values of their unassigned variables are left out. Returns false if the model has no solution.
+func (r *Reduction) fix(s *goSearch) bool {
+	all := make([]int, len(s.constraints))
+	for k := range all {
+		all[k] = k
+	}
+	if !s.propagate(all) {
+		return false
+	}
+
+	for changed := true; changed; {
+		changed = false
+		live := s.liveConstraints()
+		for v, value := range s.values {
+			if value != -1 {
+				continue
+			}
+
+			harder, easier := false, false
+			for _, o := range s.occurs[v] {
+				if live[o.constraint] {
+					harder = harder || o.coef > 0
+					easier = easier || o.coef < 0
+				}
+			}
+			switch {
+			case !easier && s.cost[v] >= 0:
+				value = 0
+			case !harder && s.cost[v] <= 0:
+				value = 1
+			default:
+				continue
+			}
+
+			if !s.assignAndPropagate(v, value) {
+				return false
+			}
+			changed = true
+		}
+	}
+	return true
+}
+
+// Split the unassigned variables into components, the variables connected by the constraints that can still
+// be violated, and build the model of every component.
+func (r *Reduction) split(s *goSearch) {
+	m := r.model
+	work := s.model
+	live := s.liveConstraints()
+
+	parent := make([]int, len(m.Vars))
+	for v := range parent {
+		parent[v] = v
+	}
+	find := func(v int) int {
+		for parent[v] != v {
+			parent[v] = parent[parent[v]]
+			v = parent[v]
+		}
+		return v
+	}
+
+	// Constraints of the model that can still be violated, and the first unassigned variable of every one.
+	first := make([]int, len(work.Constraints))
+	for k := range first {
+		first[k] = -1
+	}
+	for k, c := range s.constraints {
+		if !live[k] {
+			continue
+		}
+		source := s.source[k]
+		for _, t := range c.terms {
+			if s.values[t.Var] != -1 {
+				continue
+			}
+			if first[source] == -1 {
+				first[source] = t.Var
+			} else {
+				parent[find(t.Var)] = find(first[source])
+			}
+		}
+	}
+
+	// Number the components in the order of their first variable.
+	component := make([]int, len(m.Vars))
+	roots := make(map[int]int)
+	var free [][]int
+	for v := range m.Vars {
+		component[v] = -1
+		if s.values[v] != -1 {
+			continue
+		}
+		c, ok := roots[find(v)]
+		if !ok {
+			c = len(free)
+			roots[find(v)] = c
+			free = append(free, nil)
+		}
+		component[v] = c
+		free[c] = append(free[c], v)
+	}
+
+	constraints := make([][]int, len(free))
+	for k, v := range first {
+		if v != -1 {
+			constraints[component[v]] = append(constraints[component[v]], k)
+		}
+	}
+
+	// The services and the policies of every component: the ones of its variables, and the policies whose
+	// constraints it has, with the services they are enforced at.
+	policyOf, serviceOf := m.layout()
+	inComponent := make([]bool, len(m.Services))
+	for c := range free {
+		var services, policies []int
+		addService := func(s int) {
+			if s != -1 && !slices.Contains(services, s) {
+				services = append(services, s)
+			}
+		}
+		addPolicy := func(j int) {
+			if j != -1 && !slices.Contains(policies, j) {
+				policies = append(policies, j)
+			}
+		}
+
+		for _, v := range free[c] {
+			addService(serviceOf[v])
+			addPolicy(policyOf[v])
+		}
+		for _, k := range constraints[c] {
+			for _, t := range work.Constraints[k].Terms {
+				if policyOf[t.Var] != -1 {
+					addPolicy(policyOf[t.Var])
+					addService(serviceOf[t.Var])
+				}
+			}
+		}
+		sort.Ints(services)
+		sort.Ints(policies)
+		for _, s := range services {
+			inComponent[s] = true
+		}
+
+		r.buildComponent(work, free[c], constraints[c], services, policies)
+	}
+
+	for _, ok := range inComponent {
+		if !ok {
+			r.RemovedServices++
+		}
+	}
+}
+
+// Build the model of a component with the given variables, constraints, services and policies.
+func (r *Reduction) buildComponent(work *Model, free []int, constraints []int, services []int, policies []int) {
+	m := r.model
+	sub := &Model{Nodes: m.Nodes}
+
+	index := make(map[int]int)
+	var vars []int
+	add := func(v int) int {
+		if k, ok := index[v]; ok {
+			return k
+		}
+		index[v] = sub.NewVar(m.Vars[v])
+		vars = append(vars, v)
+		return index[v]
+	}
+	for _, v := range free {
+		add(v)
+	}
+
+	serviceIndex := make(map[int]int)
+	for k, s := range services {
+		serviceIndex[s] = k
+		sub.Services = append(sub.Services, m.Services[s])
+	}
+
+	sub.X = make([][]int, len(m.X))
+	for i := range m.X {
+		for _, s := range services {
+			sub.X[i] = append(sub.X[i], add(m.X[i][s]))
+		}
+	}
+	if m.Costs != nil {
+		sub.Costs = make([][]int, len(m.Costs))
+		for i := range m.Costs {
+			for _, s := range services {
+				sub.Costs[i] = append(sub.Costs[i], m.Costs[i][s])
+			}
+		}
+	}
+	if m.Assigned != nil {
+		for _, s := range services {
+			sub.Assigned = append(sub.Assigned, m.Assigned[s])
+		}
+	}
+
+	restrict := func(list []int) []int {
+		var restricted []int
+		for _, s := range list {
+			if k, ok := serviceIndex[s]; ok {
+				restricted = append(restricted, k)
+			}
+		}
+		return restricted
+	}
+	for _, j := range policies {
+		e := make([]int, len(services))
+		for k, s := range services {
+			e[k] = -1
+			if v := m.E[j][s]; v != -1 {
+				e[k] = add(v)
+			}
+		}
+		sub.E = append(sub.E, e)
+
+		// A policy whose side is fixed is only enforced at that side.
+		y, side := -1, -1
+		if j < len(m.Y) && m.Y[j] != -1 {
+			if side = int(r.fixed[m.Y[j]]); side == -1 {
+				y = add(m.Y[j])
+			}
+		}
+		sub.Y = append(sub.Y, y)
+
+		if m.Sides != nil {
+			sides := PolicySides{Dataplanes: make(map[int][]int)}
+			if side != sideReceivers {
+				sides.Senders = restrict(m.Sides[j].Senders)
+			}
+			if side != sideSenders {
+				sides.Receivers = restrict(m.Sides[j].Receivers)
+			}
+			for s, list := range m.Sides[j].Dataplanes {
+				if k, ok := serviceIndex[s]; ok {
+					sides.Dataplanes[k] = list
+				}
+			}
+			sub.Sides = append(sub.Sides, sides)
+		}
+	}
+
+	for _, k := range constraints {
+		c := &work.Constraints[k]
+		rhs := c.RHS
+		var terms []Term
+		for _, t := range c.Terms {
+			switch r.fixed[t.Var] {
+			case -1:
+				terms = append(terms, Term{t.Coef, add(t.Var)})
+			case 1:
+				rhs -= t.Coef
+			}
+		}
+		sub.Add(c.Name, terms, c.Sense, rhs)
+	}
+
+	// The variables of the structure keep their fixed values, and the ones of other components are left free.
+	for k, v := range vars[len(free):] {
+		if value := r.fixed[v]; value != -1 {
+			sub.Add(fixedPrefix+m.Vars[v], []Term{{1, len(free) + k}}, EQ, int(value))
+		}
+	}
+	for _, t := range m.Objective {
+		if k, ok := index[t.Var]; ok && (k < len(free) || r.fixed[t.Var] != -1) {
+			sub.Objective = append(sub.Objective, Term{t.Coef, k})
+		}
+	}
+
+	if m.Hint != nil {
+		sub.Hint = make([]int8, len(vars))
+		for k, v := range vars {
+			sub.Hint[k] = m.Hint[v]
+			if value := r.fixed[v]; value != -1 {
+				sub.Hint[k] = value
+			}
+		}
+	}
+
+	r.Components = append(r.Components, sub)
+	r.vars = append(r.vars, vars)
+	r.owned = append(r.owned, len(free))
+}
+
+// Expand the solutions of the components, in order, to the values of the variables of the model.
+func (r *Reduction) Expand(results []*Result) []bool {
+	values := make([]bool, len(r.model.Vars))
+	for v, value := range r.fixed {
+		values[v] = value == 1
+	}
+	for c, result := range results {
+		for k, v := range r.vars[c][:r.owned[c]] {
+			values[v] = result.Values[k]
+		}
+	}
+	for v, a := range r.alias {
+		if a != -1 {
+			values[v] = values[a]
+		}
+	}
+	return values
+}
+
+// ReducingSolver reduces the model before solving it with another solver, and solves the components of
+// the reduced model in parallel. See Reduce.
+type ReducingSolver struct {
+	Solver Solver
+
+	// Maximum number of components solved at the same time.
+	Parallelism int
+}
+
+func NewReducingSolver(solver Solver) *ReducingSolver {
+	return &ReducingSolver{Solver: solver, Parallelism: runtime.NumCPU()}
+}
+
+// Name is the name of the solver of the components.
+func (rs *ReducingSolver) Name() string {
+	return rs.Solver.Name()
+}
+
+func (rs *ReducingSolver) Solve(m *Model) (*Result, error) {
+	return rs.SolveContext(context.Background(), m)
+}
+
+// SolveContext solves the components of the reduced model. If a component has no solution but the context is
+// not done, e.g. because the heuristic does not search some constraints, the whole model is solved instead.
+// A model with no solution has no unsat core, see FindCore. A solver that writes the model to a file is given
+// the whole model, since the components would all be written to the same file.
+func (rs *ReducingSolver) SolveContext(ctx context.Context, m *Model) (*Result, error) {
+	if writesFile(rs.Solver) {
+		return rs.Solver.SolveContext(ctx, m)
+	}
+
+	r := Reduce(m)
+	if r.Infeasible {
+		return &Result{Status: STATUS_UNSAT}, nil
+	}
+
+	results := make([]*Result, len(r.Components))
+	errs := make([]error, len(r.Components))
+	slots := make(chan struct{}, max(rs.Parallelism, 1))
+	var wg sync.WaitGroup
+	for c, sub := range r.Components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[c], errs[c] = rs.Solver.SolveContext(ctx, sub)
+		}()
+	}
+	wg.Wait()
+
+	result := &Result{Status: STATUS_OPTIMAL}
+	for c, sub := range results {
+		if errs[c] != nil {
+			return nil, errs[c]
+		}
+		switch {
+		case sub.Status == STATUS_UNSAT:
+			return &Result{Status: STATUS_UNSAT}, nil
+		case !sub.HasSolution():
+			result.Status = STATUS_UNKNOWN
+		case sub.Status == STATUS_SAT && result.Status == STATUS_OPTIMAL:
+			result.Status = STATUS_SAT
+		}
+
+		// The gap of the sum of the costs is at most the largest gap of the components.
+		if sub.Gap == -1 || result.Gap == -1 {
+			result.Gap = -1
+		} else {
+			result.Gap = max(result.Gap, sub.Gap)
+		}
+	}
+
+	if result.Status == STATUS_UNKNOWN {
+		if ctx.Err() != nil {
+			return &Result{Status: STATUS_UNKNOWN, Gap: -1}, nil
+		}
+		glog.Warning("Some components of the reduced model have no solution, solving the whole model")
+		return rs.Solver.SolveContext(ctx, m)
+	}
+
+	result.Values = r.Expand(results)
+	if err := m.Check(result.Values); err != nil {
+		glog.Warning("Solution of the reduced model is not feasible, solving the whole model: ", err)
+		return rs.Solver.SolveContext(ctx, m)
+	}
+	result.Cost = m.Cost(result.Values)
+	return result, nil
+}
+
+// Check if the solver, or any solver it runs, writes the model to a file.
+func writesFile(s Solver) bool {
+	switch s := s.(type) {
+	case *WriteOnlySolver:
+		return true
+	case *Z3Solver:
+		return s.File != ""
+	case *ReducingSolver:
+		return writesFile(s.Solver)
+	case *Portfolio:
+		for _, solver := range s.Solvers {
+			if writesFile(solver) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SExpr is an s-expression of SMT-LIB: an atom (a symbol, a numeral or a string literal) or a list.
+type SExpr struct {
+	Atom string
+	List []*SExpr
+
+	// Whether the expression is a list, possibly empty.
+	IsList bool
+}
+
+func (e *SExpr) String() string {
+	if !e.IsList {
+		return e.Atom
+	}
+	list := make([]string, 0, len(e.List))
+	for _, item := range e.List {
+		list = append(list, item.String())
+	}
+	return "(" + strings.Join(list, " ") + ")"
+}
+
+// Get the contents of a string literal, or the atom itself if it is not a string literal.
+func (e *SExpr) Unquote() string {
+	if len(e.Atom) < 2 || e.Atom[0] != '"' || e.Atom[len(e.Atom)-1] != '"' {
+		return e.Atom
+	}
+	return strings.ReplaceAll(e.Atom[1:len(e.Atom)-1], "\"\"", "\"")
+}
+
+// Check if the expression is a list whose first item is the given symbol, e.g. (error "...").
+func (e *SExpr) HasHead(symbol string) bool {
+	return e.IsList && len(e.List) > 0 && !e.List[0].IsList && e.List[0].Atom == symbol
+}
+
+// SyntaxError is an s-expression that cannot be parsed, at the given byte offset of the input.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// ParseSExprs parses a sequence of s-expressions, e.g. the output of z3. Comments start with ';' and end at
+// the end of the line. String literals are in double quotes, with "" for a quote, and are returned with their quotes.
+// Quoted symbols are in '|', and are returned without them.
+func ParseSExprs(input string) ([]*SExpr, error) {
+	p := &sexprParser{input: input}
+
+	var exprs []*SExpr
+	for {
+		p.skipSpace()
+		if p.pos == len(p.input) {
+			return exprs, nil
+		}
+		e, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+}
+
+type sexprParser struct {
+	input string
+	pos   int
+}
+
+func (p *sexprParser) skipSpace() {
+	for p.pos < len(p.input) {
+		switch c := p.input[p.pos]; {
+		case c == ';':
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *sexprParser) parse() (*SExpr, error) {
+	start := p.pos
+	switch p.input[p.pos] {
+	case '(':
+		p.pos++
+		e := &SExpr{IsList: true}
+		for {
+			p.skipSpace()
+			if p.pos == len(p.input) {
+				return nil, &SyntaxError{start, "unbalanced parenthesis"}
+			}
+			if p.input[p.pos] == ')' {
+				p.pos++
+				return e, nil
+			}
+			item, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			e.List = append(e.List, item)
+		}
+
+	case ')':
+		return nil, &SyntaxError{start, "unexpected closing parenthesis"}
+
+	case '"':
+		// A quote in a string literal is written "".
+		p.pos++
+		for {
+			k := strings.IndexByte(p.input[p.pos:], '"')
+			if k == -1 {
+				return nil, &SyntaxError{start, "unterminated string"}
+			}
+			p.pos += k + 1
+			if p.pos < len(p.input) && p.input[p.pos] == '"' {
+				p.pos++
+				continue
+			}
+			return &SExpr{Atom: p.input[start:p.pos]}, nil
+		}
+
+	case '|':
+		k := strings.IndexByte(p.input[p.pos+1:], '|')
+		if k == -1 {
+			return nil, &SyntaxError{start, "unterminated quoted symbol"}
+		}
+		p.pos += k + 2
+		return &SExpr{Atom: p.input[start+1 : p.pos-1]}, nil
+
+	default:
+		for p.pos < len(p.input) && !strings.ContainsRune(" \t\r\n()\";|", rune(p.input[p.pos])) {
+			p.pos++
+		}
+		return &SExpr{Atom: p.input[start:p.pos]}, nil
+	}
+}
+
+// Z3Error is an (error "...") reported by z3.
+type Z3Error struct {
+	Message string
+}
+
+func (e *Z3Error) Error() string {
+	return "z3: " + e.Message
+}
+
+// ErrNoStatus is returned when the output of z3 has no sat, unsat, unknown or timeout.
+var ErrNoStatus = errors.New("no status in z3 output")
+
+// UnknownVariableError is a value in the output of a solver for a variable that is not in the model.
+type UnknownVariableError struct {
+	Name string
+}
+
+func (e *UnknownVariableError) Error() string {
+	return fmt.Sprintf("unknown variable %s in solver output", e.Name)
+}
+
+// MissingValuesError is returned when the output of a solver has no value for some variables of the model.
+type MissingValuesError struct {
+	Missing []string
+}
+
+func (e *MissingValuesError) Error() string {
+	const shown = 5
+	if len(e.Missing) > shown {
+		return fmt.Sprintf("no value in solver output for %d variables: %s, ...", len(e.Missing), strings.Join(e.Missing[:shown], ", "))
+	}
+	return fmt.Sprintf("no value in solver output for %d variables: %s", len(e.Missing), strings.Join(e.Missing, ", "))
+}
+
+// InvalidValueError is a value in the output of a solver that is not a binary value.
+type InvalidValueError struct {
+	Name  string
+	Value string
+}
+
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("invalid value %s for variable %s in solver output", e.Value, e.Name)
+}
+
+// z3Output is the output of z3 on a script: the status of the first check-sat, the values of the variables by
+// name, from get-value or get-model, the unsat cores, and the errors.
+type z3Output struct {
+	status  Status
+	timeout bool
+	values  map[string]string
+	cores   [][]string
+	errors  []*Z3Error
+}
+
+// Parse the output of z3.
+func parseZ3(out []byte) (*z3Output, error) {
+	exprs, err := ParseSExprs(string(out))
+	if err != nil {
+		return nil, err
+	}
+
+	o := &z3Output{values: make(map[string]string)}
+	for _, e := range exprs {
+		if !e.IsList {
+			switch e.Atom {
+			case "sat", "unsat", "unknown":
+				if o.status == "" {
+					o.status = Status(e.Atom)
+				}
+			case "timeout":
+				o.timeout = true
+			default:
+				// e.g. success, or a warning.
+			}
+			continue
+		}
+
+		switch {
+		case e.HasHead("error"):
+			msg := ""
+			if len(e.List) > 1 {
+				msg = e.List[1].Unquote()
+			}
+			o.errors = append(o.errors, &Z3Error{Message: msg})
+
+		case e.HasHead("model"):
+			// (model (define-fun X_0_0 () Int 1) ...), as printed by older versions of z3.
+			o.defineFuns(e.List[1:])
+
+		case len(e.List) > 0 && e.List[0].HasHead("define-fun"):
+			// ((define-fun X_0_0 () Int 1) ...)
+			o.defineFuns(e.List)
+
+		case len(e.List) > 0 && e.List[0].IsList && len(e.List[0].List) == 2:
+			// ((X_0_0 1) (X_0_1 0)), the values of get-value.
+			for _, pair := range e.List {
+				if pair.IsList && len(pair.List) == 2 && !pair.List[0].IsList {
+					o.values[pair.List[0].Atom] = pair.List[1].String()
+				}
+			}
+
+		default:
+			// (X_0_0 E_0_1), an unsat core.
+			core := make([]string, 0, len(e.List))
+			for _, item := range e.List {
+				if !item.IsList {
+					core = append(core, item.Atom)
+				}
+			}
+			o.cores = append(o.cores, core)
+		}
+	}
+
+	// z3 reports "timeout" on its own, or "unknown" with a reason.
+	if o.status == "" && o.timeout {
+		o.status = STATUS_UNKNOWN
+	}
+	if o.status == "" {
+		if len(o.errors) > 0 {
+			return nil, o.errors[0]
+		}
+		return nil, ErrNoStatus
+	}
+
+	return o, nil
+}
+
+func (o *z3Output) defineFuns(list []*SExpr) {
+	for _, f := range list {
+		// (define-fun name () sort value)
+		if f.HasHead("define-fun") && len(f.List) == 5 && !f.List[1].IsList {
+			o.values[f.List[1].Atom] = f.List[4].String()
+		}
+	}
+}
+
+// Get the binary value of a variable: 0 or 1, or a boolean.
+func binaryValue(name string, value string) (bool, error) {
+	switch value {
+	case "1", "true":
+		return true, nil
+	case "0", "false":
+		return false, nil
+	default:
+		return false, &InvalidValueError{Name: name, Value: value}
+	}
+}
@@ -3,8 +3,8 @@ package smt
 import (
 	"errors"
 	"fmt"
-	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"xPlane"
 
@@ -24,188 +24,203 @@ func getSvcMapFromList(services []string) map[string]int {
 	return svcMap
 }
 
-func getPolicyImpls(policyContext []string, applEdges map[string][]string, svcMap map[string]int) ([]int, []int) {
+// Parse the policy context as it is enforced. A trailing wildcard stands for the callees of the previous hop,
+// e.g. `A->*` applies to the requests sent by A, so it is replaced by a single-hop wildcard.
+// Label selectors match the labels of a service as returned by labels, which may be nil if no service has labels.
+func parseEnforcedContext(policyContext []string, labels func(string) map[string]string) (*xPlane.PolicyContext, bool, error) {
+	context, err := xPlane.ParseContextTokens(policyContext)
+	if err != nil {
+		return nil, false, err
+	}
+	context.WithLabels(labels)
+
+	last := &context.Hops[len(context.Hops)-1]
+	if len(context.Hops) > 1 && last.Kind == xPlane.HOP_ANY_PATH {
+		last.Kind = xPlane.HOP_ANY
+		return context, true, nil
+	}
+	return context, false, nil
+}
+
+// Get the penultimate (sender) and last (receiver) nodes of every request path matched by the policy context.
+func getPolicyImpls(policyContext []string, applEdges map[string][]string, labels func(string) map[string]string, svcMap map[string]int) ([]int, []int) {
 	var penultimateNodes []int
 	var lastNodes []int
 
-	if policyContext[len(policyContext)-1] == ".*" {
-		// There should be a single penultimate node.
-		penultimateSvc := policyContext[len(policyContext)-2]
-		penultimateNodes = append(penultimateNodes, svcMap[penultimateSvc])
-
-		// All edges from the penultimate node are last nodes.
-		for _, svc := range applEdges[penultimateSvc] {
-			lastNodes = append(lastNodes, svcMap[svc])
+	for sender, receivers := range getEnforcementEdges(policyContext, applEdges, labels) {
+		m, ok := svcMap[sender]
+		if !ok {
+			glog.Warningf("Service %s is not in the list of services", sender)
+			continue
+		}
+		if !slices.Contains(penultimateNodes, m) {
+			penultimateNodes = append(penultimateNodes, m)
 		}
-	} else {
-		// The last node is the last element of the policy context.
-		lastSvc := policyContext[len(policyContext)-1]
-		lastNodes = append(lastNodes, svcMap[lastSvc])
-
-		// The penultimate node set will be all the nodes before the last node.
-		for svc, edges := range applEdges {
-			if slices.Contains(edges, lastSvc) {
-				penultimateNodes = append(penultimateNodes, svcMap[svc])
+
+		for _, receiver := range receivers {
+			m, ok := svcMap[receiver]
+			if !ok {
+				glog.Warningf("Service %s is not in the list of services", receiver)
+				continue
+			}
+			if !slices.Contains(lastNodes, m) {
+				lastNodes = append(lastNodes, m)
 			}
 		}
 	}
 
+	sort.Ints(penultimateNodes)
+	sort.Ints(lastNodes)
 	return penultimateNodes, lastNodes
 }
 
-// forwardPolicyContext takes a policy context and a start node,
-// and gets all paths that satisfy the policy context.
-// If fullExpand is true, then all paths are expanded to the leaf nodes (useful for conflict detection).
-// Otherwise, only the paths to the last node are returned.
-func forwardPolicyContext(policyContext []string, applEdges map[string][]string, fullExpand bool) [][]string {
-	currContextList := [][]string{{policyContext[0]}}
-	prevNode := policyContext[0]
-
-	for i := 1; i < len(policyContext); i++ {
-		if policyContext[i] != "*" {
-			// Add prevNode to every context in currContextList.
-			prevNode = policyContext[i]
-			for j := 0; j < len(currContextList); j++ {
-				currContextList[j] = append(currContextList[j], prevNode)
-			}
-		} else {
-			targetNode := ""
-			if i < len(policyContext)-1 {
-				targetNode = policyContext[i+1]
-			}
-
-			if targetNode != "" {
-				bftQueue := [][]string{}
-				for _, n := range applEdges[prevNode] {
-					bftQueue = append(bftQueue, []string{n})
-				}
+// Get the last edge of every request path matched by the policy context, as a map from the sender to its receivers.
+func getEnforcementEdges(policyContext []string, applEdges map[string][]string, labels func(string) map[string]string) map[string][]string {
+	context, _, err := parseEnforcedContext(policyContext, labels)
+	if err != nil {
+		glog.Errorf("Error parsing context %v: %v", policyContext, err)
+		return map[string][]string{}
+	}
 
-				// Keep track of paths from previous node to target node.
-				newContextList := [][]string{}
-				for len(bftQueue) > 0 {
-					currPath := bftQueue[0]
-					currNode := currPath[len(currPath)-1]
-
-					// Unroll BFS until target node is found or a leaf is met.
-					if currNode == targetNode {
-						for _, context := range currContextList {
-							contextCopy := append([]string{}, context...)
-							newContextList = append(newContextList, append(contextCopy, currPath[:len(currPath)-1]...))
-						}
-					} else if children, ok := applEdges[currNode]; ok {
-						for _, n := range children {
-							currPathCopy := append([]string{}, currPath...)
-							bftQueue = append(bftQueue, append(currPathCopy, n))
-						}
-					}
-					bftQueue = bftQueue[1:]
-				}
+	return context.EnforcementEdges(applEdges)
+}
 
-				currContextList = newContextList
-			} else {
-				if fullExpand {
-					bftQueue := [][]string{}
-					for _, n := range applEdges[prevNode] {
-						bftQueue = append(bftQueue, []string{n})
-					}
+// GetEnforcementNodes returns the services that can enforce a policy with the given context:
+// the senders (penultimate nodes) and the receivers (last nodes) of the request. Label selectors match the labels
+// returned by labels, e.g. ApplicationGraph.GetLabels, which may be nil.
+func GetEnforcementNodes(policyContext []string, applEdges map[string][]string, services []string, labels func(string) map[string]string) ([]string, []string) {
+	penultimateNodes, lastNodes := getPolicyImpls(policyContext, applEdges, labels, getSvcMapFromList(services))
 
-					// Keep track of paths from previous node to target node.
-					newContextList := [][]string{}
-					for len(bftQueue) > 0 {
-						currPath := bftQueue[0]
-						currNode := currPath[len(currPath)-1]
-
-						// Unroll BFS until a leaf is met.
-						if children, ok := applEdges[currNode]; ok {
-							for _, n := range children {
-								currPathCopy := append([]string{}, currPath...)
-								bftQueue = append(bftQueue, append(currPathCopy, n))
-							}
-						} else {
-							// Leaf node found, add to context list.
-							for _, context := range currContextList {
-								contextCopy := append([]string{}, context...)
-								newContextList = append(newContextList, append(contextCopy, currPath...))
-							}
-						}
-						bftQueue = bftQueue[1:]
-					}
+	senders := make([]string, 0, len(penultimateNodes))
+	for _, m := range penultimateNodes {
+		senders = append(senders, services[m])
+	}
 
-					currContextList = newContextList
-				} else {
-					// Add all children of prevNode to every context in currContextList.
-					newContextList := [][]string{}
-					for _, context := range currContextList {
-						for _, n := range applEdges[prevNode] {
-							contextCopy := append([]string{}, context...)
-							newContextList = append(newContextList, append(contextCopy, n))
-						}
-					}
-					currContextList = newContextList
-				}
-			}
-		}
+	receivers := make([]string, 0, len(lastNodes))
+	for _, m := range lastNodes {
+		receivers = append(receivers, services[m])
 	}
 
-	return currContextList
+	return senders, receivers
 }
 
-// backwardPolicyContext takes a policy context and gets all paths
-// that satisfy the policy context and end on the given policy_context.
-func backwardPolicyContext(targetNode string, applEdges map[string][]string) [][]string {
-	parents := make(map[string][]string)
-	for n, e := range applEdges {
-		for _, c := range e {
-			parents[c] = append(parents[c], n)
-		}
+// ExpandOptions bounds the expansion of policy contexts, so that it terminates on cyclic application graphs.
+// Zero fields take the value of DefaultExpandOptions.
+type ExpandOptions struct {
+	// Maximum number of services on an expanded path. A path that is still matched or extended at this length
+	// is cut, which is an error.
+	MaxPathLength int
+
+	// Maximum number of paths explored, including partial paths. Exceeding it is an error.
+	MaxPaths int
+
+	// Labels of a service, matched by the label selectors of contexts, e.g. ApplicationGraph.GetLabels.
+	// If nil, no service has labels.
+	Labels func(service string) map[string]string
+}
+
+var DefaultExpandOptions = ExpandOptions{MaxPathLength: 16, MaxPaths: 1000000}
+
+var ErrPathBudgetExceeded = errors.New("path budget exceeded")
+
+// ExpandPolicyContext expands the policy context to get all request paths of the application graph it matches.
+// Paths can start at any service, and may visit a service more than once if the graph has cycles.
+// If fullExpand is true, then the paths of a context ending with a wildcard are expanded to the leaf nodes
+// (useful for conflict detection). Otherwise, only the paths to the last node are returned.
+// An error wrapping ErrPathBudgetExceeded is returned if the paths exceed the limits of opts, e.g. if a
+// wildcard follows a cycle of the graph.
+func ExpandPolicyContext(policyContext []string, applEdges map[string][]string, fullExpand bool, opts ExpandOptions) ([][]string, error) {
+	if opts.MaxPathLength == 0 {
+		opts.MaxPathLength = DefaultExpandOptions.MaxPathLength
+	}
+	if opts.MaxPaths == 0 {
+		opts.MaxPaths = DefaultExpandOptions.MaxPaths
 	}
 
-	backwardBFTQueue := [][]string{{targetNode}}
+	context, trailingWildcard, err := parseEnforcedContext(policyContext, opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// With fullExpand, the paths of a context ending with a wildcard are expanded to the leaf nodes.
+	extend := fullExpand && trailingWildcard
+
+	// Every service of the graph can start a request, unless the first hop names the services.
+	services := context.StartServices(applEdges)
+
 	contextList := [][]string{}
-	for len(backwardBFTQueue) > 0 {
-		currPath := backwardBFTQueue[0]
-		currNode := currPath[0]
-		if len(currPath) > 1 {
-			contextList = append(contextList, currPath)
+	explored := 0
+
+	// Depth first search of the paths, pruned as soon as the path cannot be matched anymore.
+	// Once a path is matched and should be extended, it is extended to the leaves regardless of the context.
+	var expand func(path []string, matched *xPlane.ContextState, accepted bool) error
+	expand = func(path []string, matched *xPlane.ContextState, accepted bool) error {
+		explored++
+		if explored > opts.MaxPaths {
+			return fmt.Errorf("expanding context %v: %w (%d paths)", policyContext, ErrPathBudgetExceeded, opts.MaxPaths)
 		}
 
-		if children, ok := parents[currNode]; ok {
-			for _, n := range children {
-				backwardBFTQueue = append(backwardBFTQueue, append([]string{n}, currPath...))
+		if !accepted && len(path) > 1 && matched.Accepts() {
+			if !extend {
+				contextList = append(contextList, append([]string{}, path...))
+			} else {
+				accepted = true
 			}
 		}
-		backwardBFTQueue = backwardBFTQueue[1:]
+
+		children := applEdges[path[len(path)-1]]
+		if len(children) == 0 {
+			if accepted {
+				contextList = append(contextList, append([]string{}, path...))
+			}
+			return nil
+		}
+		if len(path) >= opts.MaxPathLength {
+			// The path is complete only if the context cannot match any longer path.
+			for _, child := range children {
+				if accepted || matched.Step(child).Live() {
+					return fmt.Errorf("expanding context %v: %w (path %v cut at %d services)", policyContext, ErrPathBudgetExceeded, path, opts.MaxPathLength)
+				}
+			}
+			return nil
+		}
+
+		for _, child := range children {
+			next := matched
+			if !accepted {
+				if next = matched.Step(child); !next.Live() {
+					continue
+				}
+			}
+			if err := expand(append(path, child), next, accepted); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
-	return contextList
+	for _, svc := range services {
+		if matched := context.Start().Step(svc); matched.Live() {
+			if err := expand([]string{svc}, matched, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return contextList, nil
 }
 
 // ExpandPolicyContextDeprecated expands the policy context to get all possible request contexts.
 //
 // Deprecated: Do not use this function. The expansion of policy contexts is not needed anymore.
 func ExpandPolicyContextDeprecated(policyContext []string, applEdges map[string][]string, fullExpand bool) [][]string {
-	if policyContext[0] != "*" {
-		contextList := forwardPolicyContext(policyContext, applEdges, fullExpand)
-		// glog.Info("Expanded policy context: ", policyContext, " to ", contextList)
-		return contextList
-	} else {
-		preContextList := backwardPolicyContext(policyContext[1], applEdges)
-		postContextList := forwardPolicyContext(policyContext[1:], applEdges, fullExpand)
-		contextList := [][]string{}
-		for _, preContext := range preContextList {
-			if len(postContextList) > 0 {
-				for _, postContext := range postContextList {
-					preContextCopy := append([]string{}, preContext...)
-					contextList = append(contextList, append(preContextCopy, postContext[1:]...))
-				}
-			} else {
-				contextList = append(contextList, preContext)
-			}
-		}
-
-		// glog.Info("Expanded policy context: ", policyContext, " to ", contextList)
-		return contextList
+	contextList, err := ExpandPolicyContext(policyContext, applEdges, fullExpand, DefaultExpandOptions)
+	if err != nil {
+		glog.Error("Error expanding policy context: ", err)
+		return [][]string{}
 	}
+
+	return contextList
 }
 
 // OptimizeForTarget takes a list of policies, the application graph, a list of all services,
@@ -341,7 +356,7 @@ func OptimizeForTargetDeprecated(policies []xPlane.Policy, applEdges map[string]
 
 	// Constraint 4 : Some policies can be implemented only at sender or receiver.
 	for j := 0; j < numPolicies; j++ {
-		penultimateNodes, lastNodes := getPolicyImpls(policies[j].GetContext(), applEdges, svcMap)
+		penultimateNodes, lastNodes := getPolicyImpls(policies[j].GetContext(), applEdges, nil, svcMap)
 		// glog.Info("For policy context ", policies[j].GetContext(), " got penultimate nodes: ", penultimateNodes, " and last nodes: ", lastNodes)
 
 		// Either all penultimate nodes implement the policy or all last nodes implement the policy.
@@ -473,201 +488,39 @@ func OptimizeForTargetDeprecated(policies []xPlane.Policy, applEdges map[string]
 // list declaring whether a service already has a sidecar and a list denoting the cost of adding a sidecar.
 //
 // It generates the z3 constraints and the objective function, which can then be used by a z3 solver.
-func GenerateOptimizationFile(policies []xPlane.Policy, applEdges map[string][]string, services []string, sidecarAssignment map[string]int, sidecarCost []int) error {
-	// Service map is needed to map service names to their index in the z3 variables.
-	svcMap := getSvcMapFromList(services)
-
-	// Useful variables.
-	numPolicies := len(policies)
-	numServices := len(svcMap)
-	numDataplanes := len(sidecarCost)
-
+// The constraints are written to the given file, as SMT-LIB or in the format of its extension (see WriteOnlySolver),
+// so concurrent runs must write different files.
+func GenerateOptimizationFile(filename string, policies []xPlane.Policy, applEdges map[string][]string, services []string, sidecarAssignment map[string]int, sidecarCost []int) error {
 	// If services more than 500, then z3 will not be able to handle it.
-	if numServices > 500 {
+	if len(services) > 500 {
 		return errors.New("more than 500 services not supported")
 	}
 
-	// File to write the variables and constraints to.
-	f, err := os.Create("z3_constraints.smt")
+	m, err := BuildModel(policies, applEdges, services, sidecarAssignment, sidecarCost)
 	if err != nil {
-		glog.Fatal(err)
-	}
-
-	// Define z3 variables.
-	glog.Info("Defining variables")
-
-	// Define the "Exists" variables.
-	X := make([][]string, numServices)
-	for i := 0; i < numDataplanes; i++ {
-		X[i] = make([]string, numServices)
-		for m := 0; m < numServices; m++ {
-			X[i][m] = fmt.Sprintf("X_%d_%d", i, m)
-			f.Write([]byte(fmt.Sprintf("(declare-const X_%d_%d Int)\n", i, m)))
-			f.Write([]byte(fmt.Sprintf("(assert (or (= X_%d_%d 0) (= X_%d_%d 1)))\n", i, m, i, m)))
-		}
+		return err
 	}
 
-	// Define the "Executes" variables.
-	E := make([][]string, numPolicies)
-	for j := 0; j < numPolicies; j++ {
-		E[j] = make([]string, numServices)
-		for m := 0; m < numServices; m++ {
-			E[j][m] = fmt.Sprintf("E_%d_%d", j, m)
-			f.Write([]byte(fmt.Sprintf("(declare-const E_%d_%d Int)\n", j, m)))
-			f.Write([]byte(fmt.Sprintf("(assert (or (= E_%d_%d 0) (= E_%d_%d 1)))\n", j, m, j, m)))
-		}
-	}
-
-	// Define the "Supports" variables.
-	S := make([][]string, numDataplanes)
-	for i := 0; i < numDataplanes; i++ {
-		S[i] = make([]string, numPolicies)
-		for j := 0; j < numPolicies; j++ {
-			S[i][j] = fmt.Sprintf("S_%d_%d", i, j)
-			f.Write([]byte(fmt.Sprintf("(declare-const S_%d_%d Int)\n", i, j)))
-			f.Write([]byte(fmt.Sprintf("(assert (or (= S_%d_%d 0) (= S_%d_%d 1)))\n", i, j, i, j)))
-		}
-	}
-
-	// Add the constraints.
-	glog.Info("Defining constraints")
-
-	// Constraint 1 : Policies must be implemented either by all penultimate nodes or all last nodes.
-	// Constraint 2 : Policies must be implemented as per their annotation constraints.
-	for j := 0; j < numPolicies; j++ {
-		penultimateNodes, lastNodes := getPolicyImpls(policies[j].GetContext(), applEdges, svcMap)
-		// glog.Info("For policy context ", policies[j].GetContext(), " got penultimate nodes: ", penultimateNodes, " and last nodes: ", lastNodes)
-
-		// Either all penultimate nodes implement the policy or all last nodes implement the policy.
-		penultimateImplements := ""
-		lastImplements := ""
-
-		penultimateList := make([]string, 0)
-		lastList := make([]string, 0)
-
-		if policies[j].GetConstraint() != xPlane.RECEIVER {
-			for _, m := range penultimateNodes {
-				penultimateList = append(penultimateList, fmt.Sprintf("(= 1 %s)", E[j][m]))
-			}
-			penultimateImplements = fmt.Sprintf("(and %s)", strings.Join(penultimateList, " "))
-		}
-
-		if policies[j].GetConstraint() != xPlane.SENDER {
-			for _, m := range lastNodes {
-				lastList = append(lastList, fmt.Sprintf("(= 1 %s)", E[j][m]))
-			}
-			lastImplements = fmt.Sprintf("(and %s)", strings.Join(lastList, " "))
-		}
-
-		// Write the constraint to the file.
-		if len(penultimateNodes) == 0 {
-			f.Write([]byte(fmt.Sprintf("(assert %s)\n", lastImplements)))
-		} else if len(lastImplements) == 0 {
-			f.Write([]byte(fmt.Sprintf("(assert %s)\n", penultimateImplements)))
-		} else {
-			f.Write([]byte(fmt.Sprintf("(assert (xor %s %s))\n", penultimateImplements, lastImplements)))
-		}
-
-		// All other nodes do not implement the policy.
-		for m := 0; m < numServices; m++ {
-			if policies[j].GetConstraint() == xPlane.SENDER {
-				// Sender policy => any node not in penultimate set should not implement the policy.
-				if !slices.Contains(penultimateNodes, m) {
-					f.Write([]byte(fmt.Sprintf("(assert (= 0 %s))\n", E[j][m])))
-				}
-			} else if policies[j].GetConstraint() == xPlane.RECEIVER {
-				// Receiver policy => any node not in lastNodes set should not implement the policy.
-				if !slices.Contains(lastNodes, m) {
-					f.Write([]byte(fmt.Sprintf("(assert (= 0 %s))\n", E[j][m])))
-				}
-			} else {
-				// Except for penultimate and last nodes, no other node should implement the policy.
-				if !slices.Contains(penultimateNodes, m) && !slices.Contains(lastNodes, m) {
-					f.Write([]byte(fmt.Sprintf("(assert (= 0 %s))\n", E[j][m])))
-				}
-			}
-		}
-	}
-
-	// Constraint 3 : For any service m, at most one i can be such that X[i][m] = 1.
-	for m := 0; m < numServices; m++ {
-		xList := make([]string, 0)
-		for i := 0; i < numDataplanes; i++ {
-			xList = append(xList, X[i][m])
-		}
-		f.Write([]byte(fmt.Sprintf("(assert (<= (+ %s) 1))\n", strings.Join(xList, " "))))
-	}
-
-	// Constraint 4 : If E[j][m] = 1, then X[i][m] = 1 and S[i][j] = 1 for some i.
-	for j := 0; j < numPolicies; j++ {
-		for m := 0; m < numServices; m++ {
-			eVal := fmt.Sprintf("(= 1 %s)", E[j][m])
-			xsList := make([]string, 0)
-			for i := 0; i < numDataplanes; i++ {
-				xsList = append(xsList, fmt.Sprintf("(and (= 1 %s) (= 1 %s))", X[i][m], S[i][j]))
-			}
-			f.Write([]byte(fmt.Sprintf("(assert (=> %s (or %s)))\n", eVal, strings.Join(xsList, " "))))
-		}
-	}
-
-	// Constraint 5 : If policy j is supported by dataplane i, then S[i][j] = 1.
-	for i := 0; i < numDataplanes; i++ {
-		for j := 0; j < numPolicies; j++ {
-			supportedDataplanes := policies[j].GetDataplanes()
-			if slices.Contains(supportedDataplanes, i) {
-				f.Write([]byte(fmt.Sprintf("(assert (= 1 %s))\n", S[i][j])))
-			} else {
-				f.Write([]byte(fmt.Sprintf("(assert (= 0 %s))\n", S[i][j])))
-			}
-		}
-	}
-
-	// Constraint 6 : If dataplane i is already assigned to a service m, then X[i][m] = 1.
-	for m := 0; m < numServices; m++ {
-		if i, ok := sidecarAssignment[services[m]]; ok {
-			f.Write([]byte(fmt.Sprintf("(assert (= 1 %s))\n", X[i][m])))
-		}
-	}
-
-	// Add the objective function.
-	cost := make([]string, 0)
-	for i := 0; i < numDataplanes; i++ {
-		for m := 0; m < numServices; m++ {
-			cost = append(cost, fmt.Sprintf("(* %d %s)", sidecarCost[i], X[i][m]))
-		}
-	}
-	totalCost := fmt.Sprintf("(+ %s)", strings.Join(cost, " "))
-	f.Write([]byte(fmt.Sprintf("(minimize %s)\n", totalCost)))
-
-	// Add instructions for the z3 solver.
-	f.Write([]byte("(check-sat)\n"))
-
-	// Get the values of the X variables.
-	for i := 0; i < numDataplanes; i++ {
-		for m := 0; m < numServices; m++ {
-			xVal := fmt.Sprintf("(= 1 %s)", X[i][m])
-			f.Write([]byte(fmt.Sprintf("(get-value (%s))\n", xVal)))
-		}
-	}
+	return writeModelFile(m, filename)
+}
 
-	// Get the values of the E variables.
-	for j := 0; j < numPolicies; j++ {
-		for m := 0; m < numServices; m++ {
-			eVal := fmt.Sprintf("(= 1 %s)", E[j][m])
-			f.Write([]byte(fmt.Sprintf("(get-value (%s))\n", eVal)))
-		}
+// GenerateOptimizationFileForGraph generates the z3 constraints for an application graph. See GenerateOptimizationFile.
+// A policy can only be enforced by a dataplane that supports the protocol of the edges it is enforced on.
+func GenerateOptimizationFileForGraph(filename string, policies []xPlane.Policy, g *xPlane.ApplicationGraph, sidecarAssignment map[string]int, dataplanes []xPlane.Dataplane) error {
+	m, err := BuildModelForGraph(policies, g, sidecarAssignment, dataplanes)
+	if err != nil {
+		return err
 	}
 
-	// Close the file.
-	f.Close()
-
-	return nil
+	return writeModelFile(m, filename)
 }
 
-// Runs the z3 solver on the generated file and returns the output.
-func RunSolver(services []string, numSidecars int, numPolicies int) (bool, map[string]int, [][]string) {
+// Runs the z3 solver on the file generated by GenerateOptimizationFile and returns the output.
+//
+// Deprecated: Do not use this function. Use BuildModel and a Solver instead.
+func RunSolver(filename string, services []string, numSidecars int, numPolicies int) (bool, map[string]int, [][]string) {
 	// Use the z3 command line tool to run the solver.
-	cmd := exec.Command("z3", "z3_constraints.smt", "-T:60")
+	cmd := exec.Command("z3", filename, "-T:60")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		glog.Error("Error running z3 solver: ", err)
@@ -675,55 +528,41 @@ func RunSolver(services []string, numSidecars int, numPolicies int) (bool, map[s
 	}
 
 	// Parse the output of the solver.
-	solverOutput := string(out)
-
-	// Split the output of the solver by new line.
-	solverOutputLines := strings.Split(solverOutput, "\n")
-
-	// 1st line is sat/unsat.
-	if solverOutputLines[0] == "unsat" {
+	o, err := parseZ3(out)
+	if err != nil {
+		glog.Error("Error parsing z3 output: ", err)
 		return false, nil, nil
 	}
-
-	// Parse solverOutput to get the values of X and I variables.
-	impls := make([][]string, numPolicies)
-	X := make([]int, len(services))
-
-	// Initialize X to -1.
-	for i := 0; i < len(services); i++ {
-		X[i] = -1
-	}
-
-	// Get the values of X variables.
-	for m := 0; m < len(services); m++ {
-		for i := 0; i < numSidecars; i++ {
-			// Next line is of the form (X_i_m value).
-			// Split the line to get i, m and value and save X[m] = i.
-			line := strings.Split(solverOutputLines[1+i*len(services)+m], " ")
-			xVal := line[len(line)-1][:len(line[len(line)-1])-2]
-			if xVal == "true" {
-				X[m] = i
-			}
-		}
+	if o.status != STATUS_SAT {
+		return false, nil, nil
 	}
 
-	// Create a map from service name to sidecar index.
+	// Get the values of the X and E variables by name.
 	sidecars := make(map[string]int)
-	for m := 0; m < len(services); m++ {
-		sidecars[services[m]] = X[m]
+	for _, svc := range services {
+		sidecars[svc] = -1
 	}
+	impls := make([][]string, numPolicies)
 
-	// Get the values of E variables.
-	for m := 0; m < len(services); m++ {
-		for j := 0; j < numPolicies; j++ {
-			// The value of E[j][m] is in the form (E_j_m value).
-			// Split the line by space and get the value.
-			line := strings.Split(solverOutputLines[1+len(services)*numSidecars+j*len(services)+m], " ")
-			eVal := line[len(line)-1][:len(line[len(line)-1])-2]
-			if eVal == "true" {
-				impls[j] = append(impls[j], services[m])
-			}
+	for name, value := range o.values {
+		if v, err := binaryValue(name, value); err != nil || !v {
+			continue
 		}
+
+		var i, m int
+		if _, err := fmt.Sscanf(name, "X_%d_%d", &i, &m); err == nil && i < numSidecars && m < len(services) {
+			sidecars[services[m]] = i
+		} else if _, err := fmt.Sscanf(name, "E_%d_%d", &i, &m); err == nil && i < numPolicies && m < len(services) {
+			impls[i] = append(impls[i], services[m])
+		}
+	}
+
+	// The values are by name, keep the services in order.
+	svcMap := getSvcMapFromList(services)
+	for j := range impls {
+		sort.Slice(impls[j], func(a, b int) bool {
+			return svcMap[impls[j][a]] < svcMap[impls[j][b]]
+		})
 	}
 
 	return true, sidecars, impls
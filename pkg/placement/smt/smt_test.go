@@ -1,8 +1,21 @@
 package smt
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 	"xPlane"
 
 	"github.com/golang/glog"
@@ -51,10 +64,9 @@ func TestBasic(t *testing.T) {
 	glog.Infof("Placements: %v", placements)
 }
 
-func TestGenerate(t *testing.T) {
-	flag.Parse()
-
-	// services := []string{"A", "B", "C"}
+// Get the graph, the policies and the dataplane costs of TestGenerate, whose cheapest placement costs 3:
+// setHeader is free at A, count needs dataplane 1 at E or F, and setDeadline needs dataplane 2 at E.
+func createGenerateGraph() ([]xPlane.Policy, map[string][]string, []string, []int) {
 	services := []string{"A", "B", "C", "D", "E", "F", "G"}
 
 	// Define application graph.
@@ -80,9 +92,1767 @@ func TestGenerate(t *testing.T) {
 		xPlane.CreatePolicy([]string{"A", "*", "E", "*"}, []xPlane.PolicyFunction{setDeadlineFunc}),
 	}
 
+	return policies, applEdges, services, sidecarCosts
+}
+
+func TestGenerate(t *testing.T) {
+	flag.Parse()
+
+	policies, applEdges, services, sidecarCosts := createGenerateGraph()
+
 	// Make an empty map. Initial placement.
 	sidecarAssignments := make(map[string]int)
 
 	// Call the file generation function.
-	GenerateOptimizationFile(policies, applEdges, services, sidecarAssignments, sidecarCosts)
+	filename := path.Join(t.TempDir(), "z3_constraints.smt")
+	if err := GenerateOptimizationFile(filename, policies, applEdges, services, sidecarAssignments, sidecarCosts); err != nil {
+		t.Fatalf("Error generating the optimization file: %v", err)
+	}
+	if data, err := os.ReadFile(filename); err != nil || !strings.Contains(string(data), "(minimize") {
+		t.Errorf("Expected the model in %s, got %v", filename, err)
+	}
+}
+
+func createHotelReservationGraph() (map[string][]string, []string) {
+	applEdges := make(map[string][]string)
+	applEdges["frontend"] = []string{"recommend", "user", "profile", "search", "reserve"}
+	applEdges["search"] = []string{"geo", "rate"}
+	applEdges["recommend"] = []string{"recommend-mongo"}
+	applEdges["reserve"] = []string{"reserve-mongo", "reserve-memc"}
+	applEdges["user"] = []string{"user-mongo"}
+	applEdges["rate"] = []string{"rate-mongo", "rate-memc"}
+	applEdges["geo"] = []string{"geo-mongo"}
+	applEdges["profile"] = []string{"profile-mongo", "profile-memc"}
+
+	services := []string{"frontend", "recommend", "user", "profile", "search", "reserve", "geo", "rate",
+		"recommend-mongo", "reserve-mongo", "reserve-memc", "user-mongo", "rate-mongo", "rate-memc", "geo-mongo",
+		"profile-mongo", "profile-memc"}
+
+	return applEdges, services
+}
+
+func TestServiceSets(t *testing.T) {
+	flag.Parse()
+
+	applEdges, services := createHotelReservationGraph()
+
+	tests := []struct {
+		context   []string
+		senders   []string
+		receivers []string
+	}{
+		{[]string{"frontend", "[search,reserve]"}, []string{"frontend"}, []string{"search", "reserve"}},
+		{[]string{"[search,reserve]", "*"}, []string{"search", "reserve"}, []string{"geo", "rate", "reserve-mongo", "reserve-memc"}},
+		{[]string{"frontend", "*", "[rate,geo]"}, []string{"search"}, []string{"geo", "rate"}},
+		{[]string{"frontend", "[search,profile]", "*"}, []string{"profile", "search"}, []string{"geo", "rate", "profile-mongo", "profile-memc"}},
+		{[]string{"*", "[geo,rate]", "[geo-mongo,rate-memc]"}, []string{"geo", "rate"}, []string{"rate-memc", "geo-mongo"}},
+	}
+
+	for _, test := range tests {
+		senders, receivers := GetEnforcementNodes(test.context, applEdges, services, nil)
+		if !sameServices(senders, test.senders) {
+			t.Errorf("Expected senders %v for %v, got %v", test.senders, test.context, senders)
+		}
+		if !sameServices(receivers, test.receivers) {
+			t.Errorf("Expected receivers %v for %v, got %v", test.receivers, test.context, receivers)
+		}
+	}
+
+	// Every service of a set is expanded.
+	contexts := ExpandPolicyContextDeprecated([]string{"frontend", "[search,reserve]"}, applEdges, false)
+	if !reflect.DeepEqual(contexts, [][]string{{"frontend", "search"}, {"frontend", "reserve"}}) {
+		t.Errorf("Expected a context for every service of the set, got %v", contexts)
+	}
+
+	// The formulation uses the index of every service of the set.
+	getHeaderFunc := xPlane.CreateNewPolicyFunction("getHeader", xPlane.RECEIVER, []int{0}, false)
+	policies := []xPlane.Policy{
+		xPlane.CreatePolicy([]string{"search", "[geo,rate]"}, []xPlane.PolicyFunction{getHeaderFunc}),
+	}
+	m, err := BuildModel(policies, applEdges, services, map[string]int{}, []int{1})
+	if err != nil {
+		t.Fatalf("Error building model: %v", err)
+	}
+	result, err := NewGoSolver().Solve(m)
+	if err != nil || !result.HasSolution() {
+		t.Fatalf("Expected a solution, got %v %v", result, err)
+	}
+	if _, impls := m.Decode(result.Values); !reflect.DeepEqual(impls[0], []string{"geo", "rate"}) {
+		t.Errorf("Expected geo and rate to enforce the policy, got %v", impls[0])
+	}
+
+	// Invalid contexts are rejected.
+	policies = []xPlane.Policy{xPlane.CreatePolicy([]string{"search", "[geo,"}, []xPlane.PolicyFunction{getHeaderFunc})}
+	if err := GenerateOptimizationFile(path.Join(t.TempDir(), "z3_constraints.smt"), policies, applEdges, services, map[string]int{}, []int{1}); err == nil {
+		t.Errorf("Expected an error for an invalid context")
+	}
+}
+
+func TestUnresolvedPolicy(t *testing.T) {
+	flag.Parse()
+
+	applEdges, services := createHotelReservationGraph()
+	getHeaderFunc := xPlane.CreateNewPolicyFunction("getHeader", xPlane.SENDER_RECEIVER, []int{0}, false)
+
+	tests := []struct {
+		context  []string
+		endpoint string
+	}{
+		{[]string{"frotnend", "*"}, "frotnend"},
+		{[]string{"*", "search", "[goe,rtae]"}, "goe"},
+		{[]string{"{app=fe}", "search"}, "{app=fe}"},
+		{[]string{"geo", "frontend"}, ""},
+	}
+
+	for _, test := range tests {
+		policies := []xPlane.Policy{
+			xPlane.CreatePolicy([]string{"frontend", "search"}, []xPlane.PolicyFunction{getHeaderFunc}),
+			xPlane.CreatePolicy(test.context, []xPlane.PolicyFunction{getHeaderFunc}),
+		}
+		_, err := BuildModel(policies, applEdges, services, map[string]int{}, []int{1})
+		var unresolved *UnresolvedPolicyError
+		if !errors.As(err, &unresolved) {
+			t.Errorf("Expected an unresolved policy error for %v, got %v", test.context, err)
+			continue
+		}
+		if unresolved.Policy != 1 || unresolved.Endpoint != test.endpoint {
+			t.Errorf("Expected policy 1 and endpoint %q for %v, got %d and %q", test.endpoint, test.context, unresolved.Policy, unresolved.Endpoint)
+		}
+	}
+}
+
+func sameServices(a []string, b []string) bool {
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+func TestExpandCyclicGraph(t *testing.T) {
+	flag.Parse()
+
+	// Social network graph, with a back edge from social-graph to home-timeline.
+	applEdges := make(map[string][]string)
+	applEdges["nginx"] = []string{"social-graph", "user", "compose-post", "user-timeline", "home-timeline"}
+	applEdges["social-graph"] = []string{"user", "graph-mongo", "home-timeline"}
+	applEdges["user"] = []string{"user-memcached", "user-mongo"}
+	applEdges["compose-post"] = []string{"home-timeline", "user-timeline", "user"}
+	applEdges["home-timeline"] = []string{"post-storage", "social-graph"}
+	applEdges["post-storage"] = []string{"post-storage-mongo"}
+
+	opts := ExpandOptions{MaxPathLength: 6, MaxPaths: 10000}
+
+	// Contexts without wildcards have finitely many paths, even through the cycle.
+	contexts, err := ExpandPolicyContext([]string{"social-graph", "home-timeline", "social-graph"}, applEdges, true, opts)
+	if err != nil {
+		t.Fatalf("Error expanding context: %v", err)
+	}
+	expected := [][]string{{"social-graph", "home-timeline", "social-graph"}}
+	if !reflect.DeepEqual(contexts, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, contexts)
+	}
+
+	// A wildcard that follows the cycle is cut at the maximum path length, which is an error instead of
+	// missing the longer paths.
+	for _, context := range [][]string{{"nginx", "*", "user"}, {"home-timeline", "*"}} {
+		_, err = ExpandPolicyContext(context, applEdges, true, opts)
+		if !errors.Is(err, ErrPathBudgetExceeded) {
+			t.Errorf("Expected the path length to be exceeded for %v, got %v", context, err)
+		}
+	}
+
+	// A wildcard whose paths leave the cycle before the maximum path length is expanded to the leaves.
+	contexts, err = ExpandPolicyContext([]string{"post-storage", "*"}, applEdges, true, opts)
+	if err != nil {
+		t.Fatalf("Error expanding context: %v", err)
+	}
+	expected = [][]string{{"post-storage", "post-storage-mongo"}}
+	if !reflect.DeepEqual(contexts, expected) {
+		t.Errorf("Expected paths %v, got %v", expected, contexts)
+	}
+
+	// Exceeding the budget is an error.
+	_, err = ExpandPolicyContext([]string{"*", "user"}, applEdges, true, ExpandOptions{MaxPathLength: 50, MaxPaths: 100})
+	if !errors.Is(err, ErrPathBudgetExceeded) {
+		t.Errorf("Expected the path budget to be exceeded, got %v", err)
+	}
+
+	// Zero options are the default ones, which bound the cycle.
+	contexts, err = ExpandPolicyContext([]string{"nginx", "user"}, applEdges, true, ExpandOptions{})
+	if err != nil {
+		t.Fatalf("Error expanding context with zero options: %v", err)
+	}
+	if len(contexts) != 1 {
+		t.Errorf("Expected a path from nginx to user with zero options, got %v", contexts)
+	}
+	_, err = ExpandPolicyContext([]string{"home-timeline", "*"}, applEdges, true, ExpandOptions{})
+	if !errors.Is(err, ErrPathBudgetExceeded) || !strings.Contains(err.Error(), fmt.Sprintf("cut at %d services", DefaultExpandOptions.MaxPathLength)) {
+		t.Errorf("Expected the path to be cut at %d services, got %v", DefaultExpandOptions.MaxPathLength, err)
+	}
+}
+
+func TestProtocolCompatibility(t *testing.T) {
+	flag.Parse()
+
+	g := xPlane.NewApplicationGraph()
+	for _, svc := range []string{"frontend", "search", "geo"} {
+		g.AddService(svc, xPlane.ServiceAttributes{})
+	}
+	g.AddEdge("frontend", "search", xPlane.EdgeAttributes{Protocol: xPlane.PROTOCOL_GRPC})
+	g.AddEdge("search", "geo", xPlane.EdgeAttributes{Protocol: xPlane.PROTOCOL_THRIFT})
+
+	// A cheap gRPC-only dataplane, and an expensive one that parses any protocol.
+	r := xPlane.NewDataplaneRegistry()
+	r.Register("bpf-grpc", 1, nil)
+	r.Register("envoy", 10, nil)
+	r.SetProtocols("bpf-grpc", []xPlane.Protocol{xPlane.PROTOCOL_GRPC})
+
+	setHeaderFunc := xPlane.CreateNewPolicyFunction("setHeader", xPlane.SENDER_RECEIVER, []int{0, 1}, false)
+	policies := []xPlane.Policy{
+		xPlane.CreatePolicy([]string{"frontend", "search"}, []xPlane.PolicyFunction{setHeaderFunc}),
+		xPlane.CreatePolicy([]string{"search", "geo"}, []xPlane.PolicyFunction{setHeaderFunc}),
+	}
+
+	m, err := BuildModelForGraph(policies, g, map[string]int{}, r.GetDataplanes())
+	if err != nil {
+		t.Fatalf("Error building model: %v", err)
+	}
+	result, err := NewGoSolver().Solve(m)
+	if err != nil || result.Status != STATUS_OPTIMAL {
+		t.Fatalf("Expected an optimal solution, got %v %v", result, err)
+	}
+
+	// The Thrift edge can only be enforced by envoy, which also enforces the gRPC edge at search.
+	sidecars, _ := m.Decode(result.Values)
+	if sidecars["search"] != 1 || sidecars["frontend"] != -1 || sidecars["geo"] != -1 || result.Cost != 10 {
+		t.Errorf("Expected envoy at search only, got %v with cost %d", sidecars, result.Cost)
+	}
+
+	// Without protocols, the cheap dataplane is used.
+	m, _ = BuildModel(policies, g.GetEdges(), g.GetServices(), map[string]int{}, xPlane.GetDataplaneCosts(r.GetDataplanes()))
+	if result, _ := NewGoSolver().Solve(m); result.Cost != 1 {
+		t.Errorf("Expected cost 1 without protocols, got %d", result.Cost)
+	}
+}
+
+func TestSolvers(t *testing.T) {
+	flag.Parse()
+
+	policies, applEdges, services, sidecarCosts := createGenerateGraph()
+	m, err := BuildModel(policies, applEdges, services, map[string]int{}, sidecarCosts)
+	if err != nil {
+		t.Fatalf("Error building model: %v", err)
+	}
+
+	result, err := NewGoSolver().Solve(m)
+	if err != nil || result.Status != STATUS_OPTIMAL {
+		t.Fatalf("Expected an optimal solution, got %v %v", result, err)
+	}
+	if err := m.Check(result.Values); err != nil {
+		t.Errorf("Expected a feasible solution: %v", err)
+	}
+	if result.Cost != 3 {
+		t.Errorf("Expected cost 3, got %d", result.Cost)
+	}
+
+	// A model with a pinned assignment that cannot support a policy has no solution.
+	m, _ = BuildModel(policies[2:], applEdges, services, map[string]int{"E": 0}, []int{0, 1, 2})
+	if result, err := NewGoSolver().Solve(m); err != nil || result.Status != STATUS_UNSAT {
+		t.Errorf("Expected no solution, got %v %v", result, err)
+	}
+
+	// The write-only solver writes the model and stops.
+	file := path.Join(t.TempDir(), "model.smt")
+	result, err = NewWriteOnlySolver(file).Solve(m)
+	if err != nil || result.Status != STATUS_WRITTEN || result.HasSolution() {
+		t.Errorf("Expected the model to be written, got %v %v", result, err)
+	}
+	if b, err := os.ReadFile(file); err != nil || !strings.Contains(string(b), "(minimize ") {
+		t.Errorf("Expected an SMT-LIB file, got %v", err)
+	}
+	if result.File != file {
+		t.Errorf("Expected the model to be written to %s, got %s", file, result.File)
+	}
+
+	// Without a file, every run writes to a new file.
+	writer := NewWriteOnlySolver("")
+	first, err := writer.Solve(m)
+	if err != nil {
+		t.Fatalf("Error writing model: %v", err)
+	}
+	defer os.Remove(first.File)
+	second, err := writer.Solve(m)
+	if err != nil {
+		t.Fatalf("Error writing model: %v", err)
+	}
+	defer os.Remove(second.File)
+	if first.File == second.File {
+		t.Errorf("Expected different files, got %s twice", first.File)
+	}
+	if b, err := os.ReadFile(second.File); err != nil || !strings.Contains(string(b), "(minimize ") {
+		t.Errorf("Expected an SMT-LIB file, got %v", err)
+	}
+
+	for _, name := range []string{"z3", "go", "portfolio", "write"} {
+		if s, err := NewSolver(name); err != nil || s.Name() != name {
+			t.Errorf("Expected solver %s, got %v %v", name, s, err)
+		}
+	}
+	if _, err := NewSolver("cplex"); err == nil {
+		t.Errorf("Expected an error for an unknown solver")
+	}
+}
+
+func TestParseZ3Output(t *testing.T) {
+	flag.Parse()
+
+	m := &Model{}
+	x := m.NewVar("X_0_0")
+	y := m.NewVar("X_0_1")
+	m.Objective = []Term{{2, x}, {3, y}}
+
+	result, err := parseZ3Output(m, []byte("sat\n((X_0_0 1))\n((X_0_1 0))\n"))
+	if err != nil || result.Status != STATUS_OPTIMAL || !result.Values[x] || result.Values[y] || result.Cost != 2 {
+		t.Errorf("Unexpected result %v %v", result, err)
+	}
+
+	if result, err := parseZ3Output(m, []byte("unsat\n(error \"model is not available\")\n")); err != nil || result.Status != STATUS_UNSAT {
+		t.Errorf("Expected unsat, got %v %v", result, err)
+	}
+	var missingErr *MissingValuesError
+	if _, err := parseZ3Output(m, []byte("sat\n((X_0_0 1))\n")); !errors.As(err, &missingErr) || !reflect.DeepEqual(missingErr.Missing, []string{"X_0_1"}) {
+		t.Errorf("Expected an error for missing values, got %v", err)
+	}
+
+	tests := []struct {
+		out    string
+		status Status
+		values []bool
+	}{
+		// Values out of order, on one line, with warnings and comments.
+		{"WARNING: optimization with quantifiers\nsat\n((X_0_1 0)) ; comment\n((X_0_0 1)) ((X_0_1 0))\n", STATUS_OPTIMAL, []bool{true, false}},
+		// Values split across lines, and booleans.
+		{"sat\n(\n  (X_0_0 true)\n  (X_0_1\n false)\n)\n", STATUS_OPTIMAL, []bool{true, false}},
+		// The model of get-model.
+		{"sat\n(\n  (define-fun X_0_1 () Int\n    1)\n  (define-fun X_0_0 () Int\n    0)\n)\n", STATUS_OPTIMAL, []bool{false, true}},
+		{"sat\n(model\n  (define-fun X_0_0 () Int 1)\n  (define-fun X_0_1 () Int 1)\n)\n", STATUS_OPTIMAL, []bool{true, true}},
+		// A timeout before or after the values.
+		{"timeout\n", STATUS_UNKNOWN, nil},
+		{"unknown\n(error \"line 5 column 10: model is not available\")\n", STATUS_UNKNOWN, nil},
+		{"sat\n((X_0_0 1))\n((X_0_1 1))\ntimeout\n", STATUS_SAT, []bool{true, true}},
+	}
+	for k, test := range tests {
+		result, err := parseZ3Output(m, []byte(test.out))
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", k, err)
+			continue
+		}
+		if result.Status != test.status || !reflect.DeepEqual(result.Values, test.values) {
+			t.Errorf("Test %d: expected %s %v, got %s %v", k, test.status, test.values, result.Status, result.Values)
+		}
+	}
+
+	var z3Err *Z3Error
+	if _, err := parseZ3Output(m, []byte("(error \"line 1 column 1: unknown constant \"\"Y\"\"\")\n")); !errors.As(err, &z3Err) || z3Err.Message != "line 1 column 1: unknown constant \"Y\"" {
+		t.Errorf("Expected a z3 error, got %v", err)
+	}
+	if _, err := parseZ3Output(m, []byte("sat\n((X_0_0 1))\n(error \"invalid get-value command\")\n")); !errors.As(err, &z3Err) {
+		t.Errorf("Expected a z3 error, got %v", err)
+	}
+	var unknownErr *UnknownVariableError
+	if _, err := parseZ3Output(m, []byte("sat\n((X_0_0 1) (X_0_1 0) (Z 1))\n")); !errors.As(err, &unknownErr) || unknownErr.Name != "Z" {
+		t.Errorf("Expected an unknown variable error, got %v", err)
+	}
+	var invalidErr *InvalidValueError
+	if _, err := parseZ3Output(m, []byte("sat\n((X_0_0 2) (X_0_1 0))\n")); !errors.As(err, &invalidErr) {
+		t.Errorf("Expected an invalid value error, got %v", err)
+	}
+	if _, err := parseZ3Output(m, []byte("")); !errors.Is(err, ErrNoStatus) {
+		t.Errorf("Expected no status, got %v", err)
+	}
+	var syntaxErr *SyntaxError
+	if _, err := parseZ3Output(m, []byte("sat\n((X_0_0 1)\n")); !errors.As(err, &syntaxErr) {
+		t.Errorf("Expected a syntax error, got %v", err)
+	}
+}
+
+func TestParseSExprs(t *testing.T) {
+	flag.Parse()
+
+	exprs, err := ParseSExprs(`sat ; comment
+(a (b "c ""d"" ;") |e f| ())`)
+	if err != nil {
+		t.Fatalf("Error parsing: %v", err)
+	}
+	if len(exprs) != 2 || exprs[0].Atom != "sat" || exprs[1].String() != `(a (b "c ""d"" ;") e f ())` {
+		t.Errorf("Unexpected expressions %v", exprs)
+	}
+	if !exprs[1].HasHead("a") || exprs[1].List[2].Atom != "e f" || !exprs[1].List[3].IsList {
+		t.Errorf("Unexpected list %v", exprs[1])
+	}
+
+	for _, input := range []string{"(a", "a)", `"a`, "|a"} {
+		if _, err := ParseSExprs(input); err == nil {
+			t.Errorf("Expected a syntax error for %s", input)
+		}
+	}
+}
+
+// Find the optimal cost of a model by trying every value of the variables.
+func bruteForce(m *Model) (int, bool) {
+	values := make([]bool, len(m.Vars))
+	best, found := 0, false
+
+	for mask := 0; mask < 1<<len(m.Vars); mask++ {
+		for v := range values {
+			values[v] = mask&(1<<v) != 0
+		}
+
+		feasible := true
+		for k := range m.Constraints {
+			if !m.Constraints[k].Holds(values) {
+				feasible = false
+				break
+			}
+		}
+		if !feasible {
+			continue
+		}
+
+		if cost := m.Cost(values); !found || cost < best {
+			best, found = cost, true
+		}
+	}
+
+	return best, found
+}
+
+func TestGoSolverExact(t *testing.T) {
+	flag.Parse()
+
+	services := []string{"A", "B", "C", "D"}
+	constraints := []xPlane.ConstraintType{xPlane.SENDER, xPlane.RECEIVER, xPlane.SENDER_RECEIVER}
+	r := rand.New(rand.NewSource(1))
+
+	for instance := 0; instance < 40; instance++ {
+		// A random DAG, with every service calling some of the later ones.
+		applEdges := make(map[string][]string)
+		for i := range services {
+			for j := i + 1; j < len(services); j++ {
+				if r.Intn(2) == 0 {
+					applEdges[services[i]] = append(applEdges[services[i]], services[j])
+				}
+			}
+		}
+
+		policies := make([]xPlane.Policy, 0)
+		for j := 0; j < 2; j++ {
+			context := []string{"*", services[1+r.Intn(len(services)-1)]}
+			if r.Intn(2) == 0 {
+				context = []string{services[r.Intn(len(services)-1)], "*"}
+			}
+
+			dataplanes := []int{r.Intn(2)}
+			if r.Intn(2) == 0 {
+				dataplanes = []int{0, 1}
+			}
+			function := xPlane.CreateNewPolicyFunction(fmt.Sprintf("f%d", j), constraints[r.Intn(len(constraints))], dataplanes, false)
+			policies = append(policies, xPlane.CreatePolicy(context, []xPlane.PolicyFunction{function}))
+		}
+
+		sidecarAssignment := map[string]int{}
+		if r.Intn(4) == 0 {
+			sidecarAssignment[services[r.Intn(len(services))]] = r.Intn(2)
+		}
+
+		m, err := BuildModel(policies, applEdges, services, sidecarAssignment, []int{1 + r.Intn(5), 1 + r.Intn(5)})
+		var unresolved *UnresolvedPolicyError
+		if errors.As(err, &unresolved) {
+			// The random context of a policy matches no edge of the random graph.
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error building model: %v", err)
+		}
+
+		// Some instances migrate from a deployed placement.
+		if r.Intn(2) == 0 {
+			migration := Migration{
+				Deployed:   map[string]int{services[r.Intn(len(services))]: r.Intn(2)},
+				Cost:       MigrationCost{Add: r.Intn(3), Remove: r.Intn(10), Switch: r.Intn(10)},
+				MaxChanges: r.Intn(4) - 1,
+			}
+			if err := m.AddMigration(migration); err != nil {
+				t.Fatalf("Error adding the migration: %v", err)
+			}
+		}
+
+		expected, feasible := bruteForce(m)
+		result, err := NewGoSolver().Solve(m)
+		if err != nil {
+			t.Fatalf("Error solving instance %d: %v", instance, err)
+		}
+
+		if !feasible {
+			if result.Status != STATUS_UNSAT {
+				t.Errorf("Expected instance %d to be unsat, got %v", instance, result.Status)
+			}
+			if reduced, err := NewReducingSolver(NewGoSolver()).Solve(m); err != nil || reduced.Status != STATUS_UNSAT {
+				t.Errorf("Expected the reduced instance %d to be unsat, got %v %v", instance, reduced, err)
+			}
+			continue
+		}
+		if result.Status != STATUS_OPTIMAL || result.Cost != expected {
+			t.Errorf("Expected optimal cost %d for instance %d, got %v with cost %d", expected, instance, result.Status, result.Cost)
+		}
+		if err := m.Check(result.Values); err != nil {
+			t.Errorf("Expected a feasible solution for instance %d: %v", instance, err)
+		}
+
+		// Solving the reduced model gives the same cost.
+		reduced, err := NewReducingSolver(NewGoSolver()).Solve(m)
+		if err != nil || reduced.Status != STATUS_OPTIMAL || reduced.Cost != expected || m.Check(reduced.Values) != nil {
+			t.Errorf("Expected optimal cost %d for the reduced instance %d, got %v %v", expected, instance, reduced, err)
+		}
+
+		// The heuristic does not search every constraint, but its placements are feasible and not better than optimal.
+		heuristic, err := NewHeuristicSolver().Solve(m)
+		if err != nil {
+			t.Fatalf("Error solving instance %d with the heuristic: %v", instance, err)
+		}
+		if heuristic.HasSolution() && (m.Check(heuristic.Values) != nil || heuristic.Cost < expected) {
+			t.Errorf("Unexpected heuristic solution for instance %d with cost %d", instance, heuristic.Cost)
+		}
+	}
+}
+
+func TestHeuristicSolver(t *testing.T) {
+	flag.Parse()
+
+	services := []string{"A", "B", "C", "D", "E", "F", "G"}
+	applEdges := make(map[string][]string)
+	applEdges["A"] = []string{"B", "C"}
+	applEdges["B"] = []string{"E"}
+	applEdges["C"] = []string{"D"}
+	applEdges["D"] = []string{"E"}
+	applEdges["E"] = []string{"F", "G"}
+
+	setHeaderFunc := xPlane.CreateNewPolicyFunction("setHeader", xPlane.SENDER_RECEIVER, []int{0, 1, 2}, false)
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{1}, false)
+	setDeadlineFunc := xPlane.CreateNewPolicyFunction("setDeadline", xPlane.SENDER, []int{2}, false)
+	policies := []xPlane.Policy{
+		xPlane.CreatePolicy([]string{"A", "*"}, []xPlane.PolicyFunction{setHeaderFunc}),
+		xPlane.CreatePolicy([]string{"*", "F"}, []xPlane.PolicyFunction{countFunc}),
+		xPlane.CreatePolicy([]string{"A", "*", "E", "*"}, []xPlane.PolicyFunction{setDeadlineFunc}),
+	}
+
+	m, err := BuildModel(policies, applEdges, services, map[string]int{}, []int{0, 1, 2})
+	if err != nil {
+		t.Fatalf("Error building model: %v", err)
+	}
+
+	// The heuristic finds the optimal placement of TestSolvers, and reports no gap to the exact solver.
+	h := NewHeuristicSolver()
+	h.Exact = NewGoSolver()
+	result, err := h.Solve(m)
+	if err != nil || result.Status != STATUS_SAT {
+		t.Fatalf("Expected a placement, got %v %v", result, err)
+	}
+	if err := m.Check(result.Values); err != nil {
+		t.Errorf("Expected a feasible solution: %v", err)
+	}
+	if result.Cost != 3 || result.Gap != 0 {
+		t.Errorf("Expected cost 3 with no gap, got cost %d with gap %f", result.Cost, result.Gap)
+	}
+
+	// Without an exact solver, the gap is unknown.
+	if result, _ := NewHeuristicSolver().Solve(m); result.Gap != -1 {
+		t.Errorf("Expected an unknown gap, got %f", result.Gap)
+	}
+
+	// An assigned dataplane that cannot support a policy leaves no feasible placement.
+	m, _ = BuildModel(policies[2:], applEdges, services, map[string]int{"E": 0}, []int{0, 1, 2})
+	if result, err := NewHeuristicSolver().Solve(m); err != nil || result.HasSolution() {
+		t.Errorf("Expected no placement, got %v %v", result, err)
+	}
+
+	if _, err := NewHeuristicSolver().Solve(&Model{}); err == nil {
+		t.Errorf("Expected an error for a model without placement structure")
+	}
+}
+
+func TestMigration(t *testing.T) {
+	flag.Parse()
+
+	services := []string{"A", "B", "C"}
+	applEdges := map[string][]string{"A": {"B", "C"}}
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{0, 1}, false)
+	policies := []xPlane.Policy{xPlane.CreatePolicy([]string{"A", "B"}, []xPlane.PolicyFunction{countFunc})}
+	deployed := map[string]int{"B": 1, "C": 1}
+
+	tests := []struct {
+		name       string
+		cost       MigrationCost
+		maxChanges int
+		cost0      int
+		sidecars   map[string]int
+	}{
+		// Migrating is free: dataplane 0 at A or B, the cheapest one.
+		{"free", MigrationCost{}, NO_CHANGE_LIMIT, 1, nil},
+		// Removing C is cheap, but switching B is not, so dataplane 1 stays at B.
+		{"expensive switch", MigrationCost{Add: 1, Remove: 1, Switch: 10}, NO_CHANGE_LIMIT, 3, map[string]int{"A": -1, "B": 1, "C": -1}},
+		// Removing C costs more than keeping it.
+		{"expensive removal", MigrationCost{Add: 1, Remove: 10, Switch: 10}, NO_CHANGE_LIMIT, 4, map[string]int{"A": -1, "B": 1, "C": 1}},
+		// Without changes, the deployed placement is kept even if migrating is free.
+		{"no changes", MigrationCost{}, NO_CHANGES, 4, map[string]int{"A": -1, "B": 1, "C": 1}},
+		// The zero value of MaxChanges does not limit the changes.
+		{"zero limit", MigrationCost{Add: 1, Remove: 1, Switch: 10}, 0, 3, map[string]int{"A": -1, "B": 1, "C": -1}},
+	}
+
+	for _, test := range tests {
+		m, err := BuildModel(policies, applEdges, services, map[string]int{}, []int{1, 2})
+		if err != nil {
+			t.Fatalf("Error building model: %v", err)
+		}
+		migration := Migration{Deployed: deployed, Cost: test.cost, MaxChanges: test.maxChanges}
+		if err := m.AddMigration(migration); err != nil {
+			t.Fatalf("Error adding the migration: %v", err)
+		}
+
+		result, err := NewGoSolver().Solve(m)
+		if err != nil || result.Status != STATUS_OPTIMAL {
+			t.Fatalf("Expected an optimal solution for %s, got %v %v", test.name, result, err)
+		}
+		if result.Cost != test.cost0 {
+			t.Errorf("Expected cost %d for %s, got %d", test.cost0, test.name, result.Cost)
+		}
+		sidecars, _ := m.Decode(result.Values)
+		if test.sidecars != nil && !reflect.DeepEqual(sidecars, test.sidecars) {
+			t.Errorf("Expected sidecars %v for %s, got %v", test.sidecars, test.name, sidecars)
+		}
+		if test.maxChanges == NO_CHANGES && migration.Changes(sidecars) != 0 {
+			t.Errorf("Expected no changes, got %v", sidecars)
+		}
+	}
+
+	// Per-service costs override the default cost.
+	m, _ := BuildModel(policies, applEdges, services, map[string]int{}, []int{1, 2})
+	migration := Migration{
+		Deployed:     deployed,
+		ServiceCosts: map[string]MigrationCost{"B": {Switch: 10, Remove: 10}},
+		MaxChanges:   NO_CHANGE_LIMIT,
+	}
+	m.AddMigration(migration)
+	if result, _ := NewGoSolver().Solve(m); result.Cost != 2 {
+		t.Errorf("Expected to keep B and remove C for cost 2, got %d", result.Cost)
+	}
+
+	m, _ = BuildModel(policies, applEdges, services, map[string]int{}, []int{1, 2})
+	if err := m.AddMigration(Migration{Deployed: map[string]int{"B": 2}, MaxChanges: NO_CHANGE_LIMIT}); err == nil {
+		t.Errorf("Expected an error for an unknown dataplane")
+	}
+}
+
+func TestWeightedCost(t *testing.T) {
+	flag.Parse()
+
+	g := xPlane.NewApplicationGraph()
+	g.AddService("frontend", xPlane.ServiceAttributes{Replicas: 40})
+	g.AddService("search", xPlane.ServiceAttributes{Replicas: 2})
+	g.AddService("cache", xPlane.ServiceAttributes{})
+	g.AddEdge("frontend", "search", xPlane.EdgeAttributes{RequestRate: 1000})
+	g.AddEdge("search", "cache", xPlane.EdgeAttributes{RequestRate: 10})
+
+	r := xPlane.NewDataplaneRegistry()
+	r.Register("envoy", 0, nil)
+	r.SetResources("envoy", xPlane.ResourceProfile{CPUPerRequest: 0.1, MemoryPerInstance: 50})
+
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{0}, false)
+	cachePolicy := xPlane.CreatePolicy([]string{"search", "cache"}, []xPlane.PolicyFunction{countFunc})
+	searchPolicy := xPlane.CreatePolicy([]string{"frontend", "search"}, []xPlane.PolicyFunction{countFunc})
+
+	tests := []struct {
+		policies []xPlane.Policy
+		model    xPlane.CostModel
+		sidecars map[string]int
+		cost     int
+	}{
+		// The cache has a single replica and processes few requests: 1 + 1 + 50.
+		{[]xPlane.Policy{cachePolicy}, xPlane.DefaultCostModel, map[string]int{"frontend": -1, "search": -1, "cache": 0}, 51},
+		// Search enforces both policies for 2 * 50 + 101, less than the cache and the 40 replicas of the frontend.
+		{[]xPlane.Policy{searchPolicy, cachePolicy}, xPlane.DefaultCostModel, map[string]int{"frontend": -1, "search": 0, "cache": -1}, 201},
+		// With memory only, the frontend is still too expensive.
+		{[]xPlane.Policy{searchPolicy, cachePolicy}, xPlane.CostModel{Memory: 1}, map[string]int{"frontend": -1, "search": 0, "cache": -1}, 100},
+		// With CPU only, the cache is cheaper than search for the policy it can enforce.
+		{[]xPlane.Policy{cachePolicy}, xPlane.CostModel{CPU: 1}, map[string]int{"frontend": -1, "search": -1, "cache": 0}, 1},
+	}
+
+	for k, test := range tests {
+		m, err := BuildWeightedModelForGraph(test.policies, g, map[string]int{}, r.GetDataplanes(), test.model)
+		if err != nil {
+			t.Fatalf("Error building model: %v", err)
+		}
+		result, err := NewGoSolver().Solve(m)
+		if err != nil || result.Status != STATUS_OPTIMAL {
+			t.Fatalf("Expected an optimal solution, got %v %v", result, err)
+		}
+
+		sidecars, _ := m.Decode(result.Values)
+		if !reflect.DeepEqual(sidecars, test.sidecars) {
+			t.Errorf("Test %d: expected sidecars %v, got %v", k, test.sidecars, sidecars)
+		}
+		if result.Cost != test.cost || m.RunningCost(result.Values) != test.cost {
+			t.Errorf("Test %d: expected cost %d, got %d", k, test.cost, result.Cost)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	flag.Parse()
+
+	g := xPlane.NewApplicationGraph()
+	g.AddService("frontend", xPlane.ServiceAttributes{})
+	g.AddService("search", xPlane.ServiceAttributes{Replicas: 2})
+	g.AddService("cache", xPlane.ServiceAttributes{})
+	g.AddEdge("frontend", "search", xPlane.EdgeAttributes{RequestRate: 1000})
+	g.AddEdge("search", "cache", xPlane.EdgeAttributes{RequestRate: 10})
+
+	// Envoy is cheaper, but bpf uses less memory.
+	r := xPlane.NewDataplaneRegistry()
+	r.Register("envoy", 1, nil)
+	r.Register("bpf", 100, nil)
+	r.SetResources("envoy", xPlane.ResourceProfile{CPUPerRequest: 0.1, CPUPerInstance: 10, MemoryPerInstance: 50})
+	r.SetResources("bpf", xPlane.ResourceProfile{CPUPerInstance: 1, MemoryPerInstance: 1})
+	dataplanes := r.GetDataplanes()
+
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{0, 1}, false)
+	receiveFunc := xPlane.CreateNewPolicyFunction("receive", xPlane.RECEIVER, []int{0, 1}, false)
+	countPolicy := xPlane.CreatePolicy([]string{"search", "cache"}, []xPlane.PolicyFunction{countFunc})
+	receivePolicy := xPlane.CreatePolicy([]string{"search", "cache"}, []xPlane.PolicyFunction{receiveFunc})
+
+	cluster := func(cpu1, memory1, cpu2, memory2 float64) *xPlane.Cluster {
+		return &xPlane.Cluster{
+			Nodes: map[string]xPlane.NodeCapacity{"node-1": {CPU: cpu1, Memory: memory1}, "node-2": {CPU: cpu2, Memory: memory2}},
+			Pods: []xPlane.Pod{
+				{Name: "frontend-0", Service: "frontend", Node: "node-1"},
+				{Name: "search-0", Service: "search", Node: "node-1"},
+				{Name: "search-1", Service: "search", Node: "node-1"},
+				{Name: "cache-0", Service: "cache", Node: "node-2"},
+			},
+		}
+	}
+
+	tests := []struct {
+		policy    xPlane.Policy
+		cluster   *xPlane.Cluster
+		sidecars  map[string]int
+		overloads []Overload
+	}{
+		// Envoy at cache fits.
+		{countPolicy, cluster(1000, 1000, 1000, 1000), map[string]int{"frontend": -1, "search": -1, "cache": 0}, nil},
+		// Envoy at cache needs 50 MiB on node-2, so bpf runs there instead of envoy at both search pods.
+		{countPolicy, cluster(1000, 1000, 1000, 40), map[string]int{"frontend": -1, "search": -1, "cache": 1}, nil},
+		// Not even bpf fits on node-2: bpf at search needs 2 * 1 MiB on node-1.
+		{countPolicy, cluster(1000, 1000, 1000, 0), map[string]int{"frontend": -1, "search": 1, "cache": -1}, nil},
+		// Both nodes fit on their own, but not together.
+		{countPolicy, cluster(1000, 1, 1000, 0), nil, []Overload{{"node-2", "memory", 50, 0}}},
+		// Only the cache can enforce the policy, and node-2 cannot fit any dataplane.
+		{receivePolicy, cluster(1000, 1000, 1000, 0), nil, []Overload{{"node-2", "memory", 50, 0}}},
+		// Envoy at cache needs 10 + 0.1 * 10 millicores.
+		{receivePolicy, cluster(1000, 1000, 10, 1000), map[string]int{"frontend": -1, "search": -1, "cache": 1}, nil},
+		{receivePolicy, cluster(1000, 1000, 11, 1000), map[string]int{"frontend": -1, "search": -1, "cache": 0}, nil},
+		{receivePolicy, cluster(1000, 1000, 0, 0), nil, []Overload{{"node-2", "cpu", 11, 0}, {"node-2", "memory", 50, 0}}},
+	}
+
+	for k, test := range tests {
+		m, err := BuildModelForGraph([]xPlane.Policy{test.policy}, g, map[string]int{}, dataplanes)
+		if err != nil {
+			t.Fatalf("Error building model: %v", err)
+		}
+		if err := m.AddCapacity(test.cluster, g, dataplanes); err != nil {
+			t.Fatalf("Error adding capacity: %v", err)
+		}
+
+		result, err := NewGoSolver().Solve(m)
+		if err != nil {
+			t.Fatalf("Error solving model: %v", err)
+		}
+
+		if test.sidecars != nil {
+			if result.Status != STATUS_OPTIMAL {
+				t.Errorf("Test %d: expected an optimal solution, got %s", k, result.Status)
+				continue
+			}
+			if sidecars, _ := m.Decode(result.Values); !reflect.DeepEqual(sidecars, test.sidecars) {
+				t.Errorf("Test %d: expected sidecars %v, got %v", k, test.sidecars, sidecars)
+			}
+			continue
+		}
+
+		if result.Status != STATUS_UNSAT {
+			t.Errorf("Test %d: expected no solution, got %s", k, result.Status)
+			continue
+		}
+		capacityErr, err := ExplainCapacity(context.Background(), NewGoSolver(), m)
+		if err != nil || capacityErr == nil {
+			t.Errorf("Test %d: expected an explanation, got %v", k, err)
+			continue
+		}
+		if !reflect.DeepEqual(capacityErr.Overloads, test.overloads) {
+			t.Errorf("Test %d: expected overloads %v, got %v", k, test.overloads, capacityErr.Overloads)
+		}
+	}
+
+	// A pod on an unknown node is an error.
+	m, _ := BuildModelForGraph([]xPlane.Policy{countPolicy}, g, map[string]int{}, dataplanes)
+	c := cluster(1000, 1000, 1000, 1000)
+	c.Pods = append(c.Pods, xPlane.Pod{Name: "cache-1", Service: "cache", Node: "node-3"})
+	if err := m.AddCapacity(c, g, dataplanes); err == nil {
+		t.Errorf("Expected an error for a pod on an unknown node")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	flag.Parse()
+
+	applEdges := map[string][]string{"A": {"B", "C"}, "B": {"D"}}
+	services := []string{"A", "B", "C", "D"}
+	dataplanes := []xPlane.Dataplane{xPlane.CreateDataplane("envoy", 0, 1, nil), xPlane.CreateDataplane("bpf", 1, 1, nil)}
+
+	routeFunc := xPlane.CreateNewPolicyFunction("RouteToVersion", xPlane.SENDER, []int{}, true)
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{0}, false)
+	filterFunc := xPlane.CreateNewPolicyFunction("filter", xPlane.RECEIVER, []int{1}, false)
+
+	tests := []struct {
+		policies    []xPlane.Policy
+		assignment  map[string]int
+		explanation []string
+	}{
+		{
+			// No dataplane supports the function.
+			[]xPlane.Policy{
+				xPlane.CreatePolicy([]string{"A", "C"}, []xPlane.PolicyFunction{countFunc}),
+				xPlane.CreatePolicy([]string{"A", "B"}, []xPlane.PolicyFunction{routeFunc}),
+			},
+			map[string]int{},
+			[]string{"policy 1 [A B] needs SENDER enforcement on {A} but no dataplane supporting RouteToVersion is allowed there"},
+		},
+		{
+			// B runs envoy, which cannot filter.
+			[]xPlane.Policy{
+				xPlane.CreatePolicy([]string{"A", "C"}, []xPlane.PolicyFunction{countFunc}),
+				xPlane.CreatePolicy([]string{"A", "B"}, []xPlane.PolicyFunction{filterFunc}),
+			},
+			map[string]int{"B": 0},
+			[]string{
+				"policy 1 [A B] needs RECEIVER enforcement on {B} but B runs envoy, which does not support filter",
+				"every service runs at most one dataplane",
+			},
+		},
+		{
+			// B needs both dataplanes, unless the count is enforced by D.
+			[]xPlane.Policy{
+				xPlane.CreatePolicy([]string{"A", "B"}, []xPlane.PolicyFunction{filterFunc}),
+				xPlane.CreatePolicy([]string{"B", "D"}, []xPlane.PolicyFunction{countFunc}),
+				xPlane.CreatePolicy([]string{"A", "C"}, []xPlane.PolicyFunction{countFunc}),
+			},
+			map[string]int{"D": 1},
+			[]string{
+				"policy 0 [A B] needs RECEIVER enforcement on {B}",
+				"policy 1 [B D] needs SENDER enforcement on {B}, or RECEIVER enforcement on {D} but D runs bpf, which does not support count",
+				"every service runs at most one dataplane",
+			},
+		},
+	}
+
+	for k, test := range tests {
+		m, err := BuildModel(test.policies, applEdges, services, test.assignment, []int{1, 1})
+		if err != nil {
+			t.Fatalf("Error building model: %v", err)
+		}
+		if result, err := NewGoSolver().Solve(m); err != nil || result.Status != STATUS_UNSAT {
+			t.Fatalf("Test %d: expected no solution, got %v %v", k, result, err)
+		}
+
+		core, err := FindCore(context.Background(), NewGoSolver(), m)
+		if err != nil {
+			t.Fatalf("Error finding core: %v", err)
+		}
+		if explanation := Explain(m, core, test.policies, dataplanes); !reflect.DeepEqual(explanation, test.explanation) {
+			t.Errorf("Test %d: expected explanation %q, got %q", k, test.explanation, explanation)
+		}
+	}
+
+	// Every constraint is named in the SMT-LIB, for the unsat core of z3.
+	m, _ := BuildModel(tests[0].policies, applEdges, services, map[string]int{}, []int{1, 1})
+	var b strings.Builder
+	if err := m.WriteSMTLIBCore(&b); err != nil || !strings.Contains(b.String(), ":named supported_1_0)") || !strings.HasSuffix(b.String(), "(get-unsat-core)\n") {
+		t.Errorf("Expected named assertions, got %v %s", err, b.String())
+	}
+
+	core, err := parseZ3Core([]byte("unsat\n(sender_1_0 supported_1_0)\n"))
+	if err != nil || !reflect.DeepEqual(core, []string{"sender_1_0", "supported_1_0"}) {
+		t.Errorf("Unexpected core %v %v", core, err)
+	}
+	if _, err := parseZ3Core([]byte("sat\n(error \"unsat core is not available\")\n")); err == nil {
+		t.Errorf("Expected an error for a model with a solution")
+	}
+}
+
+// Write a script that stands in for z3 in the temporary directory of the test, and return its path.
+func fakeZ3(t *testing.T, script string) string {
+	file := path.Join(t.TempDir(), "z3")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("Error writing script: %v", err)
+	}
+	return file
+}
+
+func TestSolveContext(t *testing.T) {
+	flag.Parse()
+
+	applEdges := map[string][]string{"A": {"B", "C"}, "B": {"D"}, "C": {"D"}}
+	services := []string{"A", "B", "C", "D"}
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{0, 1}, false)
+	policies := []xPlane.Policy{
+		xPlane.CreatePolicy([]string{"A", "*"}, []xPlane.PolicyFunction{countFunc}),
+		xPlane.CreatePolicy([]string{"*", "D"}, []xPlane.PolicyFunction{countFunc}),
+	}
+
+	// The model is given on the standard input of z3, so that concurrent runs do not share files.
+	// This z3 sets every variable it reads to 1.
+	z := NewZ3Solver()
+	z.Path = fakeZ3(t, `[ "$1" = "-in" ] || exit 1
+echo sat
+sed -n 's/^(declare-const \([^ ]*\) Int)$/((\1 1))/p'
+`)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for k := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Models of different sizes.
+			m, _ := BuildModel(policies, applEdges, services, map[string]int{}, make([]int, 1+k%3))
+			result, err := z.SolveContext(context.Background(), m)
+			if err == nil && (result.Status != STATUS_OPTIMAL || len(result.Values) != len(m.Vars)) {
+				err = fmt.Errorf("unexpected result %v", result)
+			}
+			errs[k] = err
+		}()
+	}
+	wg.Wait()
+	for k, err := range errs {
+		if err != nil {
+			t.Errorf("Run %d: %v", k, err)
+		}
+	}
+
+	// z3 is killed when the context is done.
+	m, _ := BuildModel(policies, applEdges, services, map[string]int{}, []int{1, 2})
+	z.Path = fakeZ3(t, "exec sleep 10\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := z.SolveContext(ctx, m); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected z3 to be killed, took %v", time.Since(start))
+	}
+
+	// The search of the Go solver stops, and the heuristic returns its greedy placement.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if result, err := NewGoSolver().SolveContext(ctx, m); err != nil || result.Status != STATUS_UNKNOWN {
+		t.Errorf("Expected an unknown result, got %v %v", result, err)
+	}
+	if result, err := NewHeuristicSolver().SolveContext(ctx, m); err != nil || result.Status != STATUS_SAT {
+		t.Errorf("Expected a placement, got %v %v", result, err)
+	}
+	if _, err := FindCore(ctx, NewGoSolver(), m); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the core search to be canceled, got %v", err)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	flag.Parse()
+
+	services := []string{"A", "B", "C", "D", "E", "F", "B-mongo"}
+	applEdges := map[string][]string{"A": {"B", "C"}, "B": {"B-mongo"}, "E": {"F"}}
+
+	countFunc := xPlane.CreateNewPolicyFunction("count", xPlane.SENDER_RECEIVER, []int{0, 1}, false)
+	routeFunc := xPlane.CreateNewPolicyFunction("route", xPlane.SENDER_RECEIVER, []int{1}, true)
+	policies := []xPlane.Policy{
+		xPlane.CreatePolicy([]string{"A", "B"}, []xPlane.PolicyFunction{countFunc}),
+		xPlane.CreatePolicy([]string{"A", "C"}, []xPlane.PolicyFunction{routeFunc}),
+		xPlane.CreatePolicy([]string{"E", "F"}, []xPlane.PolicyFunction{countFunc}),
+		xPlane.CreatePolicy([]string{"A", "B"}, []xPlane.PolicyFunction{countFunc}),
+	}
+
+	tests := []struct {
+		name       string
+		assignment map[string]int
+
+		// Expected services of every component.
+		components [][]string
+	}{
+		{
+			// The last policy is merged into the first one, and the services that enforce no policy are removed.
+			name:       "free",
+			assignment: map[string]int{},
+			components: [][]string{{"A", "B", "C"}, {"E", "F"}},
+		},
+		{
+			// The dataplane pinned at A supports both policies at A, which do not share variables anymore.
+			name:       "pinned",
+			assignment: map[string]int{"A": 1},
+			components: [][]string{{"A", "B"}, {"E", "F"}, {"A", "C"}},
+		},
+	}
+
+	for _, test := range tests {
+		m, err := BuildModel(policies, applEdges, services, test.assignment, []int{1, 2})
+		if err != nil {
+			t.Fatalf("Error building model: %v", err)
+		}
+
+		r := Reduce(m)
+		if r.Infeasible || r.MergedPolicies != 1 || r.RemovedServices != 2 {
+			t.Errorf("Unexpected reduction for %s: %d policies merged, %d services removed", test.name, r.MergedPolicies, r.RemovedServices)
+		}
+		var components [][]string
+		for _, c := range r.Components {
+			components = append(components, c.Services)
+		}
+		if !reflect.DeepEqual(components, test.components) {
+			t.Errorf("Expected components %v for %s, got %v", test.components, test.name, components)
+		}
+
+		expected, err := NewGoSolver().Solve(m)
+		if err != nil || expected.Status != STATUS_OPTIMAL {
+			t.Fatalf("Expected an optimal solution for %s, got %v %v", test.name, expected, err)
+		}
+		for _, solver := range []Solver{NewGoSolver(), NewHeuristicSolver()} {
+			result, err := NewReducingSolver(solver).Solve(m)
+			if err != nil || !result.HasSolution() || m.Check(result.Values) != nil {
+				t.Fatalf("Expected a solution of the reduced %s model with the %s solver, got %v %v", test.name, solver.Name(), result, err)
+			}
+			if result.Cost != expected.Cost {
+				t.Errorf("Expected cost %d for %s with the %s solver, got %d", expected.Cost, test.name, solver.Name(), result.Cost)
+			}
+
+			// The merged policy is enforced where the first policy is.
+			_, impls := m.Decode(result.Values)
+			if !reflect.DeepEqual(impls[3], impls[0]) {
+				t.Errorf("Expected the merged policy to be enforced at %v for %s, got %v", impls[0], test.name, impls[3])
+			}
+		}
+	}
+
+	// A solver that writes the model writes the whole model, not one of its components.
+	m, _ := BuildModel(policies, applEdges, services, map[string]int{}, []int{1, 2})
+	filename := path.Join(t.TempDir(), "model.smt")
+	if result, err := NewReducingSolver(NewWriteOnlySolver(filename)).Solve(m); err != nil || result.Status != STATUS_WRITTEN {
+		t.Fatalf("Expected the model to be written, got %v %v", result, err)
+	}
+	var expected strings.Builder
+	if err := m.WriteSMTLIB(&expected); err != nil {
+		t.Fatalf("Error writing model: %v", err)
+	}
+	if written, err := os.ReadFile(filename); err != nil || string(written) != expected.String() {
+		t.Errorf("Expected the whole model to be written, got %d bytes instead of %d: %v", len(written), expected.Len(), err)
+	}
+
+	// A pinned dataplane that does not support a policy leaves no solution.
+	m, _ = BuildModel(policies[1:2], applEdges, services, map[string]int{"A": 0, "C": 0}, []int{1, 2})
+	if r := Reduce(m); !r.Infeasible {
+		t.Errorf("Expected no solution, got %d components", len(r.Components))
+	}
+	if result, err := NewReducingSolver(NewGoSolver()).Solve(m); err != nil || result.Status != STATUS_UNSAT {
+		t.Errorf("Expected no solution, got %v %v", result, err)
+	}
+}
+
+// Build the model of TestGenerate and solve it, for the round trips through the formats of other solvers.
+func solveGenerateModel(t *testing.T) (*Model, []bool) {
+	t.Helper()
+
+	policies, applEdges, services, sidecarCosts := createGenerateGraph()
+	m, err := BuildModel(policies, applEdges, services, map[string]int{}, sidecarCosts)
+	if err != nil {
+		t.Fatalf("Error building model: %v", err)
+	}
+	result, err := NewGoSolver().Solve(m)
+	if err != nil || result.Status != STATUS_OPTIMAL || result.Cost != 3 {
+		t.Fatalf("Expected an optimal solution of cost 3, got %v %v", result, err)
+	}
+	return m, result.Values
+}
+
+// Parse a sum written by infixTerms, split in words, back to terms.
+func parseInfixTerms(t *testing.T, m *Model, words []string) []Term {
+	t.Helper()
+
+	varMap := getSvcMapFromList(m.Vars)
+	var terms []Term
+	sign, coef := 1, 1
+	for _, word := range words {
+		switch {
+		case word == "+":
+			continue
+		case word == "-":
+			sign = -1
+			continue
+		}
+		if c, v, ok := strings.Cut(word, "*"); ok {
+			fmt.Sscan(c, &coef)
+			word = v
+		} else if n, err := strconv.Atoi(word); err == nil {
+			coef = n
+			continue
+		}
+		if name, ok := strings.CutPrefix(word, "-"); ok {
+			sign, word = -1, name
+		}
+
+		v, ok := varMap[word]
+		if !ok {
+			t.Fatalf("Unknown variable %s", word)
+		}
+		terms = append(terms, Term{sign * coef, v})
+		sign, coef = 1, 1
+	}
+	return terms
+}
+
+// Parse a constraint written as its terms, sense and right-hand side, split in words.
+func parseInfixConstraint(t *testing.T, m *Model, name string, words []string) Constraint {
+	t.Helper()
+
+	if len(words) < 3 {
+		t.Fatalf("Invalid constraint %s: %v", name, words)
+	}
+	senses := map[string]Sense{"<=": LE, ">=": GE, "=": EQ}
+	sense, ok := senses[words[len(words)-2]]
+	rhs, err := strconv.Atoi(words[len(words)-1])
+	if !ok || err != nil {
+		t.Fatalf("Invalid constraint %s: %v", name, words)
+	}
+	return Constraint{Name: name, Terms: parseInfixTerms(t, m, words[:len(words)-2]), Sense: sense, RHS: rhs}
+}
+
+// Check that the constraints and the objective parsed from a file are the ones of the model.
+func checkParsedModel(t *testing.T, format string, m *Model, constraints []Constraint, objective []Term) {
+	t.Helper()
+
+	if len(constraints) != len(m.Constraints) {
+		t.Fatalf("Expected %d constraints in %s, got %d", len(m.Constraints), format, len(constraints))
+	}
+	for k, c := range constraints {
+		if !reflect.DeepEqual(c, m.Constraints[k]) {
+			t.Errorf("Expected constraint %v in %s, got %v", m.Constraints[k], format, c)
+		}
+	}
+	if !reflect.DeepEqual(objective, m.Objective) {
+		t.Errorf("Expected objective %v in %s, got %v", m.Objective, format, objective)
+	}
+}
+
+// Check the result of parsing the output of a solver.
+func checkParsedResult(t *testing.T, name string, m *Model, result *Result, err error, status Status, values []bool) {
+	t.Helper()
+
+	if err != nil {
+		t.Errorf("Unexpected error parsing %s: %v", name, err)
+		return
+	}
+	if result.Status != status || !reflect.DeepEqual(result.Values, values) {
+		t.Errorf("Expected %s %v for %s, got %s %v", status, values, name, result.Status, result.Values)
+	}
+	if values != nil && result.Cost != m.Cost(values) {
+		t.Errorf("Expected cost %d for %s, got %d", m.Cost(values), name, result.Cost)
+	}
+}
+
+func TestMiniZinc(t *testing.T) {
+	flag.Parse()
+
+	m, optimal := solveGenerateModel(t)
+
+	var b strings.Builder
+	if err := m.WriteMiniZinc(&b); err != nil {
+		t.Fatalf("Error writing MiniZinc: %v", err)
+	}
+
+	// Parse the model back.
+	var vars []string
+	var constraints []Constraint
+	var objective []Term
+	for _, line := range strings.Split(b.String(), "\n") {
+		line = strings.TrimSuffix(line, ";")
+		if v, ok := strings.CutPrefix(line, "var 0..1: "); ok {
+			vars = append(vars, v)
+		} else if c, ok := strings.CutPrefix(line, "constraint :: "); ok {
+			words := strings.Fields(c)
+			name, err := strconv.Unquote(words[0])
+			if err != nil {
+				t.Fatalf("Invalid constraint name in %s: %v", line, err)
+			}
+			constraints = append(constraints, parseInfixConstraint(t, m, name, words[1:]))
+		} else if o, ok := strings.CutPrefix(line, "solve minimize "); ok {
+			objective = parseInfixTerms(t, m, strings.Fields(o))
+		}
+	}
+	if !reflect.DeepEqual(vars, m.Vars) {
+		t.Errorf("Expected variables %v, got %v", m.Vars, vars)
+	}
+	checkParsedModel(t, "MiniZinc", m, constraints, objective)
+
+	// The output of MiniZinc, with an improving solution and the optimal one.
+	solution := func(values []bool) string {
+		var b strings.Builder
+		for v, name := range m.Vars {
+			fmt.Fprintf(&b, "%s = %d;\n", name, map[bool]int{false: 0, true: 1}[values[v]])
+		}
+		return b.String() + "----------\n"
+	}
+	worse := make([]bool, len(optimal))
+	copy(worse, optimal)
+	worse[m.X[0][6]] = true
+
+	result, err := ParseMiniZincOutput(m, []byte(solution(worse)+solution(optimal)+"==========\n"))
+	checkParsedResult(t, "an optimal solution", m, result, err, STATUS_OPTIMAL, optimal)
+	result, err = ParseMiniZincOutput(m, []byte(solution(optimal)+"%%%mzn-stat: nodes=10\n"+solution(worse)))
+	checkParsedResult(t, "a solution at the time limit", m, result, err, STATUS_SAT, worse)
+	result, err = ParseMiniZincOutput(m, []byte("=====UNSATISFIABLE=====\n"))
+	checkParsedResult(t, "no solution", m, result, err, STATUS_UNSAT, nil)
+	result, err = ParseMiniZincOutput(m, []byte("=====UNKNOWN=====\n"))
+	checkParsedResult(t, "no solution found", m, result, err, STATUS_UNKNOWN, nil)
+
+	var unknownErr *UnknownVariableError
+	if _, err := ParseMiniZincOutput(m, []byte("Z = 1;\n"+solution(optimal))); !errors.As(err, &unknownErr) {
+		t.Errorf("Expected an unknown variable error, got %v", err)
+	}
+	var missingErr *MissingValuesError
+	if _, err := ParseMiniZincOutput(m, []byte("X_0_0 = 1;\n----------\n")); !errors.As(err, &missingErr) {
+		t.Errorf("Expected an error for missing values, got %v", err)
+	}
+	if _, err := ParseMiniZincOutput(m, []byte("")); !errors.Is(err, ErrNoMiniZincStatus) {
+		t.Errorf("Expected no status, got %v", err)
+	}
+
+	// The write-only solver writes MiniZinc to a .mzn file.
+	file := path.Join(t.TempDir(), "model.mzn")
+	if _, err := NewWriteOnlySolver(file).Solve(m); err != nil {
+		t.Errorf("Error writing the model: %v", err)
+	}
+	if out, err := os.ReadFile(file); err != nil || string(out) != b.String() {
+		t.Errorf("Expected the MiniZinc model in %s, got %v", file, err)
+	}
+}
+
+func TestLP(t *testing.T) {
+	flag.Parse()
+
+	m, optimal := solveGenerateModel(t)
+
+	var b strings.Builder
+	if err := m.WriteLP(&b); err != nil {
+		t.Fatalf("Error writing CPLEX-LP: %v", err)
+	}
+
+	// Parse the model back. Wrapped lines of a row start with two spaces.
+	sections := make(map[string][][]string)
+	section := ""
+	for _, line := range strings.Split(b.String(), "\n") {
+		switch {
+		case line == "" || strings.HasPrefix(line, "\\"):
+		case !strings.HasPrefix(line, " "):
+			section = line
+		case strings.HasPrefix(line, "  "):
+			rows := sections[section]
+			rows[len(rows)-1] = append(rows[len(rows)-1], strings.Fields(line)...)
+		default:
+			sections[section] = append(sections[section], strings.Fields(line))
+		}
+	}
+
+	var constraints []Constraint
+	for _, row := range sections["Subject To"] {
+		constraints = append(constraints, parseInfixConstraint(t, m, strings.TrimSuffix(row[0], ":"), row[1:]))
+	}
+	if obj := sections["Minimize"]; len(obj) != 1 || obj[0][0] != "obj:" {
+		t.Fatalf("Expected an objective, got %v", obj)
+	}
+	checkParsedModel(t, "CPLEX-LP", m, constraints, parseInfixTerms(t, m, sections["Minimize"][0][1:]))
+	if binaries := sections["Binaries"]; len(binaries) != 1 || !reflect.DeepEqual(binaries[0], m.Vars) {
+		t.Errorf("Expected binaries %v, got %v", m.Vars, binaries)
+	}
+	if !strings.HasSuffix(b.String(), "End\n") {
+		t.Errorf("Expected the end of the model")
+	}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if len(line) > lpLineLength {
+			t.Errorf("Expected lines of at most %d bytes, got %d", lpLineLength, len(line))
+		}
+	}
+
+	// The solution file of HiGHS, with the values as floating point numbers.
+	solution := func(status string, values []bool) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "Model status\n%s\n\n# Primal solution values\nFeasible\nObjective %d\n# Columns %d\n", status, m.Cost(values), len(m.Vars))
+		for v, name := range m.Vars {
+			value := "0"
+			if values[v] {
+				value = "0.9999999999"
+			}
+			fmt.Fprintf(&b, "%s %s\n", name, value)
+		}
+		fmt.Fprintf(&b, "# Rows %d\n", len(m.Constraints))
+		for _, c := range m.Constraints {
+			fmt.Fprintf(&b, "%s %d\n", c.Name, activity(c.Terms, values))
+		}
+		b.WriteString("\n# Dual solution values\nNone\n")
+		return b.String()
+	}
+
+	result, err := ParseHiGHSSolution(m, []byte(solution("Optimal", optimal)))
+	checkParsedResult(t, "an optimal solution", m, result, err, STATUS_OPTIMAL, optimal)
+	result, err = ParseHiGHSSolution(m, []byte(solution("Time limit reached", optimal)))
+	checkParsedResult(t, "a solution at the time limit", m, result, err, STATUS_SAT, optimal)
+	result, err = ParseHiGHSSolution(m, []byte("Model status\nInfeasible\n\n# Primal solution values\nNone\n"))
+	checkParsedResult(t, "no solution", m, result, err, STATUS_UNSAT, nil)
+	result, err = ParseHiGHSSolution(m, []byte("Model status\nTime limit reached\n\n# Primal solution values\nNone\n"))
+	checkParsedResult(t, "no solution found", m, result, err, STATUS_UNKNOWN, nil)
+
+	// Values at the time limit that violate a constraint are dropped.
+	infeasible := make([]bool, len(m.Vars))
+	result, err = ParseHiGHSSolution(m, []byte(solution("Time limit reached", infeasible)))
+	checkParsedResult(t, "an infeasible solution", m, result, err, STATUS_UNKNOWN, nil)
+
+	var invalidErr *InvalidValueError
+	if _, err := ParseHiGHSSolution(m, []byte(strings.Replace(solution("Optimal", optimal), "X_0_0 0\n", "X_0_0 0.5\n", 1))); !errors.As(err, &invalidErr) {
+		t.Errorf("Expected an invalid value error, got %v", err)
+	}
+	if _, err := ParseHiGHSSolution(m, []byte("")); !errors.Is(err, ErrNoHiGHSStatus) {
+		t.Errorf("Expected no status, got %v", err)
+	}
+}
+
+// wcnfFile is a weighted CNF parsed from WriteWCNF.
+type wcnfFile struct {
+	numVars int
+	vars    []string
+	offset  int
+	hard    [][]int
+	soft    []pbTerm
+}
+
+func parseWCNF(t *testing.T, text string) *wcnfFile {
+	t.Helper()
+
+	f := &wcnfFile{}
+	top, numClauses := 0, 0
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		words := strings.Fields(line)
+		switch {
+		case words[0] == "c" && len(words) == 3:
+			f.vars = append(f.vars, words[2])
+		case words[0] == "c":
+			fmt.Sscanf(line, "c Placement of %d services, cost offset %d", new(int), &f.offset)
+		case words[0] == "p":
+			fmt.Sscanf(line, "p wcnf %d %d %d", &f.numVars, &numClauses, &top)
+		default:
+			lits := make([]int, 0, len(words))
+			for _, word := range words {
+				n, err := strconv.Atoi(word)
+				if err != nil {
+					t.Fatalf("Invalid clause %s", line)
+				}
+				lits = append(lits, n)
+			}
+			if lits[len(lits)-1] != 0 {
+				t.Fatalf("Expected a clause ending with 0, got %s", line)
+			}
+			for _, lit := range lits[1 : len(lits)-1] {
+				if lit == 0 || abs(lit) > f.numVars {
+					t.Fatalf("Invalid literal %d in %s", lit, line)
+				}
+			}
+			if lits[0] == top {
+				f.hard = append(f.hard, lits[1:len(lits)-1])
+			} else if len(lits) == 3 {
+				f.soft = append(f.soft, pbTerm{lits[0], lits[1]})
+			} else {
+				t.Fatalf("Expected a soft unit clause, got %s", line)
+			}
+		}
+	}
+	if len(f.hard)+len(f.soft) != numClauses {
+		t.Errorf("Expected %d clauses, got %d", numClauses, len(f.hard)+len(f.soft))
+	}
+	total := 0
+	for _, c := range f.soft {
+		total += c.weight
+	}
+	if top <= total {
+		t.Errorf("Expected the weight of hard clauses to be over %d, got %d", total, top)
+	}
+	return f
+}
+
+// Check if the hard clauses have a solution with the given values of the variables of the model. Once the
+// values are set, the clauses of the decision diagrams are decided by unit propagation.
+func (f *wcnfFile) holds(values []bool) bool {
+	assign := make([]int, f.numVars+1)
+	for v, value := range values {
+		assign[v+1] = map[bool]int{false: -1, true: 1}[value]
+	}
+	value := func(lit int) int {
+		if lit < 0 {
+			return -assign[-lit]
+		}
+		return assign[lit]
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, clause := range f.hard {
+			unknown, last, sat := 0, 0, false
+			for _, lit := range clause {
+				switch value(lit) {
+				case 1:
+					sat = true
+				case 0:
+					unknown++
+					last = lit
+				}
+			}
+			switch {
+			case sat:
+			case unknown == 0:
+				return false
+			case unknown == 1:
+				assign[abs(last)] = map[bool]int{false: -1, true: 1}[last > 0]
+				changed = true
+			}
+		}
+	}
+
+	// The auxiliary variables left imply nothing.
+	for _, clause := range f.hard {
+		sat := false
+		for _, lit := range clause {
+			sat = sat || value(lit) == 1 || (value(lit) == 0 && lit < 0)
+		}
+		if !sat {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the cost of the given values of the variables of the model: the weight of the soft clauses they falsify.
+func (f *wcnfFile) cost(values []bool) int {
+	cost := f.offset
+	for _, c := range f.soft {
+		if values[abs(c.lit)-1] != (c.lit > 0) {
+			cost += c.weight
+		}
+	}
+	return cost
+}
+
+func TestWCNF(t *testing.T) {
+	flag.Parse()
+
+	m, optimal := solveGenerateModel(t)
+
+	var b strings.Builder
+	if err := m.WriteWCNF(&b); err != nil {
+		t.Fatalf("Error writing weighted CNF: %v", err)
+	}
+	f := parseWCNF(t, b.String())
+	if !reflect.DeepEqual(f.vars, m.Vars) {
+		t.Errorf("Expected variables %v, got %v", m.Vars, f.vars)
+	}
+
+	// The clauses have the solutions of the model, at the same cost: the optimal values, the values next to them,
+	// and random values.
+	check := func(name string, values []bool) {
+		if holds := f.holds(values); holds != (m.Check(values) == nil) {
+			t.Errorf("Expected the clauses to hold for %s: %v, got %v", name, m.Check(values) == nil, holds)
+		} else if holds && f.cost(values) != m.Cost(values) {
+			t.Errorf("Expected cost %d for %s, got %d", m.Cost(values), name, f.cost(values))
+		}
+	}
+	check("the optimal values", optimal)
+	for v := range m.Vars {
+		values := make([]bool, len(optimal))
+		copy(values, optimal)
+		values[v] = !values[v]
+		check(fmt.Sprintf("the optimal values with %s flipped", m.Vars[v]), values)
+	}
+	r := rand.New(rand.NewSource(1))
+	for k := 0; k < 1000; k++ {
+		values := make([]bool, len(m.Vars))
+		for v := range values {
+			values[v] = r.Intn(4) == 0
+		}
+		check(fmt.Sprintf("random values %d", k), values)
+	}
+
+	// Weighted constraints and negative costs need decision diagrams and an offset.
+	w := &Model{Offset: 5}
+	for k := 0; k < 6; k++ {
+		w.NewVar(fmt.Sprintf("V_%d", k))
+	}
+	w.Add("weighted", []Term{{3, 0}, {2, 1}, {2, 2}, {-1, 3}, {1, 4}}, LE, 4)
+	w.Add("equal", []Term{{1, 1}, {1, 3}, {2, 5}}, EQ, 2)
+	w.Add("at_least", []Term{{2, 0}, {1, 2}, {1, 4}, {1, 5}}, GE, 2)
+	w.Objective = []Term{{2, 0}, {-3, 3}, {1, 5}}
+	b.Reset()
+	if err := w.WriteWCNF(&b); err != nil {
+		t.Fatalf("Error writing weighted CNF: %v", err)
+	}
+	f = parseWCNF(t, b.String())
+	m = w
+	for k := 0; k < 1<<len(w.Vars); k++ {
+		values := make([]bool, len(w.Vars))
+		for v := range values {
+			values[v] = k&(1<<v) != 0
+		}
+		check(fmt.Sprintf("values %06b", k), values)
+	}
+
+	// The output of a MaxSAT solver, with the values of the auxiliary variables.
+	m, _ = solveGenerateModel(t)
+	literals, bits := "v", "v "
+	for v := range m.Vars {
+		if optimal[v] {
+			literals += fmt.Sprintf(" %d", v+1)
+			bits += "1"
+		} else {
+			literals += fmt.Sprintf(" %d", -(v + 1))
+			bits += "0"
+		}
+	}
+	literals += fmt.Sprintf(" %d", len(m.Vars)+1)
+	bits += "1"
+
+	result, err := ParseMaxSATOutput(m, []byte("c solved\no 5\no 3\ns OPTIMUM FOUND\n"+literals+"\n"))
+	checkParsedResult(t, "an optimal solution", m, result, err, STATUS_OPTIMAL, optimal)
+	result, err = ParseMaxSATOutput(m, []byte("o 3\ns OPTIMUM FOUND\n"+bits+"\n"))
+	checkParsedResult(t, "an optimal solution as a string", m, result, err, STATUS_OPTIMAL, optimal)
+	result, err = ParseMaxSATOutput(m, []byte("o 3\ns SATISFIABLE\n"+literals+"\n"))
+	checkParsedResult(t, "a solution at the time limit", m, result, err, STATUS_SAT, optimal)
+	result, err = ParseMaxSATOutput(m, []byte("s UNSATISFIABLE\n"))
+	checkParsedResult(t, "no solution", m, result, err, STATUS_UNSAT, nil)
+
+	var missingErr *MissingValuesError
+	if _, err := ParseMaxSATOutput(m, []byte("s OPTIMUM FOUND\nv 1 -2\n")); !errors.As(err, &missingErr) || len(missingErr.Missing) != len(m.Vars)-2 {
+		t.Errorf("Expected an error for missing values, got %v", err)
+	}
+	if _, err := ParseMaxSATOutput(m, []byte("o 3\n")); !errors.Is(err, ErrNoMaxSATStatus) {
+		t.Errorf("Expected no status, got %v", err)
+	}
+}
+
+func TestPortfolio(t *testing.T) {
+	flag.Parse()
+
+	m, _ := solveGenerateModel(t)
+
+	// The exact solver proves the optimal cost, and the portfolio kills z3 rather than waiting for it.
+	z := NewZ3Solver()
+	z.Path = fakeZ3(t, "exec sleep 10\n")
+	p := NewPortfolio(z, NewHeuristicSolver(), NewGoSolver())
+
+	var incumbents []Incumbent
+	start := time.Now()
+	race, err := p.Race(context.Background(), m, func(inc Incumbent) {
+		incumbents = append(incumbents, inc)
+	})
+	if err != nil || race.Status != STATUS_OPTIMAL || !race.Proven || race.Cost != 3 || race.Gap != 0 {
+		t.Fatalf("Expected a proven optimal cost 3, got %v %v", race, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected z3 to be stopped, took %v", elapsed)
+	}
+	if !errors.Is(race.Errors[0], context.Canceled) {
+		t.Errorf("Expected z3 to be canceled, got %v", race.Errors[0])
+	}
+	if race.Results[2] == nil || race.Results[2].Status != STATUS_OPTIMAL {
+		t.Errorf("Expected the exact solver to prove the cost optimal, got %v", race.Results[2])
+	}
+
+	// Every incumbent is a solution, cheaper than the one before it, and the last one is returned.
+	if len(incumbents) == 0 || incumbents[len(incumbents)-1].Cost != race.Cost || incumbents[len(incumbents)-1].Solver != race.Solver {
+		t.Fatalf("Expected incumbents ending with the result, got %v", incumbents)
+	}
+	for k, inc := range incumbents {
+		if err := m.Check(inc.Values); err != nil || m.Cost(inc.Values) != inc.Cost {
+			t.Errorf("Expected incumbent %d to be a solution of cost %d: %v", k, inc.Cost, err)
+		}
+		if k > 0 && inc.Cost >= incumbents[k-1].Cost {
+			t.Errorf("Expected incumbent %d to improve on %d, got %d", k, incumbents[k-1].Cost, inc.Cost)
+		}
+	}
+
+	// At the deadline, the best solution found is returned, without proof.
+	h := NewHeuristicSolver()
+	h.Iterations = math.MaxInt32
+	p = NewPortfolio(z, h)
+	p.Timeout = 200 * time.Millisecond
+	start = time.Now()
+	race, err = p.Race(context.Background(), m, nil)
+	if err != nil || race.Status != STATUS_SAT || race.Proven || race.Cost != 3 || race.Solver != 1 {
+		t.Errorf("Expected an unproven solution of cost 3 by the heuristic, got %v %v", race, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the race to stop at the deadline, took %v", elapsed)
+	}
+
+	// A pinned assignment that cannot support a policy has no solution.
+	policies, applEdges, services, sidecarCosts := createGenerateGraph()
+	unsat, _ := BuildModel(policies[2:], applEdges, services, map[string]int{"E": 0}, sidecarCosts)
+	if result, err := NewPortfolio(NewHeuristicSolver(), NewGoSolver()).Solve(unsat); err != nil || result.Status != STATUS_UNSAT {
+		t.Errorf("Expected no solution, got %v %v", result, err)
+	}
+
+	// The race fails only if every solver fails.
+	z = NewZ3Solver()
+	z.Path = path.Join(t.TempDir(), "missing")
+	if _, err := NewPortfolio(z).Solve(m); err == nil {
+		t.Errorf("Expected an error without z3")
+	}
+	if result, err := NewPortfolio(z, NewGoSolver()).Solve(m); err != nil || result.Status != STATUS_OPTIMAL {
+		t.Errorf("Expected an optimal solution without z3, got %v %v", result, err)
+	}
+}
+
+// Build the placement of the given values of the variables of a model, as the placement package does.
+func hintPlacement(m *Model, policies []xPlane.Policy, values []bool) *xPlane.Placement {
+	p := &xPlane.Placement{Sidecars: make(map[string]string)}
+	for i := range m.X {
+		p.Dataplanes = append(p.Dataplanes, fmt.Sprintf("dataplane-%d", i))
+	}
+	sidecars, impls := m.Decode(values)
+	for svc, i := range sidecars {
+		if i != -1 {
+			p.Sidecars[svc] = p.Dataplanes[i]
+		}
+	}
+	for j, policy := range policies {
+		side := xPlane.RECEIVER
+		if m.Y[j] != -1 && values[m.Y[j]] {
+			side = xPlane.SENDER
+		}
+		pp := xPlane.PolicyPlacement{Policy: j, Context: policy.GetContext()}
+		for _, svc := range impls[j] {
+			pp.EnforcementPoints = append(pp.EnforcementPoints, xPlane.EnforcementPoint{Service: svc, Side: side})
+		}
+		p.Policies = append(p.Policies, pp)
+	}
+	return p
+}
+
+func TestHint(t *testing.T) {
+	flag.Parse()
+
+	policies, _, _, _ := createGenerateGraph()
+	m, optimal := solveGenerateModel(t)
+	previous := hintPlacement(m, policies, optimal)
+
+	// The hint of a placement of the same policies is the values of its variables.
+	if err := m.SetHint(previous, policies); err != nil {
+		t.Fatalf("Error setting the hint: %v", err)
+	}
+	for v, value := range optimal {
+		if (m.Hint[v] == 1) != value || m.Hint[v] == -1 {
+			t.Errorf("Expected hint %v for %s, got %d", value, m.Vars[v], m.Hint[v])
+		}
+	}
+
+	// A complete hint is the first solution of the go solver, which proves it optimal.
+	var costs []int
+	result, err := NewGoSolver().SolveImproving(context.Background(), m, func(values []bool, cost int) {
+		costs = append(costs, cost)
+	})
+	if err != nil || result.Status != STATUS_OPTIMAL || !reflect.DeepEqual(result.Values, optimal) {
+		t.Errorf("Expected the hint to be optimal, got %v %v", result, err)
+	}
+	if !reflect.DeepEqual(costs, []int{3}) {
+		t.Errorf("Expected the hint as the only solution found, got costs %v", costs)
+	}
+
+	// It bounds the objective of z3, and its dataplanes are soft assertions.
+	var b strings.Builder
+	if err := m.WriteSMTLIB(&b); err != nil {
+		t.Fatalf("Error writing SMT-LIB: %v", err)
+	}
+	smt := b.String()
+	bound := fmt.Sprintf("(assert (<= %s %d))", smtSum(m, m.Objective), 3-m.Offset)
+	if !strings.Contains(smt, bound) {
+		t.Errorf("Expected the bound %s in SMT-LIB", bound)
+	}
+	if n := strings.Count(smt, "(assert-soft "); n != len(m.X)*len(m.Services) {
+		t.Errorf("Expected %d soft assertions, got %d", len(m.X)*len(m.Services), n)
+	}
+	if strings.Index(smt, "(minimize ") > strings.Index(smt, "(assert-soft ") {
+		t.Error("Expected the soft assertions after the objective")
+	}
+	b.Reset()
+	if err := m.WriteSMTLIBCore(&b); err != nil || strings.Contains(b.String(), "assert-soft") {
+		t.Errorf("Expected no hint in the unsat core script, got %v", err)
+	}
+
+	// The components of the reduced model keep the hint of their variables.
+	r := Reduce(m)
+	for c, sub := range r.Components {
+		for k, v := range r.vars[c] {
+			if r.fixed[v] == -1 && sub.Hint[k] != m.Hint[v] {
+				t.Errorf("Expected hint %d for %s in component %d, got %d", m.Hint[v], m.Vars[v], c, sub.Hint[k])
+			}
+		}
+	}
+	result, err = NewReducingSolver(NewGoSolver()).Solve(m)
+	if err != nil || result.Status != STATUS_OPTIMAL || result.Cost != 3 {
+		t.Errorf("Expected an optimal reduced solution of cost 3, got %v %v", result, err)
+	}
+
+	// Policies are matched by context: a policy missing from the placement has no hint, whatever the order.
+	previous.Policies = []xPlane.PolicyPlacement{previous.Policies[2], previous.Policies[0]}
+	if err := m.SetHint(previous, policies); err != nil {
+		t.Fatalf("Error setting the hint: %v", err)
+	}
+	for j := range m.E {
+		for _, v := range m.E[j] {
+			if v != -1 && (m.Hint[v] == -1) != (j == 1) {
+				t.Errorf("Unexpected hint %d for %s", m.Hint[v], m.Vars[v])
+			}
+		}
+	}
+	if _, ok := m.hintValues(); ok {
+		t.Error("Expected a partial hint")
+	}
+	for _, solver := range []Solver{NewGoSolver(), NewHeuristicSolver()} {
+		result, err := solver.Solve(m)
+		if err != nil || !result.HasSolution() || m.Check(result.Values) != nil || result.Cost < 3 {
+			t.Errorf("Expected a feasible solution from %s with a partial hint, got %v %v", solver.Name(), result, err)
+		}
+		if solver.Name() == "go" && (result.Status != STATUS_OPTIMAL || result.Cost != 3) {
+			t.Errorf("Expected the optimal cost 3 with a partial hint, got %v", result)
+		}
+	}
+
+	// A placement with other dataplanes is no hint.
+	previous.Dataplanes = previous.Dataplanes[:1]
+	if err := m.SetHint(previous, policies); err == nil {
+		t.Error("Expected an error for a placement with other dataplanes")
+	}
 }
@@ -0,0 +1,349 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Status is the outcome of solving a model.
+type Status string
+
+const (
+	// The values are an optimal solution.
+	STATUS_OPTIMAL Status = "optimal"
+	// The values are a solution, but may not be optimal.
+	STATUS_SAT Status = "sat"
+	// The model has no solution.
+	STATUS_UNSAT Status = "unsat"
+	// The solver stopped before finding a solution.
+	STATUS_UNKNOWN Status = "unknown"
+	// The model was written, but not solved.
+	STATUS_WRITTEN Status = "written"
+)
+
+// Result is the outcome of solving a model. Values has the value of every variable of the model
+// if the status is STATUS_OPTIMAL or STATUS_SAT.
+type Result struct {
+	Status Status
+	Values []bool
+	Cost   int
+
+	// Relative gap of the cost to the optimal cost: 0 if the values are optimal, and -1 if the optimal cost is unknown.
+	Gap float64
+
+	// Names of constraints that have no solution together, if the status is STATUS_UNSAT and the solver finds
+	// unsat cores. See FindCore for the other solvers.
+	Core []string
+
+	// File the model was written to, if the status is STATUS_WRITTEN.
+	File string
+}
+
+// HasSolution checks if the result has values for the variables.
+func (r *Result) HasSolution() bool {
+	return r.Status == STATUS_OPTIMAL || r.Status == STATUS_SAT
+}
+
+// Solver finds the values of the variables of a model that satisfy all constraints at the lowest cost.
+// Solvers are safe to run concurrently on different models.
+type Solver interface {
+	Name() string
+	Solve(m *Model) (*Result, error)
+
+	// SolveContext solves the model until the context is done. The solvers that search stop and return the
+	// best solution found so far; z3 is killed, and the error of the context is returned.
+	SolveContext(ctx context.Context, m *Model) (*Result, error)
+}
+
+// NewSolver returns the solver with the given name: "z3" for the z3 command line tool,
+// "go" for the embedded solver, "heuristic" for the heuristic solver of large graphs, "portfolio" to race them,
+// or "write" to write each model to a new temporary file and stop. The name of the file is in the result.
+func NewSolver(name string) (Solver, error) {
+	switch name {
+	case "z3":
+		return NewZ3Solver(), nil
+	case "go":
+		return NewGoSolver(), nil
+	case "heuristic":
+		return NewHeuristicSolver(), nil
+	case "portfolio":
+		return NewPortfolio(), nil
+	case "write":
+		return NewWriteOnlySolver(""), nil
+	default:
+		return nil, fmt.Errorf("unknown solver %s", name)
+	}
+}
+
+// Check if a done channel is closed. A nil channel is never closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Z3Solver runs the z3 command line tool on the model, written as SMT-LIB to its standard input.
+type Z3Solver struct {
+	// Path of the z3 binary.
+	Path string
+
+	// If set, the model is also written to this file, e.g. to inspect it. Concurrent runs must use different files.
+	File string
+
+	// Timeout of z3, or less if the context of the run has an earlier deadline.
+	Timeout time.Duration
+}
+
+func NewZ3Solver() *Z3Solver {
+	return &Z3Solver{
+		Path:    "z3",
+		Timeout: 60 * time.Second,
+	}
+}
+
+func (z *Z3Solver) Name() string {
+	return "z3"
+}
+
+func (z *Z3Solver) Solve(m *Model) (*Result, error) {
+	return z.SolveContext(context.Background(), m)
+}
+
+func (z *Z3Solver) SolveContext(ctx context.Context, m *Model) (*Result, error) {
+	// If services more than 500, then z3 will not be able to handle it.
+	if len(m.Services) > 500 {
+		return nil, errors.New("more than 500 services not supported")
+	}
+
+	var b bytes.Buffer
+	if err := m.WriteSMTLIB(&b); err != nil {
+		return nil, err
+	}
+	if z.File != "" {
+		if err := os.WriteFile(z.File, b.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := z.run(ctx, b.Bytes())
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result, parseErr := parseZ3Output(m, out)
+	if parseErr != nil {
+		if err != nil {
+			glog.Error("Error running z3 solver: ", err)
+			return nil, fmt.Errorf("running z3: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil, parseErr
+	}
+
+	if result.Status == STATUS_UNSAT {
+		if result.Core, err = z.unsatCore(ctx, m); err != nil {
+			glog.Warning("Error getting the unsat core: ", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Run z3 on a script given on its standard input, and return its output. z3 is killed when the context is done.
+func (z *Z3Solver) run(ctx context.Context, script []byte) ([]byte, error) {
+	timeout := z.Timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	cmd := exec.CommandContext(ctx, z.Path, "-in", "-smt2", fmt.Sprintf("-T:%d", max(1, int(math.Ceil(timeout.Seconds())))))
+	cmd.Stdin = bytes.NewReader(script)
+
+	// Do not wait for children of z3 that keep its output open once it is killed.
+	cmd.WaitDelay = time.Second
+	return cmd.CombinedOutput()
+}
+
+// Run z3 on the constraints of a model without solution, and get the names of the constraints of an unsat core.
+func (z *Z3Solver) unsatCore(ctx context.Context, m *Model) ([]string, error) {
+	var b bytes.Buffer
+	if err := m.WriteSMTLIBCore(&b); err != nil {
+		return nil, err
+	}
+
+	out, err := z.run(ctx, b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("running z3: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseZ3Core(out)
+}
+
+// Parse the output of z3 on a model written by WriteSMTLIBCore: unsat, followed by the names of the constraints
+// of the core.
+func parseZ3Core(out []byte) ([]string, error) {
+	o, err := parseZ3(out)
+	if err != nil {
+		return nil, err
+	}
+	if o.status != STATUS_UNSAT {
+		return nil, fmt.Errorf("expected unsat from z3, got %s", o.status)
+	}
+	if len(o.cores) == 0 {
+		if len(o.errors) > 0 {
+			return nil, o.errors[0]
+		}
+		return nil, errors.New("no unsat core in z3 output")
+	}
+	return o.cores[0], nil
+}
+
+// Parse the output of z3 on a model written by WriteSMTLIB: the status, and the value of every variable by name.
+// After a timeout, or if z3 gives up, the values are kept if they satisfy the constraints, but are not optimal.
+func parseZ3Output(m *Model, out []byte) (*Result, error) {
+	o, err := parseZ3(out)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case o.status == STATUS_UNSAT:
+		return &Result{Status: STATUS_UNSAT}, nil
+	case o.status == STATUS_UNKNOWN && len(o.values) == 0:
+		return &Result{Status: STATUS_UNKNOWN}, nil
+	case len(o.errors) > 0:
+		return nil, o.errors[0]
+	}
+
+	values, err := valuesByName(m, o.values)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.status == STATUS_SAT && !o.timeout {
+		return &Result{Status: STATUS_OPTIMAL, Values: values, Cost: m.Cost(values)}, nil
+	}
+	return feasibleResult(m, values, "z3"), nil
+}
+
+// Get the result of the values a solver stopped with before proving them optimal: a solution of unknown gap
+// if they satisfy the constraints, and STATUS_UNKNOWN otherwise.
+func feasibleResult(m *Model, values []bool, solver string) *Result {
+	if err := m.Check(values); err != nil {
+		glog.Warningf("Values of %s are not feasible: %v", solver, err)
+		return &Result{Status: STATUS_UNKNOWN}
+	}
+	return &Result{Status: STATUS_SAT, Values: values, Cost: m.Cost(values), Gap: -1}
+}
+
+// Get the values of the variables of a model from the values a solver prints by name, e.g. "1" or "true".
+func valuesByName(m *Model, named map[string]string) ([]bool, error) {
+	varMap := make(map[string]int, len(m.Vars))
+	for i, v := range m.Vars {
+		varMap[v] = i
+	}
+
+	values := make([]bool, len(m.Vars))
+	seen := make([]bool, len(m.Vars))
+	for name, value := range named {
+		v, ok := varMap[name]
+		if !ok {
+			return nil, &UnknownVariableError{Name: name}
+		}
+		var err error
+		if values[v], err = binaryValue(name, value); err != nil {
+			return nil, err
+		}
+		seen[v] = true
+	}
+
+	if err := checkSeen(m, seen); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Check that a solver printed a value for every variable of the model.
+func checkSeen(m *Model, seen []bool) error {
+	var missing []string
+	for v, ok := range seen {
+		if !ok {
+			missing = append(missing, m.Vars[v])
+		}
+	}
+	if missing != nil {
+		return &MissingValuesError{Missing: missing}
+	}
+	return nil
+}
+
+// Write the model to a file in the format of its extension: MiniZinc for .mzn, CPLEX-LP for .lp, weighted CNF
+// for .wcnf, and SMT-LIB otherwise.
+func writeModelFile(m *Model, filename string) error {
+	write := m.WriteSMTLIB
+	switch filepath.Ext(filename) {
+	case ".mzn":
+		write = m.WriteMiniZinc
+	case ".lp":
+		write = m.WriteLP
+	case ".wcnf":
+		write = m.WriteWCNF
+	}
+
+	var b bytes.Buffer
+	if err := write(&b); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, b.Bytes(), 0644)
+}
+
+// WriteOnlySolver writes the model to a file and stops, e.g. to solve it elsewhere. The model is written as
+// SMT-LIB, or in the format of the extension of the file for other solvers: .mzn, .lp or .wcnf.
+type WriteOnlySolver struct {
+	// If empty, every model is written as SMT-LIB to a new temporary file, so concurrent runs do not
+	// overwrite each other's models.
+	File string
+}
+
+func NewWriteOnlySolver(filename string) *WriteOnlySolver {
+	return &WriteOnlySolver{File: filename}
+}
+
+func (w *WriteOnlySolver) Name() string {
+	return "write"
+}
+
+func (w *WriteOnlySolver) Solve(m *Model) (*Result, error) {
+	return w.SolveContext(context.Background(), m)
+}
+
+func (w *WriteOnlySolver) SolveContext(ctx context.Context, m *Model) (*Result, error) {
+	filename := w.File
+	if filename == "" {
+		f, err := os.CreateTemp("", "z3_constraints_*.smt")
+		if err != nil {
+			return nil, err
+		}
+		filename = f.Name()
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeModelFile(m, filename); err != nil {
+		return nil, err
+	}
+	glog.Infof("Model written to %s", filename)
+
+	return &Result{Status: STATUS_WRITTEN, File: filename}, nil
+}
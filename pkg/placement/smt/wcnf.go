@@ -0,0 +1,267 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// At most this many literals of an at-most-one constraint are encoded pairwise, and more with a decision diagram.
+const maxPairwise = 6
+
+// WriteWCNF writes the model as weighted CNF for MaxSAT solvers, in the format of the MaxSAT evaluations up to 2021:
+// a "p wcnf" header with the weight of the hard clauses, followed by a clause per line. Variable k is the variable of
+// the model of index k-1, as listed in the comments, and the variables after them are auxiliary.
+//
+// Every constraint is a set of hard clauses: a clause or pairwise at-most-one clauses if it is one, and a decision
+// diagram of its partial sums otherwise. Every term of the objective is a soft clause, the negation of its variable
+// weighted by its coefficient, or the variable itself if the coefficient is negative. The cost of a solution is the
+// weight of the soft clauses it falsifies, plus the offset and the negative coefficients of the objective.
+func (m *Model) WriteWCNF(w io.Writer) error {
+	f := &wcnf{numVars: len(m.Vars)}
+	for _, c := range m.Constraints {
+		if c.Sense != GE {
+			f.addLE(c.Terms, c.RHS)
+		}
+		if c.Sense != LE {
+			negated := make([]Term, len(c.Terms))
+			for k, t := range c.Terms {
+				negated[k] = Term{-t.Coef, t.Var}
+			}
+			f.addLE(negated, -c.RHS)
+		}
+	}
+
+	offset := m.Offset
+	top := 1
+	var soft []pbTerm
+	for _, t := range m.Objective {
+		switch {
+		case t.Coef > 0:
+			soft = append(soft, pbTerm{t.Coef, -(t.Var + 1)})
+		case t.Coef < 0:
+			soft = append(soft, pbTerm{-t.Coef, t.Var + 1})
+			offset += t.Coef
+		}
+		top += abs(t.Coef)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "c Placement of %d services, cost offset %d\n", len(m.Services), offset)
+	for k, v := range m.Vars {
+		fmt.Fprintf(&b, "c %d %s\n", k+1, v)
+	}
+	fmt.Fprintf(&b, "p wcnf %d %d %d\n", f.numVars, len(f.hard)+len(soft), top)
+	for _, clause := range f.hard {
+		writeClause(&b, top, clause)
+	}
+	for _, t := range soft {
+		writeClause(&b, t.weight, []int{t.lit})
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeClause(b *strings.Builder, weight int, clause []int) {
+	b.WriteString(strconv.Itoa(weight))
+	for _, lit := range clause {
+		b.WriteString(" ")
+		b.WriteString(strconv.Itoa(lit))
+	}
+	b.WriteString(" 0\n")
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// pbTerm is a positive weight times a literal of the weighted CNF: a variable from 1, or its negation.
+type pbTerm struct {
+	weight int
+	lit    int
+}
+
+// wcnf is the hard clauses of a weighted CNF over the variables of a model, followed by auxiliary variables.
+type wcnf struct {
+	numVars int
+	hard    [][]int
+}
+
+func (f *wcnf) newVar() int {
+	f.numVars++
+	return f.numVars
+}
+
+// Add the clauses of a constraint sum <= rhs over the variables of the model.
+func (f *wcnf) addLE(terms []Term, rhs int) {
+	list := make([]pbTerm, 0, len(terms))
+	for _, t := range terms {
+		switch {
+		case t.Coef > 0:
+			list = append(list, pbTerm{t.Coef, t.Var + 1})
+		case t.Coef < 0:
+			// c x = c + |c| (1 - x), a positive weight on the negation of x.
+			list = append(list, pbTerm{-t.Coef, -(t.Var + 1)})
+			rhs -= t.Coef
+		}
+	}
+	f.addPB(list, rhs)
+}
+
+// Add the clauses of a pseudo-boolean constraint sum <= k with positive weights.
+func (f *wcnf) addPB(list []pbTerm, k int) {
+	if k < 0 {
+		// The empty clause, since no values satisfy the constraint.
+		f.hard = append(f.hard, []int{})
+		return
+	}
+
+	// A literal that weighs more than the bound is false.
+	rest := make([]pbTerm, 0, len(list))
+	sum := 0
+	for _, t := range list {
+		if t.weight > k {
+			f.hard = append(f.hard, []int{-t.lit})
+			continue
+		}
+		rest = append(rest, t)
+		sum += t.weight
+	}
+	if sum <= k {
+		return
+	}
+	sort.SliceStable(rest, func(a, b int) bool { return rest[a].weight > rest[b].weight })
+	n := len(rest)
+
+	switch {
+	case sum-rest[n-1].weight <= k:
+		// Not all the literals are true.
+		clause := make([]int, 0, n)
+		for _, t := range rest {
+			clause = append(clause, -t.lit)
+		}
+		f.hard = append(f.hard, clause)
+
+	case rest[n-1].weight+rest[n-2].weight > k && n <= maxPairwise:
+		// At most one literal is true.
+		for a := range rest {
+			for b := a + 1; b < n; b++ {
+				f.hard = append(f.hard, []int{-rest[a].lit, -rest[b].lit})
+			}
+		}
+
+	default:
+		f.addDecisionDiagram(rest, k)
+	}
+}
+
+// Add the clauses of a pseudo-boolean constraint with a decision diagram: node (a, k) is an auxiliary variable
+// that implies that the literals from a on weigh at most k. It implies the node of the rest with k less the weight
+// of literal a if the literal is true, and the node of the rest with k otherwise. Nodes are shared by bound.
+func (f *wcnf) addDecisionDiagram(list []pbTerm, k int) {
+	suffix := make([]int, len(list)+1)
+	for a := len(list) - 1; a >= 0; a-- {
+		suffix[a] = suffix[a+1] + list[a].weight
+	}
+
+	type node struct{ a, k int }
+	nodes := make(map[node]int)
+
+	// Get the variable of a node with k >= 0, or 0 if the literals from a always weigh at most k.
+	var build func(a, k int) int
+	build = func(a, k int) int {
+		if suffix[a] <= k {
+			return 0
+		}
+		if v, ok := nodes[node{a, k}]; ok {
+			return v
+		}
+		v := f.newVar()
+		nodes[node{a, k}] = v
+
+		lit := list[a].lit
+		if k < list[a].weight {
+			f.hard = append(f.hard, []int{-v, -lit})
+		} else if hi := build(a+1, k-list[a].weight); hi != 0 {
+			f.hard = append(f.hard, []int{-v, -lit, hi})
+		}
+		if lo := build(a+1, k); lo != 0 {
+			f.hard = append(f.hard, []int{-v, lo})
+		}
+		return v
+	}
+
+	if root := build(0, k); root != 0 {
+		f.hard = append(f.hard, []int{root})
+	}
+}
+
+// ErrNoMaxSATStatus is returned when the output of a MaxSAT solver has no "s" line.
+var ErrNoMaxSATStatus = errors.New("no status in MaxSAT output")
+
+// ParseMaxSATOutput parses the output of a MaxSAT solver on a model written by WriteWCNF: an "s" line with the
+// status, and "v" lines with the values of the variables, either as literals ("v 1 -2 3") or, since the MaxSAT
+// evaluation of 2022, as a string of 0 and 1 ("v 101"). The values of the auxiliary variables are ignored.
+// The values are kept if the solver stops before proving them optimal and they satisfy the constraints.
+func ParseMaxSATOutput(m *Model, out []byte) (*Result, error) {
+	status := ""
+	var words []string
+	for _, line := range strings.Split(string(out), "\n") {
+		kind, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch kind {
+		case "s":
+			status = strings.TrimSpace(rest)
+		case "v":
+			words = append(words, strings.Fields(rest)...)
+		default:
+			// A comment, or an "o" line with the cost of a solution.
+		}
+	}
+
+	switch status {
+	case "":
+		return nil, ErrNoMaxSATStatus
+	case "UNSATISFIABLE":
+		return &Result{Status: STATUS_UNSAT}, nil
+	case "UNKNOWN":
+		return &Result{Status: STATUS_UNKNOWN}, nil
+	case "OPTIMUM FOUND", "SATISFIABLE":
+	default:
+		return nil, fmt.Errorf("unknown status %s in MaxSAT output", status)
+	}
+
+	values := make([]bool, len(m.Vars))
+	seen := make([]bool, len(m.Vars))
+	if len(words) == 1 && len(words[0]) >= len(m.Vars) && strings.Trim(words[0], "01") == "" {
+		for v := range m.Vars {
+			values[v] = words[0][v] == '1'
+			seen[v] = true
+		}
+	} else {
+		for _, word := range words {
+			lit, err := strconv.Atoi(word)
+			if err != nil {
+				return nil, &InvalidValueError{Name: "literal", Value: word}
+			}
+			if v := abs(lit) - 1; v >= 0 && v < len(m.Vars) {
+				values[v] = lit > 0
+				seen[v] = true
+			}
+		}
+	}
+	if err := checkSeen(m, seen); err != nil {
+		return nil, err
+	}
+
+	if status == "OPTIMUM FOUND" {
+		return &Result{Status: STATUS_OPTIMAL, Values: values, Cost: m.Cost(values)}, nil
+	}
+	return feasibleResult(m, values, "the MaxSAT solver"), nil
+}
@@ -0,0 +1,177 @@
+package placement
+
+import (
+	"fmt"
+	"strings"
+	xp "xPlane"
+
+	"golang.org/x/exp/slices"
+)
+
+// Violation is a hop of a request path a policy applies to, where the policy is not enforced exactly once.
+type Violation struct {
+	// A request path the policy applies to, whose last hop is from the sender to the receiver.
+	Path     []string
+	Sender   string
+	Receiver string
+
+	Reason string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s->%s (path %s): %s", v.Sender, v.Receiver, strings.Join(v.Path, "->"), v.Reason)
+}
+
+// Verify checks a placement against the graph, without the model of the solver that computed it. It walks the
+// request paths of the graph every policy applies to, hop by hop from the services its context starts at, and checks
+// that the last hop of every path has exactly one enforcement point of the policy: the sender or the receiver, on a
+// side the functions of the policy allow, and running a dataplane that supports all of them. The side of every
+// enforcement point is the one recorded in the placement.
+//
+// Returns the violations of every policy, indexed like policies, or nil if the placement enforces all of them.
+func Verify(p *xp.Placement, policies []xp.Policy, g *xp.ApplicationGraph) ([][]Violation, error) {
+	applEdges := g.GetEdges()
+
+	var violations [][]Violation
+	for j := range policies {
+		context, err := policies[j].GetContextExpr()
+		if err != nil {
+			return nil, fmt.Errorf("policy %d: %w", j, err)
+		}
+
+		// A trailing wildcard stands for the callees of the previous hop, e.g. `A->*` applies to the requests
+		// sent by A, so it only matches a single service.
+		if last := &context.Hops[len(context.Hops)-1]; len(context.Hops) > 1 && last.Kind == xp.HOP_ANY_PATH {
+			last.Kind = xp.HOP_ANY
+		}
+		context.WithLabels(g.GetLabels)
+
+		list := verifyPolicy(p, j, &policies[j], matchedPaths(context, applEdges))
+		if len(list) == 0 {
+			continue
+		}
+		if violations == nil {
+			violations = make([][]Violation, len(policies))
+		}
+		violations[j] = list
+	}
+
+	return violations, nil
+}
+
+// Find the request paths of the graph matched by the context, stepping the context through every callee and
+// pruning the paths it cannot match anymore. A path that reaches a service in a state of the context it was
+// already reached in matches the same hops from there, so it is not walked further, and the walk covers the
+// paths of any length of cyclic graphs. Every matched hop is the last hop of at least one of the paths.
+func matchedPaths(context *xp.PolicyContext, applEdges map[string][]string) [][]string {
+	var matched [][]string
+	visited := make(map[[2]string]bool)
+
+	var walk func(path []string, state *xp.ContextState)
+	walk = func(path []string, state *xp.ContextState) {
+		for _, callee := range applEdges[path[len(path)-1]] {
+			next := state.Step(callee)
+			if !next.Live() {
+				continue
+			}
+			if next.Accepts() {
+				matched = append(matched, append(append([]string{}, path...), callee))
+			}
+			if n := [2]string{callee, next.Key()}; !visited[n] {
+				visited[n] = true
+				walk(append(path, callee), next)
+			}
+		}
+	}
+
+	for _, svc := range context.StartServices(applEdges) {
+		state := context.Start().Step(svc)
+		if n := [2]string{svc, state.Key()}; state.Live() && !visited[n] {
+			visited[n] = true
+			walk([]string{svc}, state)
+		}
+	}
+
+	return matched
+}
+
+// Check the enforcement points of policy j on the last hop of every path.
+func verifyPolicy(p *xp.Placement, j int, policy *xp.Policy, paths [][]string) []Violation {
+	// Dataplane of every enforcement point of the policy, by service and side.
+	type end struct {
+		svc  string
+		side xp.ConstraintType
+	}
+	points := make(map[end]string)
+	for _, pp := range p.Policies {
+		if pp.Policy != j {
+			continue
+		}
+		for _, ep := range pp.EnforcementPoints {
+			points[end{ep.Service, ep.Side}] = ep.Dataplane
+		}
+	}
+
+	// Why the enforcement point at the given end of a hop does not enforce the policy, or "" if it does.
+	problem := func(e end) string {
+		if constraint := policy.GetConstraint(); constraint != xp.SENDER_RECEIVER && constraint != e.side {
+			return fmt.Sprintf("%s enforces it on the %s side, but it must be enforced on the %s side", e.svc, e.side, constraint)
+		}
+		dataplane, ok := p.Sidecars[e.svc]
+		if !ok {
+			return fmt.Sprintf("%s enforces it but has no sidecar", e.svc)
+		}
+		if dataplane != points[e] {
+			return fmt.Sprintf("%s enforces it with %s but runs %s", e.svc, points[e], dataplane)
+		}
+
+		i := p.GetDataplaneIndex(e.svc)
+		var unsupported []string
+		for _, f := range policy.GetFunctions() {
+			if !slices.Contains(f.GetDataplanes(), i) {
+				unsupported = append(unsupported, f.GetFunctionName())
+			}
+		}
+		if len(unsupported) > 0 {
+			return fmt.Sprintf("%s runs %s, which does not support %s", e.svc, dataplane, strings.Join(unsupported, ", "))
+		}
+		return ""
+	}
+
+	var violations []Violation
+	seen := make(map[[2]string]bool)
+	for _, path := range paths {
+		sender, receiver := path[len(path)-2], path[len(path)-1]
+		if seen[[2]string{sender, receiver}] {
+			continue
+		}
+		seen[[2]string{sender, receiver}] = true
+
+		var enforced, problems []string
+		for _, e := range []end{{sender, xp.SENDER}, {receiver, xp.RECEIVER}} {
+			if _, ok := points[e]; !ok {
+				continue
+			}
+			if reason := problem(e); reason != "" {
+				problems = append(problems, reason)
+			} else {
+				enforced = append(enforced, e.svc)
+			}
+		}
+
+		v := Violation{Path: path, Sender: sender, Receiver: receiver}
+		switch {
+		case len(enforced) == 0 && len(problems) == 0:
+			v.Reason = "not enforced"
+		case len(enforced) == 0:
+			v.Reason = "not enforced: " + strings.Join(problems, "; ")
+		case len(enforced) == 2:
+			v.Reason = "enforced twice, by the sender and the receiver"
+		default:
+			continue
+		}
+		violations = append(violations, v)
+	}
+
+	return violations
+}
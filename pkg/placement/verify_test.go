@@ -0,0 +1,220 @@
+package placement
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+	xp "xPlane"
+	"xPlane/pkg/placement/smt"
+)
+
+// Check with Verify that a placement enforces every policy.
+func verifyPlacement(t *testing.T, name string, p *xp.Placement, policies []xp.Policy, applEdges map[string][]string, services []string) {
+	t.Helper()
+
+	g, err := xp.ApplicationGraphFromEdges(applEdges, services)
+	if err != nil {
+		t.Fatalf("Invalid graph %s: %v", name, err)
+	}
+	violations, err := Verify(p, policies, g)
+	if err != nil {
+		t.Fatalf("Error verifying the placement of %s: %v", name, err)
+	}
+	for j, list := range violations {
+		for _, v := range list {
+			t.Errorf("Policy %d %v is not enforced by the placement of %s: %s", j, policies[j].GetContext(), name, v)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	flag.Parse()
+
+	applEdges := map[string][]string{"A": {"B", "C"}, "B": {"C"}}
+	services := []string{"A", "B", "C"}
+	g, err := xp.ApplicationGraphFromEdges(applEdges, services)
+	if err != nil {
+		t.Fatalf("Invalid graph: %v", err)
+	}
+
+	policies := []xp.Policy{
+		xp.CreatePolicy([]string{"A", "B"}, []xp.PolicyFunction{
+			xp.CreateNewPolicyFunction("set_header", xp.SENDER, []int{0}, true)}),
+		xp.CreatePolicy([]string{"*", "C"}, []xp.PolicyFunction{
+			xp.CreateNewPolicyFunction("count", xp.SENDER_RECEIVER, []int{0, 1}, false)})}
+	dataplanes := createDataplanes([]int{2, 1})
+
+	placement, err := GetPlacementWithSolver(smt.NewGoSolver(), policies, applEdges, services, map[string]int{}, dataplanes)
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	verifyPlacement(t, "the solver", placement, policies, applEdges, services)
+
+	sidecars := map[string]string{"A": "dataplane-0", "B": "dataplane-1", "C": "dataplane-1"}
+	sender := func(svc string, dataplane string) xp.EnforcementPoint {
+		return xp.EnforcementPoint{Service: svc, Side: xp.SENDER, Dataplane: dataplane}
+	}
+	receiver := func(svc string, dataplane string) xp.EnforcementPoint {
+		return xp.EnforcementPoint{Service: svc, Side: xp.RECEIVER, Dataplane: dataplane}
+	}
+
+	tests := []struct {
+		name     string
+		sidecars map[string]string
+		points   [][]xp.EnforcementPoint
+
+		// Expected hops in violation of every policy, and a part of their reason.
+		expected [][]string
+		reason   string
+	}{
+		{
+			name:     "correct",
+			sidecars: sidecars,
+			points:   [][]xp.EnforcementPoint{{sender("A", "dataplane-0")}, {receiver("C", "dataplane-1")}},
+		},
+		{
+			name:     "not enforced",
+			sidecars: sidecars,
+			points:   [][]xp.EnforcementPoint{{}, {sender("A", "dataplane-0")}},
+			expected: [][]string{{"A->B"}, {"B->C"}},
+			reason:   "not enforced",
+		},
+		{
+			name:     "enforced twice",
+			sidecars: sidecars,
+			points:   [][]xp.EnforcementPoint{{sender("A", "dataplane-0")}, {sender("A", "dataplane-0"), sender("B", "dataplane-1"), receiver("C", "dataplane-1")}},
+			expected: [][]string{nil, {"B->C", "A->C"}},
+			reason:   "enforced twice",
+		},
+		{
+			name:     "unsupported dataplane",
+			sidecars: map[string]string{"A": "dataplane-1", "C": "dataplane-1"},
+			points:   [][]xp.EnforcementPoint{{sender("A", "dataplane-1")}, {receiver("C", "dataplane-1")}},
+			expected: [][]string{{"A->B"}, nil},
+			reason:   "A runs dataplane-1, which does not support set_header",
+		},
+		{
+			name:     "wrong side",
+			sidecars: sidecars,
+			points:   [][]xp.EnforcementPoint{{receiver("B", "dataplane-1")}, {receiver("C", "dataplane-1")}},
+			expected: [][]string{{"A->B"}, nil},
+			reason:   "B enforces it on the receiver side, but it must be enforced on the sender side",
+		},
+		{
+			name:     "no sidecar",
+			sidecars: map[string]string{"A": "dataplane-0"},
+			points:   [][]xp.EnforcementPoint{{sender("A", "dataplane-0")}, {receiver("C", "dataplane-1")}},
+			expected: [][]string{nil, {"B->C", "A->C"}},
+			reason:   "C enforces it but has no sidecar",
+		},
+	}
+
+	for _, test := range tests {
+		p := &xp.Placement{Dataplanes: []string{"dataplane-0", "dataplane-1"}, Sidecars: test.sidecars}
+		for j, points := range test.points {
+			p.Policies = append(p.Policies, xp.PolicyPlacement{Policy: j, Context: policies[j].GetContext(), EnforcementPoints: points})
+		}
+
+		violations, err := Verify(p, policies, g)
+		if err != nil {
+			t.Fatalf("Error verifying %s: %v", test.name, err)
+		}
+		if test.expected == nil {
+			if violations != nil {
+				t.Errorf("Expected no violations for %s, got %v", test.name, violations)
+			}
+			continue
+		}
+		if len(violations) != len(policies) {
+			t.Fatalf("Expected violations for %s, got %v", test.name, violations)
+		}
+
+		for j, list := range violations {
+			var hops []string
+			for _, v := range list {
+				hops = append(hops, v.Sender+"->"+v.Receiver)
+				if !strings.Contains(v.Reason, test.reason) {
+					t.Errorf("Expected a violation of policy %d for %s because %s, got %s", j, test.name, test.reason, v)
+				}
+			}
+			if strings.Join(hops, " ") != strings.Join(test.expected[j], " ") {
+				t.Errorf("Expected violations of policy %d for %s on %v, got %v", j, test.name, test.expected[j], list)
+			}
+		}
+	}
+}
+
+func TestVerifyLabels(t *testing.T) {
+	flag.Parse()
+
+	g := labeledGraph()
+	policies := []xp.Policy{xp.CreatePolicy([]string{"{app=fe}", "search"}, []xp.PolicyFunction{
+		xp.CreateNewPolicyFunction("set_header", xp.SENDER, []int{0}, true)})}
+
+	// Only the requests of the frontends must be enforced, not those of admin.
+	p := &xp.Placement{
+		Dataplanes: []string{"dataplane-0"},
+		Sidecars:   map[string]string{"fe-web": "dataplane-0"},
+		Policies: []xp.PolicyPlacement{{Policy: 0, Context: policies[0].GetContext(), EnforcementPoints: []xp.EnforcementPoint{
+			{Service: "fe-web", Side: xp.SENDER, Dataplane: "dataplane-0"}}}},
+	}
+	violations, err := Verify(p, policies, g)
+	if err != nil {
+		t.Fatalf("Error verifying the placement: %v", err)
+	}
+	if len(violations) != 1 || len(violations[0]) != 1 || violations[0][0].Sender != "fe-mobile" || violations[0][0].Reason != "not enforced" {
+		t.Errorf("Expected fe-mobile->search not to be enforced, got %v", violations)
+	}
+}
+
+func TestVerifyCyclicGraph(t *testing.T) {
+	flag.Parse()
+
+	// Home-timeline and social-graph call each other.
+	applEdges := map[string][]string{"nginx": {"home-timeline"}, "home-timeline": {"social-graph", "post-storage"}, "social-graph": {"home-timeline", "user"}}
+	g, err := xp.ApplicationGraphFromEdges(applEdges, nil)
+	if err != nil {
+		t.Fatalf("Invalid graph: %v", err)
+	}
+	countFunc := xp.CreateNewPolicyFunction("count", xp.RECEIVER, []int{0}, false)
+	policies := []xp.Policy{xp.CreatePolicy([]string{"nginx", "*", "post-storage"}, []xp.PolicyFunction{countFunc})}
+
+	placement, err := GetPlacementForGraph(policies, g, map[string]int{}, createDataplanes([]int{1}), Options{Solver: smt.NewGoSolver()})
+	if err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+	verifyPlacement(t, "the cyclic graph", placement, policies, applEdges, g.GetServices())
+
+	// The requests through the cycle reach post-storage too.
+	placement.Policies[0].EnforcementPoints = nil
+	violations, err := Verify(placement, policies, g)
+	if err != nil {
+		t.Fatalf("Error verifying the placement: %v", err)
+	}
+	if len(violations) != 1 || len(violations[0]) != 1 || violations[0][0].Sender != "home-timeline" || violations[0][0].Receiver != "post-storage" {
+		t.Errorf("Expected home-timeline->post-storage not to be enforced, got %v", violations)
+	}
+
+	// A hop at the end of a long chain after the cycle is verified too.
+	last := "nginx"
+	for k := 0; k < 20; k++ {
+		svc := fmt.Sprintf("storage-%d", k)
+		applEdges[last] = append(applEdges[last], svc)
+		last = svc
+	}
+	applEdges["social-graph"] = append(applEdges["social-graph"], "storage-0")
+	g, err = xp.ApplicationGraphFromEdges(applEdges, nil)
+	if err != nil {
+		t.Fatalf("Invalid graph: %v", err)
+	}
+	policies = []xp.Policy{xp.CreatePolicy([]string{"nginx", "*", last}, []xp.PolicyFunction{countFunc})}
+	p := &xp.Placement{Dataplanes: []string{"dataplane-0"}, Sidecars: map[string]string{}, Policies: []xp.PolicyPlacement{{Policy: 0, Context: policies[0].GetContext()}}}
+	violations, err = Verify(p, policies, g)
+	if err != nil {
+		t.Fatalf("Error verifying the placement: %v", err)
+	}
+	if len(violations) != 1 || len(violations[0]) != 1 || violations[0][0].Receiver != last || violations[0][0].Reason != "not enforced" {
+		t.Errorf("Expected the hop to %s not to be enforced, got %v", last, violations)
+	}
+}
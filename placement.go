@@ -0,0 +1,255 @@
+package xPlane
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnforcementPoint is a service that enforces a policy, the side of the request it runs on,
+// and the dataplane that runs it.
+type EnforcementPoint struct {
+	Service   string         `json:"service" yaml:"service"`
+	Side      ConstraintType `json:"side" yaml:"side"`
+	Dataplane string         `json:"dataplane" yaml:"dataplane"`
+}
+
+// PolicyPlacement lists where a policy is enforced. Policy is the index of the policy in the solver input.
+type PolicyPlacement struct {
+	Policy            int                `json:"policy" yaml:"policy"`
+	Context           []string           `json:"context" yaml:"context"`
+	EnforcementPoints []EnforcementPoint `json:"enforcementPoints" yaml:"enforcementPoints"`
+}
+
+// SolverStats describes the solver run that produced a placement.
+type SolverStats struct {
+	Solver       string `json:"solver" yaml:"solver"`
+	Status       string `json:"status" yaml:"status"`
+	Optimal      bool   `json:"optimal" yaml:"optimal"`
+	DurationMs   int64  `json:"durationMs" yaml:"durationMs"`
+	NumVariables int    `json:"numVariables" yaml:"numVariables"`
+
+	// Relative gap of the cost to the optimal cost: 0 if optimal, and -1 if the optimal cost is unknown.
+	Gap float64 `json:"gap" yaml:"gap"`
+
+	// Number of services whose dataplane changed from the deployed placement, for incremental placements.
+	Changes int `json:"changes,omitempty" yaml:"changes,omitempty"`
+
+	// Largest number of sidecars a request crosses, and the request path that crosses them.
+	MaxProxies int      `json:"maxProxies" yaml:"maxProxies"`
+	WorstPath  []string `json:"worstPath,omitempty" yaml:"worstPath,omitempty"`
+}
+
+// Placement is the result of placing policies on an application graph.
+type Placement struct {
+	// Names of the dataplanes, in order of their index.
+	Dataplanes []string `json:"dataplanes" yaml:"dataplanes"`
+
+	// Dataplane assigned to every service that has a sidecar.
+	Sidecars map[string]string `json:"sidecars" yaml:"sidecars"`
+
+	// Enforcement points of every policy, in the order of the solver input.
+	Policies []PolicyPlacement `json:"policies" yaml:"policies"`
+
+	// Total cost of the sidecars.
+	Cost int `json:"cost" yaml:"cost"`
+
+	Stats SolverStats `json:"stats" yaml:"stats"`
+
+	// Fingerprint of the solver input, see ComputeFingerprint.
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+}
+
+// Get the index of the dataplane assigned to a service, or -1 if it has no sidecar.
+func (p *Placement) GetDataplaneIndex(service string) int {
+	name, ok := p.Sidecars[service]
+	if !ok {
+		return -1
+	}
+
+	for i, d := range p.Dataplanes {
+		if d == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get the dataplane index of every service that has a sidecar, e.g. to pin them in the next placement,
+// or as the deployed placement to migrate from.
+func (p *Placement) GetSidecarAssignment() map[string]int {
+	assignment := make(map[string]int)
+	for svc := range p.Sidecars {
+		if i := p.GetDataplaneIndex(svc); i != -1 {
+			assignment[svc] = i
+		}
+	}
+	return assignment
+}
+
+// Count the sidecars a request crosses on a path through the application, see GetRequestPaths.
+func (p *Placement) CountProxies(path []string) int {
+	count := 0
+	for _, svc := range path {
+		if _, ok := p.Sidecars[svc]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// Get the services implementing each policy.
+func (p *Placement) GetImplementations() [][]string {
+	impls := make([][]string, len(p.Policies))
+	for j, pp := range p.Policies {
+		for _, ep := range pp.EnforcementPoints {
+			impls[j] = append(impls[j], ep.Service)
+		}
+	}
+	return impls
+}
+
+func (p *Placement) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+func (p *Placement) ToYAML() ([]byte, error) {
+	return yaml.Marshal(p)
+}
+
+func ParsePlacementJSON(b []byte) (*Placement, error) {
+	var p Placement
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func ParsePlacementYAML(b []byte) (*Placement, error) {
+	var p Placement
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Write the placement to a file. Files ending in `.yaml` or `.yml` are written as YAML, others as JSON.
+func WritePlacement(p *Placement, filename string) error {
+	var b []byte
+	var err error
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".yaml" || ext == ".yml" {
+		b, err = p.ToYAML()
+	} else {
+		b, err = p.ToJSON()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, b, 0644)
+}
+
+// Read a placement from a file written by WritePlacement.
+func ReadPlacement(filename string) (*Placement, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".yaml" || ext == ".yml" {
+		return ParsePlacementYAML(b)
+	}
+	return ParsePlacementJSON(b)
+}
+
+// ComputeFingerprint hashes the input of a placement, so that a placement can be matched to the
+// policies, graph and dataplanes it was computed for. The order of services, edges and map keys does not matter.
+//
+// The other inputs that change the placement, e.g. the cost model, the capacity of the cluster, the latency budget
+// or the deployed placement to migrate from, are hashed by their JSON encoding, in order. The attributes of the
+// services and edges are not hashed: see ComputeGraphFingerprint for placements on an ApplicationGraph.
+func ComputeFingerprint(policies []Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []Dataplane, inputs ...interface{}) string {
+	h := sha256.New()
+
+	sortedServices := append([]string{}, services...)
+	sort.Strings(sortedServices)
+	fmt.Fprintf(h, "services:%s\n", strings.Join(sortedServices, ","))
+
+	sources := make([]string, 0, len(applGraph))
+	for svc := range applGraph {
+		sources = append(sources, svc)
+	}
+	sort.Strings(sources)
+	for _, svc := range sources {
+		edges := append([]string{}, applGraph[svc]...)
+		sort.Strings(edges)
+		fmt.Fprintf(h, "edges:%s:%s\n", svc, strings.Join(edges, ","))
+	}
+
+	for _, p := range policies {
+		fmt.Fprintf(h, "policy:%s\n", strings.Join(p.GetContext(), ","))
+		for _, pf := range p.GetFunctions() {
+			fmt.Fprintf(h, "function:%s:%d:%t:%v\n", pf.GetFunctionName(), pf.GetConstraint(), pf.GetMutability(), pf.GetDataplanes())
+		}
+	}
+
+	assigned := make([]string, 0, len(sidecarAssignments))
+	for svc := range sidecarAssignments {
+		assigned = append(assigned, svc)
+	}
+	sort.Strings(assigned)
+	for _, svc := range assigned {
+		fmt.Fprintf(h, "assignment:%s:%d\n", svc, sidecarAssignments[svc])
+	}
+
+	for _, d := range dataplanes {
+		fmt.Fprintf(h, "dataplane:%d:%s:%d:%v\n", d.GetIndex(), d.GetName(), d.GetCost(), d.GetProtocols())
+		if r := d.GetResources(); r != (ResourceProfile{}) {
+			fmt.Fprintf(h, "resources:%d:%g:%g:%g\n", d.GetIndex(), r.CPUPerRequest, r.CPUPerInstance, r.MemoryPerInstance)
+		}
+	}
+
+	for k, input := range inputs {
+		b, err := json.Marshal(input)
+		if err != nil {
+			b = []byte(fmt.Sprintf("%v", input))
+		}
+		fmt.Fprintf(h, "input:%d:%s\n", k, b)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ComputeGraphFingerprint hashes the input of a placement on an application graph: the input hashed by
+// ComputeFingerprint, and the attributes of every service and edge, e.g. labels, protocols, replicas and
+// request rates.
+func ComputeGraphFingerprint(policies []Policy, g *ApplicationGraph, sidecarAssignments map[string]int, dataplanes []Dataplane, inputs ...interface{}) string {
+	var attrs []string
+	services := g.GetServices()
+	for _, svc := range services {
+		a, _ := g.GetServiceAttributes(svc)
+		b, _ := json.Marshal(a)
+		attrs = append(attrs, fmt.Sprintf("service:%s:%s", svc, b))
+	}
+	for _, from := range g.sortedSources() {
+		callees := append([]string{}, g.GetCallees(from)...)
+		sort.Strings(callees)
+		for _, to := range callees {
+			a, _ := g.GetEdgeAttributes(from, to)
+			b, _ := json.Marshal(a)
+			attrs = append(attrs, fmt.Sprintf("edge:%s:%s:%s", from, to, b))
+		}
+	}
+
+	return ComputeFingerprint(policies, g.GetEdges(), services, sidecarAssignments, dataplanes, append([]interface{}{attrs}, inputs...)...)
+}
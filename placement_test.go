@@ -0,0 +1,107 @@
+package xPlane
+
+import (
+	"flag"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func createTestPlacement() *Placement {
+	return &Placement{
+		Dataplanes: []string{"istio", "cilium"},
+		Sidecars:   map[string]string{"frontend": "istio", "search": "cilium"},
+		Policies: []PolicyPlacement{
+			{Policy: 0, Context: []string{"frontend", "search"}, EnforcementPoints: []EnforcementPoint{
+				{Service: "frontend", Side: SENDER, Dataplane: "istio"},
+			}},
+			{Policy: 1, Context: []string{"frontend", "*", "geo"}, EnforcementPoints: []EnforcementPoint{
+				{Service: "search", Side: SENDER, Dataplane: "cilium"},
+			}},
+		},
+		Cost:        14,
+		Stats:       SolverStats{Solver: "z3", Status: "sat", Optimal: true, DurationMs: 12, NumVariables: 30},
+		Fingerprint: "abc",
+	}
+}
+
+func TestPlacementMarshalling(t *testing.T) {
+	flag.Parse()
+
+	p := createTestPlacement()
+	dir := t.TempDir()
+
+	for _, filename := range []string{"placement.json", "placement.yaml"} {
+		if err := WritePlacement(p, path.Join(dir, filename)); err != nil {
+			t.Fatalf("Error writing %s: %v", filename, err)
+		}
+
+		read, err := ReadPlacement(path.Join(dir, filename))
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", filename, err)
+		}
+
+		if !reflect.DeepEqual(p, read) {
+			t.Errorf("Placement changed after round trip through %s: %v vs %v", filename, p, read)
+		}
+	}
+
+	// Sides are written by name.
+	b, _ := p.ToJSON()
+	if !strings.Contains(string(b), `"side": "sender"`) {
+		t.Errorf("Expected sides to be marshalled by name, got %s", b)
+	}
+
+	if p.GetDataplaneIndex("search") != 1 || p.GetDataplaneIndex("geo") != -1 {
+		t.Errorf("Unexpected dataplane indexes: %v", p.GetSidecarAssignment())
+	}
+	if assignment := p.GetSidecarAssignment(); !reflect.DeepEqual(assignment, map[string]int{"frontend": 0, "search": 1}) {
+		t.Errorf("Unexpected assignment %v", assignment)
+	}
+}
+
+func TestComputeFingerprint(t *testing.T) {
+	flag.Parse()
+
+	pf := CreateNewPolicyFunction("SetHeader", SENDER, []int{0}, true)
+	policies := []Policy{CreatePolicy([]string{"A", "B"}, []PolicyFunction{pf})}
+	dataplanes := []Dataplane{CreateDataplane("istio", 0, 10, nil)}
+
+	f1 := ComputeFingerprint(policies, map[string][]string{"A": {"B", "C"}}, []string{"A", "B", "C"}, nil, dataplanes)
+	f2 := ComputeFingerprint(policies, map[string][]string{"A": {"C", "B"}}, []string{"C", "B", "A"}, map[string]int{}, dataplanes)
+	if f1 != f2 {
+		t.Errorf("Expected the fingerprint to ignore ordering")
+	}
+
+	f3 := ComputeFingerprint(policies, map[string][]string{"A": {"B", "C"}}, []string{"A", "B", "C"}, map[string]int{"A": 0}, dataplanes)
+	if f1 == f3 {
+		t.Errorf("Expected the fingerprint to depend on the existing sidecars")
+	}
+
+	f4 := ComputeFingerprint(policies, map[string][]string{"A": {"B", "C"}}, []string{"A", "B", "C"}, nil, dataplanes, 3)
+	if f1 == f4 || f4 == ComputeFingerprint(policies, map[string][]string{"A": {"B", "C"}}, []string{"A", "B", "C"}, nil, dataplanes, 4) {
+		t.Errorf("Expected the fingerprint to depend on the other inputs")
+	}
+
+	// The fingerprint of a graph depends on the attributes of its services and edges.
+	graph := func(replicas int, protocol Protocol) *ApplicationGraph {
+		g := NewApplicationGraph()
+		g.AddService("A", ServiceAttributes{Replicas: replicas})
+		g.AddEdge("A", "B", EdgeAttributes{Protocol: protocol})
+		return g
+	}
+	g1 := ComputeGraphFingerprint(policies, graph(1, PROTOCOL_HTTP), nil, dataplanes)
+	if g1 != ComputeGraphFingerprint(policies, graph(1, PROTOCOL_HTTP), nil, dataplanes) {
+		t.Errorf("Expected the same fingerprint for the same graph")
+	}
+	if g1 == ComputeGraphFingerprint(policies, graph(2, PROTOCOL_HTTP), nil, dataplanes) {
+		t.Errorf("Expected the fingerprint to depend on the replicas")
+	}
+	if g1 == ComputeGraphFingerprint(policies, graph(1, PROTOCOL_GRPC), nil, dataplanes) {
+		t.Errorf("Expected the fingerprint to depend on the protocols")
+	}
+	if g1 == ComputeGraphFingerprint(policies, graph(1, PROTOCOL_HTTP), nil, dataplanes, &Cluster{Nodes: map[string]NodeCapacity{"n": {}}}) {
+		t.Errorf("Expected the fingerprint to depend on the cluster")
+	}
+}
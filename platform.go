@@ -1,11 +1,12 @@
 package xPlane
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path"
 	"strings"
 
-	"github.com/buger/jsonparser"
 	"github.com/golang/glog"
 	"golang.org/x/exp/slices"
 )
@@ -19,13 +20,19 @@ type Platform struct {
 	services  []string
 	applGraph map[string][]string
 
-	// Registry of all available dataplane functions.
-	functionsRegistry map[string]map[string]PolicyFunction
+	// Registry of all available dataplanes and their functions.
+	registry *DataplaneRegistry
 
 	// All accepted policies.
 	policies []Policy
+
+	// Current placement of the accepted policies.
+	placement *Placement
 }
 
+// PlacementFunc computes the placement of the given policies, e.g. placement.GetPlacement.
+type PlacementFunc func(policies []Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []Dataplane) (*Placement, error)
+
 // Accessor methods for Platform struct.
 func (p *Platform) GetServices() []string {
 	return p.services
@@ -35,8 +42,16 @@ func (p *Platform) GetApplGraph() map[string][]string {
 	return p.applGraph
 }
 
-func (p *Platform) GetFunctionsRegistry() map[string]map[string]PolicyFunction {
-	return p.functionsRegistry
+func (p *Platform) GetPolicies() []Policy {
+	return p.policies
+}
+
+func (p *Platform) GetRegistry() *DataplaneRegistry {
+	return p.registry
+}
+
+func (p *Platform) GetDataplanes() []Dataplane {
+	return p.registry.GetDataplanes()
 }
 
 func (p *Platform) fillServicesFromGraph() {
@@ -54,9 +69,9 @@ func (p *Platform) fillServicesFromGraph() {
 
 func InitializePlatform(jsonDir string, applGraph map[string][]string) *Platform {
 	p := Platform{
-		jsonDir:           jsonDir,
-		functionsRegistry: make(map[string]map[string]PolicyFunction),
-		applGraph:         applGraph,
+		jsonDir:   jsonDir,
+		registry:  NewDataplaneRegistry(),
+		applGraph: applGraph,
 	}
 
 	// Construct the list of services.
@@ -65,9 +80,10 @@ func InitializePlatform(jsonDir string, applGraph map[string][]string) *Platform
 	return &p
 }
 
-// Add a new dataplane to the functionsRegistry map. Takes the dataplane json file name as input.
-// NOTE: Currently requires all functions of a dataplane to be unique.
-func (p *Platform) RegisterDataplane(dataplaneJson string) error {
+// Add a new dataplane to the registry. Takes the dataplane json file name and the cost of
+// running one instance of the dataplane as input. The dataplane is named after the file,
+// without the `.json` suffix, which is how policies import it.
+func (p *Platform) RegisterDataplane(dataplaneJson string, cost int) error {
 	// Read the json file from the jsonDir.
 	b, err := os.ReadFile(path.Join(p.jsonDir, dataplaneJson))
 	if err != nil {
@@ -75,182 +91,101 @@ func (p *Platform) RegisterDataplane(dataplaneJson string) error {
 		return err
 	}
 
-	// Get the functions from the json file.
-	groupObjects, _, _, err := jsonparser.Get(b, "groups")
+	// Decode the Copper IR and get the functions from its ActInterfaces.
+	copperFile, err := DecodeCopper(b)
 	if err != nil {
-		glog.Errorf("Error getting functions from json bytes: %v", err)
+		glog.Errorf("Error decoding dataplane %s: %v", dataplaneJson, err)
 		return err
 	}
 
-	// Iterate over the objects in the groups array and get the one which has a ActInterface.
-	var cnoObjects [][]byte
-	jsonparser.ArrayEach(groupObjects, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		// Get the ActInterface from the object.
-		cnoObject, _, _, e := jsonparser.Get(value, "inner", "Specification", "ActInterface")
-		if e != nil {
-			glog.Errorf("No ActInterface in object: %s", value)
-		}
-		cnoObjects = append(cnoObjects, cnoObject)
-	})
-
-	// Iterate over the ActInterfaces and get the functions.
-	var functions map[string]PolicyFunction
-	for _, cnoObject := range cnoObjects {
-		// Get the functions from the ActInterface.
-		functionsArray, _, _, err := jsonparser.Get(cnoObject, "fields")
-		if err != nil {
-			glog.Errorf("No functions in ActInterface: %s", cnoObject)
-		}
-
-		// Iterate over the functions array and add functions to the `functions` array.
-		jsonparser.ArrayEach(functionsArray, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-			// Get the function from the object.
-			functionName, _, _, e := jsonparser.Get(value, "Action", "name", "name")
-			if e != nil {
-				glog.Errorf("Could not find function name in: %s", value)
-			}
-
-			// Get the constraint from the object.
-			constraintObj, _, _, e := jsonparser.Get(value, "Action", "type_", "Function", "[0]", "self_")
-			if e != nil {
-				glog.Errorf("Could not find function constraint in: %s", value)
-			}
-
-			if constraintObj == nil {
-				glog.Info("No constraint found for function: %s", functionName)
-
-				pf := CreatePolicyFunction(string(functionName), SENDER_RECEIVER, false)
-				functions[string(functionName)] = pf
-			} else {
-				// Get the placement constraint from the object.
-				pConstraint, _, _, e := jsonparser.Get(constraintObj, "placement")
-				if e != nil {
-					glog.Errorf("Could not find function placement constraint in: %s", value)
-				}
-
-				placement := SENDER_RECEIVER
-				if string(pConstraint) == "In" {
-					placement = RECEIVER
-				} else if string(pConstraint) == "Out" {
-					placement = SENDER
-				}
-
-				// Get the mutable constraint from the object.
-				mConstraint, _, _, e := jsonparser.Get(constraintObj, "mutability")
-				if e != nil {
-					glog.Errorf("Could not find function mutable constraint in: %s", value)
-				}
-
-				pf := CreatePolicyFunction(string(functionName), placement, string(mConstraint) == "Mut")
-				functions[string(functionName)] = pf
-			}
-		})
+	functions, err := copperFile.GetFunctions()
+	if err != nil {
+		glog.Errorf("Error getting functions from dataplane %s: %v", dataplaneJson, err)
+		return err
 	}
 
-	// Add the functions to the functionsRegistry map.
-	p.functionsRegistry[dataplaneJson] = functions
+	// Add the dataplane to the registry.
+	index, err := p.registry.Register(strings.TrimSuffix(dataplaneJson, ".json"), cost, functions)
+	if err != nil {
+		glog.Errorf("Error registering dataplane %s: %v", dataplaneJson, err)
+		return err
+	}
+	glog.Infof("Registered dataplane %s with index %d", dataplaneJson, index)
 
 	return nil
 }
 
+// Declare the protocols a registered dataplane can parse. See DataplaneRegistry.SetProtocols.
+func (p *Platform) SetDataplaneProtocols(name string, protocols []Protocol) error {
+	return p.registry.SetProtocols(name, protocols)
+}
+
+// Declare the resources a registered dataplane uses. See DataplaneRegistry.SetResources.
+func (p *Platform) SetDataplaneResources(name string, resources ResourceProfile) error {
+	return p.registry.SetResources(name, resources)
+}
+
 // Parse a byte array of json file to get the policy struct.
 // Requires the dataplane json file to be named as `<filename>.m4.json`.
-func (p *Platform) ParsePolicy(b []byte) Policy {
-	// Get the context from the json file.
-	matchesArray, _, _, err := jsonparser.Get(b, "groups", "[0]", "inner", "Policy", "matches")
+func (p *Platform) ParsePolicy(b []byte) (Policy, error) {
+	copperFile, err := DecodeCopper(b)
 	if err != nil {
-		glog.Errorf("Error getting context from json bytes: %v", err)
+		return Policy{}, err
 	}
 
-	// Iterate over the objects in the matches array and get the one which has the context.
-	var contextObject []byte
-	found := false
-	jsonparser.ArrayEach(matchesArray, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		// Get the context from the object.
-		object, _, _, e := jsonparser.Get(value, "Context")
-		if e == nil {
-			// TODO: Assumption is that there is only one context object.
-			contextObject = object
-			found = true
-		}
-	})
-
-	if !found {
-		glog.Errorf("No context found in policy: %s", matchesArray)
+	copperPolicy, policyPath, err := copperFile.GetPolicy()
+	if err != nil {
+		return Policy{}, err
 	}
 
-	// Get the list of endpoints from the context object.
-	endpointsArray, _, _, err := jsonparser.Get(contextObject, "blocks")
+	// Get the context from the policy.
+	context, err := copperPolicy.GetContext(policyPath)
 	if err != nil {
-		glog.Errorf("No blocks in context: %s", contextObject)
+		return Policy{}, err
 	}
+	glog.Infof("Context: %s", context)
 
-	// Iterate over the endpoints array and get the endpoints.
-	var context []string
-	jsonparser.ArrayEach(endpointsArray, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		// Get the endpoint from the object.
-		endpointBlock, _, _, e := jsonparser.Get(value, "inner", "Endpoints")
-		if e != nil {
-			glog.Errorf("No endpoints in object: %s", value)
-		}
+	// Get the dataplanes the functions are imported from.
+	if len(copperFile.Imports) == 0 {
+		return Policy{}, copperErrorf("imports", "no dataplane imported")
+	}
 
-		// Iterate over the endpoints array and get the endpoint.
-		var serviceSet []string
-		jsonparser.ArrayEach(endpointBlock, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-			endpoint, _, _, e := jsonparser.Get(value, "name")
-			if e != nil {
-				glog.Errorf("Service name missing in object: %s", value)
-			}
-			serviceSet = append(serviceSet, string(endpoint))
-		})
-
-		// Append the service set to the context.
-		if len(serviceSet) == 1 {
-			context = append(context, serviceSet[0])
-		} else {
-			context = append(context, "["+strings.Join(serviceSet, ",")+"]")
+	var importedDataplanes []string
+	for i, imp := range copperFile.Imports {
+		importPath := fmt.Sprintf("imports[%d].path", i)
+		if imp.Path == "" {
+			return Policy{}, copperErrorf(importPath, "missing import path")
 		}
-	})
-	glog.Infof("Context: %s", context)
 
-	// Get the functions from the json file.
-	// NOTE: Currently assumes only a single import.
-	pathObj, _, _, err := jsonparser.Get(b, "imports", "[0]", "path")
-	if err != nil {
-		glog.Errorf("No dataplane name in json bytes: %v", err)
+		dataplaneName := imp.Path
+		if _, ok := p.registry.GetDataplane(dataplaneName); !ok {
+			return Policy{}, copperErrorf(importPath, "dataplane %s is not registered", dataplaneName)
+		}
+		importedDataplanes = append(importedDataplanes, dataplaneName)
 	}
-	dataplaneName := string(pathObj) + ".json"
 
 	// Get the list of functions being used in the policy.
-	functionsList, _, _, err := jsonparser.Get(b, "groups", "[0]", "inner", "Policy", "used_abstract_fields")
+	refs, err := copperPolicy.GetUsedFunctions(policyPath)
 	if err != nil {
-		glog.Errorf("Functions list not found in json bytes: %v", err)
+		return Policy{}, err
 	}
 
-	// Iterate over the functions list and get the functions.
 	var functions []PolicyFunction
-	jsonparser.ArrayEach(functionsList, func(value []byte, dataType jsonparser.ValueType, offset int, err error) {
-		// Get the function from the object.
-		jsonparser.ArrayEach(value, func(valueInner []byte, dataTypeInner jsonparser.ValueType, offsetInner int, errInner error) {
-			obj, _, _, e := jsonparser.Get(valueInner, "set")
-			if e == nil {
-				functionName, _, _, e := jsonparser.Get(obj, "[0]")
-				if e != nil {
-					glog.Errorf("Function name missing in object: %s", valueInner)
-				}
-
-				// Get the function from the functionsRegistry.
-				pf := p.functionsRegistry[dataplaneName][string(functionName)]
-				functions = append(functions, pf)
-			}
-		})
-	})
+	for _, ref := range refs {
+		pf, err := p.registry.ResolveFunction(ref.Name, importedDataplanes)
+		if err != nil {
+			return Policy{}, &CopperError{Path: ref.Path, Err: err}
+		}
+		functions = append(functions, pf)
+	}
 
-	return CreatePolicy(context, functions)
+	return CreatePolicy(context, functions), nil
 }
 
 // Submit a list of policy json files to the platform.
+// Either all policies are accepted, or none are.
 func (p *Platform) SubmitPolicy(policyJsons []string) error {
+	var policies []Policy
 	for _, pJson := range policyJsons {
 		// Read the json file from the jsonDir.
 		b, err := os.ReadFile(path.Join(p.jsonDir, pJson))
@@ -260,28 +195,83 @@ func (p *Platform) SubmitPolicy(policyJsons []string) error {
 		}
 
 		// Parse the json file to get the policy struct.
-		policy := p.ParsePolicy(b)
-		p.policies = append(p.policies, policy)
+		policy, err := p.ParsePolicy(b)
+		if err != nil {
+			glog.Errorf("Error parsing policy %s: %v", pJson, err)
+			return fmt.Errorf("%s: %w", pJson, err)
+		}
+		policies = append(policies, policy)
+	}
+
+	p.policies = append(p.policies, policies...)
+
+	return nil
+}
+
+// Compute the placement of all accepted policies on the registered dataplanes, using the given solver.
+// The result is stored as the current placement of the platform.
+func (p *Platform) ComputePlacement(solve PlacementFunc) error {
+	dataplanes := p.registry.GetDataplanes()
+	if len(dataplanes) == 0 {
+		return errors.New("no dataplanes registered")
+	}
+
+	// No policies, no sidecars.
+	if len(p.policies) == 0 {
+		p.placement = &Placement{
+			Sidecars:    make(map[string]string),
+			Fingerprint: ComputeFingerprint(nil, p.applGraph, p.services, nil, dataplanes),
+		}
+		for _, d := range dataplanes {
+			p.placement.Dataplanes = append(p.placement.Dataplanes, d.GetName())
+		}
+		return nil
+	}
+
+	// Placement is computed from scratch over all policies, so existing sidecars are not pinned.
+	sidecarAssignment := make(map[string]int)
+	placement, err := solve(p.policies, p.applGraph, p.services, sidecarAssignment, dataplanes)
+	if err != nil {
+		glog.Errorf("Error computing placement: %v", err)
+		return err
 	}
 
+	p.placement = placement
+
 	return nil
 }
 
+// Get the current placement, or nil if ComputePlacement has not succeeded yet.
+func (p *Platform) GetCurrentPlacement() *Placement {
+	return p.placement
+}
+
+// Get the dataplane assigned to every service that has a sidecar in the current placement.
+func (p *Platform) GetSidecarAssignment() map[string]Dataplane {
+	assignment := make(map[string]Dataplane)
+	if p.placement == nil {
+		return assignment
+	}
+
+	for svc, name := range p.placement.Sidecars {
+		if d, ok := p.registry.GetDataplane(name); ok {
+			assignment[svc] = *d
+		}
+	}
+	return assignment
+}
+
 // Get a list of boolean values indicating whether a service has a sidecar or not.
+// Answers from the placement computed by ComputePlacement.
 func (p *Platform) GetServicesWithSidecars() []bool {
 	hasSidecar := make([]bool, len(p.services))
-	for i := range hasSidecar {
-		hasSidecar[i] = false
+	if p.placement == nil {
+		return hasSidecar
 	}
 
-	// Iterate over the policies and set the hasSidecar value to true for the services in the policy.
-	for _, policy := range p.policies {
-		svc := policy.GetPlacement()
-		// Get the index of the service in the services array.
-		for i, service := range p.services {
-			if service == svc {
-				hasSidecar[i] = true
-			}
+	for i, service := range p.services {
+		if _, ok := p.placement.Sidecars[service]; ok {
+			hasSidecar[i] = true
 		}
 	}
 
@@ -0,0 +1,67 @@
+package xPlane
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestComputePlacement(t *testing.T) {
+	flag.Parse()
+
+	p := setupPlatform(t)
+	if err := os.WriteFile(path.Join(p.jsonDir, "policy.json"), []byte(testPolicyJson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SubmitPolicy([]string{"policy.json"}); err != nil {
+		t.Fatalf("Error submitting policy: %v", err)
+	}
+
+	// A solver that places the only dataplane on the receivers, and checks what it is given.
+	solve := func(policies []Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []Dataplane) (*Placement, error) {
+		if len(policies) != 1 || len(dataplanes) != 1 || dataplanes[0].GetCost() != 10 {
+			t.Errorf("Unexpected solver input: %v %v", policies, dataplanes)
+		}
+		return &Placement{
+			Dataplanes: []string{"rpc.m4"},
+			Sidecars:   map[string]string{"search": "rpc.m4", "reserve": "rpc.m4"},
+			Policies: []PolicyPlacement{{Policy: 0, Context: policies[0].GetContext(), EnforcementPoints: []EnforcementPoint{
+				{Service: "search", Side: RECEIVER, Dataplane: "rpc.m4"},
+				{Service: "reserve", Side: RECEIVER, Dataplane: "rpc.m4"},
+			}}},
+			Cost: 20,
+		}, nil
+	}
+
+	if err := p.ComputePlacement(solve); err != nil {
+		t.Fatalf("Error computing placement: %v", err)
+	}
+
+	hasSidecar := p.GetServicesWithSidecars()
+	for i, svc := range p.GetServices() {
+		if hasSidecar[i] != (svc != "frontend") {
+			t.Errorf("Unexpected sidecar for %s: %v", svc, hasSidecar[i])
+		}
+	}
+
+	assignment := p.GetSidecarAssignment()
+	if d, ok := assignment["search"]; !ok || d.GetName() != "rpc.m4" {
+		t.Errorf("Expected search to get dataplane rpc.m4, got %v", assignment)
+	}
+	if _, ok := assignment["frontend"]; ok {
+		t.Errorf("Expected frontend to have no dataplane")
+	}
+
+	// A solver that finds nothing leaves the previous placement untouched.
+	failing := func(policies []Policy, applGraph map[string][]string, services []string, sidecarAssignments map[string]int, dataplanes []Dataplane) (*Placement, error) {
+		return nil, errors.New("unsat")
+	}
+	if err := p.ComputePlacement(failing); err == nil {
+		t.Errorf("Expected an error when no placement is found")
+	}
+	if len(p.GetSidecarAssignment()) != 2 {
+		t.Errorf("Expected the previous placement to be kept")
+	}
+}
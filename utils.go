@@ -1,6 +1,8 @@
 package xPlane
 
 import (
+	"fmt"
+
 	"golang.org/x/exp/slices"
 )
 
@@ -12,6 +14,33 @@ const (
 	SENDER_RECEIVER
 )
 
+var constraintNames = []string{"sender", "receiver", "sender_receiver"}
+
+func (c ConstraintType) String() string {
+	if int(c) < 0 || int(c) >= len(constraintNames) {
+		return fmt.Sprintf("ConstraintType(%d)", int(c))
+	}
+	return constraintNames[c]
+}
+
+// Constraints are marshalled by name, so that placements written to JSON or YAML are readable.
+func (c ConstraintType) MarshalText() ([]byte, error) {
+	if int(c) < 0 || int(c) >= len(constraintNames) {
+		return nil, fmt.Errorf("invalid constraint %d", int(c))
+	}
+	return []byte(constraintNames[c]), nil
+}
+
+func (c *ConstraintType) UnmarshalText(text []byte) error {
+	for i, name := range constraintNames {
+		if name == string(text) {
+			*c = ConstraintType(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid constraint %q", string(text))
+}
+
 type PolicyFunction struct {
 	functionName string
 	constraint   ConstraintType
@@ -79,6 +108,10 @@ func (p *Policy) GetFunctions() []PolicyFunction {
 }
 
 func (p *Policy) GetDataplanes() []int {
+	if len(p.functions) == 0 {
+		return nil
+	}
+
 	dataplanes := map[int]bool{}
 
 	// Add dataplanes of the first function.
@@ -101,6 +134,7 @@ func (p *Policy) GetDataplanes() []int {
 	for d := range dataplanes {
 		dataplanesArray = append(dataplanesArray, d)
 	}
+	slices.Sort(dataplanesArray)
 
 	return dataplanesArray
 }